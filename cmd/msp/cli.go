@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"msp/internal/config"
+	"msp/internal/db"
+	"msp/internal/paths"
+	"msp/internal/pinhash"
+	"msp/internal/server"
+	"msp/internal/types"
+	"msp/internal/util"
+)
+
+// version is set at build time with -ldflags "-X main.version=...".
+var version = "dev"
+
+const usageText = `用法: msp [选项] [命令]
+
+命令:
+  serve              启动服务器（默认）
+  scan               扫描所有共享目录、更新索引后退出
+  config validate    检查配置文件是否有效
+  config show        显示生效的配置（不含密钥）
+  config convert FILE
+                     将配置文件转换为 FILE 的格式（.json/.yaml/.toml）
+  pin set [PIN]      设置访问 PIN（省略时从标准输入读取）
+  pin set-admin [PIN]
+                     设置管理员凭据
+  db vacuum          压缩数据库文件
+  version            显示版本
+
+选项:
+`
+
+// errBadFlags means the flag set has already printed a parse error.
+var errBadFlags = errors.New("invalid flags")
+
+// options are the global command-line flags.
+type options struct {
+	configPath string
+	dataDir    string
+	portable   bool
+	port       int
+	listen     string
+	noBrowser  bool
+
+	dirs      paths.Dirs // resolved from dataDir, MSP_HOME and portable
+	configSet bool       // --config was given
+}
+
+func (o options) dbPath() string {
+	return o.dirs.DBPath()
+}
+
+// parseArgs parses flags, which may appear before or after the command, and
+// returns the remaining positional arguments.
+func parseArgs(args []string, stderr io.Writer) (options, []string, error) {
+	var o options
+	fs := flag.NewFlagSet("msp", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&o.configPath, "config", "", "配置文件路径（默认：<data-dir>/config.json）")
+	fs.StringVar(&o.dataDir, "data-dir", "", "数据目录，存放配置、数据库、日志和证书（默认：$MSP_HOME 或系统用户目录）")
+	fs.BoolVar(&o.portable, "portable", false, "便携模式：所有文件保存在可执行文件所在目录")
+	fs.IntVar(&o.port, "port", 0, "监听端口，覆盖配置中的 port")
+	fs.StringVar(&o.listen, "listen", "", "监听地址，如 127.0.0.1 或 0.0.0.0:8099（默认：所有地址）")
+	fs.BoolVar(&o.noBrowser, "no-browser", false, "启动时不自动打开浏览器")
+	fs.Usage = func() {
+		_, _ = fmt.Fprint(stderr, usageText)
+		fs.PrintDefaults()
+	}
+
+	var rest []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return o, nil, err
+			}
+			return o, nil, errBadFlags // already reported by fs
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		rest = append(rest, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+
+	if o.listen != "" {
+		if host, port, err := net.SplitHostPort(o.listen); err == nil {
+			p, err := strconv.Atoi(port)
+			if err != nil || p <= 0 || p > 65535 {
+				return o, nil, fmt.Errorf("--listen: invalid port %q", port)
+			}
+			o.listen = host
+			if o.port == 0 {
+				o.port = p
+			}
+		}
+		o.listen = strings.Trim(o.listen, "[]")
+	}
+	if o.port < 0 || o.port > 65535 {
+		return o, nil, fmt.Errorf("--port: out of range")
+	}
+	dirs, err := paths.Resolve(o.dataDir, o.portable, util.MustExeDir())
+	if err != nil {
+		return o, nil, err
+	}
+	o.dirs = dirs
+	o.dataDir = dirs.Data
+	o.configSet = o.configPath != ""
+	if o.configPath == "" {
+		o.configPath = dirs.ConfigPath()
+	}
+	return o, rest, nil
+}
+
+// run executes the command line and returns the process exit code.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	o, rest, err := parseArgs(args, stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		if !errors.Is(err, errBadFlags) {
+			_, _ = fmt.Fprintln(stderr, "错误:", err)
+		}
+		return 2
+	}
+
+	cmd := strings.Join(rest, " ")
+	switch cmd {
+	case "version":
+		_, _ = fmt.Fprintln(stdout, versionString())
+		return 0
+	case "help":
+		_, _ = fmt.Fprint(stdout, usageText)
+		return 0
+	}
+
+	migrateLegacyFiles(o, stderr)
+	if cmd == "" || cmd == "serve" {
+		serve(o)
+		return 0
+	}
+
+	switch rest[0] {
+	case "scan":
+		err = runScan(o, stdout)
+	case "config":
+		err = runConfig(o, rest[1:], stdout)
+	case "pin":
+		err = runPIN(o, rest[1:], stdin, stdout, stderr)
+	case "db":
+		err = runDB(o, rest[1:], stdout)
+	default:
+		err = fmt.Errorf("未知命令 %q，运行 msp -h 查看帮助", cmd)
+	}
+	if err != nil {
+		_, _ = fmt.Fprintln(stderr, "错误:", err)
+		return 1
+	}
+	return 0
+}
+
+// migrateLegacyFiles moves config, database, logs and certificates that
+// older versions kept beside the executable into the data directories. An
+// explicit --config leaves everything where it is.
+func migrateLegacyFiles(o options, stderr io.Writer) {
+	if o.configSet {
+		return
+	}
+	exeDir := util.MustExeDir()
+	moved, err := paths.MigrateLegacy(exeDir, o.dirs, o.configPath)
+	if err != nil {
+		_, _ = fmt.Fprintln(stderr, "警告: 迁移旧数据文件失败:", err)
+	}
+	if len(moved) == 0 {
+		return
+	}
+	_, _ = fmt.Fprintf(stderr, "已将 %s 中的旧数据文件迁移到:\n", exeDir)
+	for _, p := range moved {
+		_, _ = fmt.Fprintln(stderr, "  ", p)
+	}
+}
+
+func versionString() string {
+	return fmt.Sprintf("msp %s (%s %s/%s)", buildVersion(), runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
+
+// buildVersion returns version, with the VCS revision appended to dev builds.
+func buildVersion() string {
+	v := version
+	if info, ok := debug.ReadBuildInfo(); ok && v == "dev" {
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" && len(s.Value) >= 7 {
+				v += "+" + s.Value[:7]
+			}
+		}
+	}
+	return v
+}
+
+// readConfigFile decodes the config file in the format of its extension.
+// Older files are upgraded in memory only; the server rewrites them.
+func readConfigFile(path string) (config.Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return config.Config{}, err
+	}
+	cfg, _, _, err := config.Load(b, config.FormatOf(path))
+	if err != nil {
+		return config.Config{}, fmt.Errorf("%s: %w", path, err)
+	}
+	config.ApplyDefaults(&cfg)
+	return cfg, nil
+}
+
+// readConfig loads the config file and applies environment overrides
+// without creating or rewriting the file.
+func readConfig(path string) (config.Config, error) {
+	cfg, err := readConfigFile(path)
+	if err != nil {
+		return config.Config{}, err
+	}
+	env, err := config.ParseEnv(os.Environ())
+	if err != nil {
+		return config.Config{}, err
+	}
+	if err := env.Apply(&cfg); err != nil {
+		return config.Config{}, err
+	}
+	config.ApplyDefaults(&cfg)
+	return cfg, nil
+}
+
+func runConfig(o options, args []string, stdout io.Writer) error {
+	if len(args) == 2 && args[0] == "convert" {
+		return convertConfig(o.configPath, args[1], stdout)
+	}
+	if len(args) != 1 {
+		return errors.New("用法: msp config validate|show|convert FILE")
+	}
+	cfg, err := readConfig(o.configPath)
+	if err == nil && args[0] == "validate" {
+		err = config.Validate(cfg)
+		if err != nil {
+			err = fmt.Errorf("%s: %w", o.configPath, err)
+		}
+		for _, f := range config.FieldErrors(config.ValidateShares(cfg.Shares, nil)) {
+			_, _ = fmt.Fprintln(stdout, "警告:", f.Error())
+		}
+	}
+	if fields := config.FieldErrors(err); fields != nil {
+		for _, f := range fields {
+			_, _ = fmt.Fprintln(stdout, f.Error())
+		}
+		return fmt.Errorf("%s: %d 个字段无效", o.configPath, len(fields))
+	}
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "validate":
+		_, _ = fmt.Fprintln(stdout, "配置有效:", o.configPath)
+		return nil
+	case "show":
+		b, err := json.MarshalIndent(cfg.Redacted(), "", "  ")
+		if err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintln(stdout, string(b))
+		return nil
+	}
+	return fmt.Errorf("未知命令 config %s", args[0])
+}
+
+// convertConfig writes the config file at from to the new file to, in the
+// format of to's extension. Environment overrides are not included.
+func convertConfig(from, to string, stdout io.Writer) error {
+	if _, err := os.Stat(to); err == nil {
+		return fmt.Errorf("%s 已存在", to)
+	}
+	cfg, err := readConfigFile(from)
+	if err != nil {
+		return err
+	}
+	b, err := config.Encode(cfg, config.FormatOf(to), nil)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(to, b, 0600); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(stdout, "已写入 %s（%s）。请删除或重命名 %s，或用 --config 指定新文件\n", to, config.FormatOf(to), from)
+	return nil
+}
+
+// runPIN sets the viewer PIN or admin credential and signs out all sessions.
+func runPIN(o options, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if len(args) < 1 || len(args) > 2 || (args[0] != "set" && args[0] != "set-admin") {
+		return errors.New("用法: msp pin set|set-admin [PIN]")
+	}
+	pin := ""
+	if len(args) == 2 {
+		pin = args[1]
+	} else {
+		_, _ = fmt.Fprint(stderr, "新 PIN: ")
+		line, err := bufio.NewReader(stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		pin = line
+	}
+	pin = strings.TrimSpace(pin)
+	if pin == "" {
+		return errors.New("PIN 不能为空")
+	}
+	hash, err := pinhash.Hash(pin)
+	if err != nil {
+		return err
+	}
+
+	s := server.New(o.configPath)
+	if err := s.LoadOrInitConfig(); err != nil {
+		return err
+	}
+	field, role := "security.pinHash", "viewer"
+	if args[0] == "set-admin" {
+		field = "security.adminPinHash"
+	}
+	if v := s.EnvVar(field); v != "" {
+		return fmt.Errorf("PIN 由环境变量 %s 设置，请修改该变量", v)
+	}
+	if err := s.UpdateConfig(func(cfg *config.Config) {
+		if args[0] == "set-admin" {
+			role = "admin"
+			cfg.Security.AdminPIN = ""
+			cfg.Security.AdminPINHash = hash
+		} else {
+			cfg.Security.PIN = ""
+			cfg.Security.PINHash = hash
+		}
+	}); err != nil {
+		return err
+	}
+
+	if err := db.Init(o.dbPath()); err == nil {
+		defer db.Close()
+		n, _ := db.DeleteSessionsExcept(context.Background(), "")
+		s.Audit(types.AuditEvent{Type: types.AuditPINChange, Actor: "cli", Detail: "role=" + role})
+		_, _ = fmt.Fprintf(stdout, "已更新 %s PIN，已注销 %d 个会话\n", role, n)
+		return nil
+	}
+	_, _ = fmt.Fprintf(stdout, "已更新 %s PIN\n", role)
+	return nil
+}
+
+func runDB(o options, args []string, stdout io.Writer) error {
+	if len(args) != 1 || args[0] != "vacuum" {
+		return errors.New("用法: msp db vacuum")
+	}
+	path := o.dbPath()
+	before, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if err := db.Init(path); err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := db.Vacuum(context.Background()); err != nil {
+		return err
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(stdout, "数据库已压缩: %s (%d KB -> %d KB)\n", path, before.Size()/1024, after.Size()/1024)
+	return nil
+}
+
+// runScan indexes all shares once and exits.
+func runScan(o options, stdout io.Writer) error {
+	s := server.New(o.configPath)
+	s.SetDataDir(o.dataDir)
+	s.SetCacheDir(o.dirs.Cache)
+	if err := s.LoadOrInitConfig(); err != nil {
+		return err
+	}
+	if err := db.Init(o.dbPath()); err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// Ctrl-C rolls the scan back instead of killing it mid-write.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	cfg := s.Config()
+	resp := s.RebuildMediaCache(ctx)
+	if ctx.Err() != nil {
+		return errors.New("扫描已取消")
+	}
+	_, _ = fmt.Fprintf(stdout, "扫描完成: %d 个共享目录，视频 %d，音频 %d，图片 %d，其他 %d\n",
+		len(cfg.Shares), len(resp.Videos), len(resp.Audios), len(resp.Images), len(resp.Others))
+	return nil
+}
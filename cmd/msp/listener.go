@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"msp/internal/util"
+)
+
+// listener serves the app on one port at a time and can move to another
+// port while running.
+type listener struct {
+	host    string
+	handler http.Handler
+	tls     *tls.Config     // nil for plain HTTP
+	base    context.Context // parent of every request context
+	errc    chan error      // serve failures other than a closed server
+
+	mu       sync.Mutex
+	srv      *http.Server
+	port     int
+	draining map[*http.Server]bool // previous servers finishing their requests
+}
+
+// newListener returns a listener for host. Cancelling base cancels all
+// requests, which stops their transcodes.
+func newListener(base context.Context, host string, h http.Handler, tlsCfg *tls.Config) *listener {
+	return &listener{host: host, handler: h, tls: tlsCfg, base: base, errc: make(chan error, 1), draining: map[*http.Server]bool{}}
+}
+
+// Listen binds port and serves on it. A previously bound port is released
+// once its open requests finish (at most 30 seconds). If port cannot be
+// bound the old one stays in use.
+func (l *listener) Listen(port int) error {
+	ln, err := net.Listen("tcp", net.JoinHostPort(l.host, util.Itoa(port)))
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{
+		Handler:           l.handler,
+		TLSConfig:         l.tls,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       15 * time.Second,
+		IdleTimeout:       60 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return l.base },
+		// WriteTimeout is intentionally omitted to support long-running media streams
+	}
+	l.mu.Lock()
+	old := l.srv
+	l.srv, l.port = srv, port
+	if old != nil {
+		l.draining[old] = true
+	}
+	l.mu.Unlock()
+
+	go func() {
+		var err error
+		if l.tls != nil {
+			err = srv.ServeTLS(ln, "", "")
+		} else {
+			err = srv.Serve(ln)
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			select {
+			case l.errc <- err:
+			default:
+			}
+		}
+	}()
+	if old != nil {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			_ = old.Shutdown(ctx)
+			l.mu.Lock()
+			delete(l.draining, old)
+			l.mu.Unlock()
+		}()
+	}
+	return nil
+}
+
+// servers returns the current server and those still draining.
+func (l *listener) servers() []*http.Server {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var out []*http.Server
+	if l.srv != nil {
+		out = append(out, l.srv)
+	}
+	for srv := range l.draining {
+		out = append(out, srv)
+	}
+	return out
+}
+
+// Port returns the port currently served.
+func (l *listener) Port() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.port
+}
+
+// Close closes the remaining connections.
+func (l *listener) Close() {
+	for _, srv := range l.servers() {
+		_ = srv.Close()
+	}
+}
+
+// shutdownServers shuts srvs down in parallel until ctx is done. Nil
+// entries are skipped.
+func shutdownServers(ctx context.Context, srvs ...*http.Server) error {
+	errs := make([]error, len(srvs))
+	var wg sync.WaitGroup
+	for i, srv := range srvs {
+		if srv == nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = srv.Shutdown(ctx)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
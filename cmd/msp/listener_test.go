@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"msp/internal/util"
+)
+
+func freePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = l.Close() }()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestListenerRebind(t *testing.T) {
+	ln := newListener(context.Background(), "127.0.0.1", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}), nil)
+	get := func(port int) error {
+		c := http.Client{Timeout: time.Second, Transport: &http.Transport{DisableKeepAlives: true}}
+		res, err := c.Get("http://127.0.0.1:" + util.Itoa(port) + "/")
+		if err == nil {
+			_ = res.Body.Close()
+		}
+		return err
+	}
+
+	p1, p2 := freePort(t), freePort(t)
+	if err := ln.Listen(p1); err != nil {
+		t.Fatal(err)
+	}
+	if err := get(p1); err != nil {
+		t.Fatal(err)
+	}
+	if err := ln.Listen(p2); err != nil {
+		t.Fatal(err)
+	}
+	if err := get(p2); err != nil || ln.Port() != p2 {
+		t.Fatalf("new port: %v (port %d)", err, ln.Port())
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	for get(p1) == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("old port still served")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// A port in use keeps the current one.
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = busy.Close() }()
+	if err := ln.Listen(busy.Addr().(*net.TCPAddr).Port); err == nil || ln.Port() != p2 {
+		t.Errorf("busy port: err %v, port %d", err, ln.Port())
+	}
+}
@@ -2,10 +2,12 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io/fs"
 	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
@@ -13,8 +15,11 @@ import (
 	"path/filepath"
 	"runtime"
 	"runtime/debug"
+	"strings"
 	"time"
 
+	"msp/internal/certs"
+	"msp/internal/config"
 	"msp/internal/db"
 	"msp/internal/handler"
 	"msp/internal/server"
@@ -24,12 +29,27 @@ import (
 )
 
 func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// serve runs the HTTP server until it is stopped by a signal or fails.
+func serve(o options) {
 	debug.SetGCPercent(50) // Aggressive GC to keep memory low
 	log.SetFlags(log.LstdFlags | log.Lmicroseconds)
 
-	cfgPath := filepath.Join(util.MustExeDir(), "config.json")
+	cfgPath := o.configPath
+
+	// bgCtx stops scans and watchers at once on shutdown; reqCtx stops
+	// requests and their transcodes after they had time to finish.
+	bgCtx, cancelBackground := context.WithCancel(context.Background())
+	reqCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
 
 	s := server.New(cfgPath)
+	s.SetContext(bgCtx)
+	s.SetDataDir(o.dataDir)
+	s.SetVersion(buildVersion())
+	s.SetCacheDir(o.dirs.Cache)
 
 	if err := s.LoadOrInitConfig(); err != nil {
 		log.Fatal(err)
@@ -38,17 +58,17 @@ func main() {
 	s.SetupLogger()
 
 	// Init DB (after logger setup to avoid noisy terminal logs from GORM)
-	dbPath := filepath.Join(util.MustExeDir(), "msp.db")
-	if err := db.Init(dbPath); err != nil {
-		log.Printf("Warning: Failed to initialize database: %v", err)
+	if err := db.Init(o.dbPath()); err != nil {
+		slog.Warn("Failed to initialize database", "err", err)
 	}
-	defer db.Close()
 
 	// Start config file watcher for hot reload
-	go s.WatchConfig(context.Background())
+	go s.WatchConfig(bgCtx)
 
 	// Trigger first scan in background early
-	go s.GetOrBuildMediaCache(context.Background(), s.Config().Shares, s.Config().Blacklist, false)
+	s.Go(func(ctx context.Context) {
+		s.GetOrBuildMediaCache(ctx, s.Config().Shares, s.Config().Blacklist, false)
+	})
 
 	webRoot, err := fs.Sub(webassets.FS, "dist")
 	if err != nil {
@@ -58,28 +78,127 @@ func main() {
 	mux := registerRoutes(s, webRoot)
 
 	port := s.GetPort()
-	addr := ":" + util.Itoa(port)
+	if o.port > 0 {
+		port = o.port
+	}
 
-	printStartupBanner(cfgPath, port)
+	tlsCfg := s.Config().TLS
+	var reloader *certs.Reloader
+	caFile := ""
+	if tlsCfg.Enabled {
+		reloader, caFile, err = setupTLS(tlsCfg, o.dataDir)
+		if err != nil {
+			fatal("TLS setup failed", err)
+		}
+		go reloader.Watch(bgCtx, 5*time.Second, func(err error) {
+			if err != nil {
+				slog.Error("Failed to reload TLS certificate", "err", err)
+				return
+			}
+			slog.Info("TLS certificate reloaded", "sha256", reloader.Fingerprint())
+		})
+	}
 
-	finalHandler := handler.WithLog(s, handler.WithSecurity(s, handler.WithGzip(mux)))
+	finalHandler := handler.WithLog(s, handler.WithSecurity(s, handler.WithGzip(handler.WithRoute(mux))))
 
-	server := &http.Server{
-		Addr:              addr,
-		Handler:           finalHandler,
-		ReadHeaderTimeout: 10 * time.Second,
-		ReadTimeout:       15 * time.Second,
-		IdleTimeout:       60 * time.Second,
-		// WriteTimeout is intentionally omitted to support long-running media streams
+	var serverTLS *tls.Config
+	if reloader != nil {
+		serverTLS = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: reloader.GetCertificate,
+		}
+	}
+	ln := newListener(reqCtx, o.listen, finalHandler, serverTLS)
+	s.SetListener(o.listen, reloader != nil, ln.Port)
+	if err := ln.Listen(port); err != nil {
+		fatal("Cannot listen", err)
+	}
+	printStartupBanner(cfgPath, o.dataDir, s.URLs(), reloader, caFile)
+	var redirect *http.Server
+	if reloader != nil && tlsCfg.RedirectPort > 0 {
+		redirect = serveHTTPSRedirect(tlsCfg.RedirectPort, ln.Port)
 	}
 
-	if os.Getenv("MSP_NO_AUTO_OPEN") != "1" {
-		go tryAutoOpenBrowser(port)
+	// --port pins the port; otherwise follow config changes.
+	if o.port == 0 {
+		s.OnConfigChange(func(old, cfg config.Config) {
+			if cfg.Port == old.Port || cfg.Port == ln.Port() {
+				return
+			}
+			if err := ln.Listen(cfg.Port); err != nil {
+				slog.Error("Cannot move to new port", "port", cfg.Port, "current", ln.Port(), "err", err)
+				return
+			}
+			slog.Info("Now listening on new port", "port", cfg.Port)
+		})
 	}
 
-	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-		log.Fatal(err)
+	if !o.noBrowser && os.Getenv("MSP_NO_AUTO_OPEN") != "1" {
+		go tryAutoOpenBrowser(o.listen, port, reloader != nil)
+	}
+
+	if err := waitForSignals(s, ln, redirect, cancelBackground, cancelRequests); err != nil {
+		db.Close()
+		fatal("Server failed", err)
+	}
+}
+
+// fatal logs err and exits. Unlike log.Fatal it logs at error level, and it
+// also prints to stderr, which the log file may have replaced.
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	fmt.Fprintf(os.Stderr, "%s: %v\n", msg, err)
+	os.Exit(1)
+}
+
+// setupTLS returns a reloader for the configured certificate, generating a
+// local CA and server certificate if none is configured. caFile is the CA
+// clients should trust, empty for a user-supplied certificate.
+func setupTLS(tc config.TLSConfig, dataDir string) (reloader *certs.Reloader, caFile string, err error) {
+	certFile, keyFile := tc.CertFile, tc.KeyFile
+	if certFile == "" {
+		dir := filepath.Join(dataDir, "certs")
+		hosts := append([]string{"localhost", "127.0.0.1", "::1"}, util.GetLanIPv4s()...)
+		if h, err := os.Hostname(); err == nil && h != "" {
+			hosts = append(hosts, strings.ToLower(h))
+		}
+		certFile, keyFile, err = certs.EnsureAutoCert(dir, hosts)
+		if err != nil {
+			return nil, "", err
+		}
+		caFile = filepath.Join(dir, certs.CAFile)
 	}
+	reloader, err = certs.NewReloader(certFile, keyFile)
+	return reloader, caFile, err
+}
+
+// serveHTTPSRedirect serves plain HTTP on redirectPort in the background
+// and sends every request to the HTTPS listener on httpsPort(). The
+// returned server is stopped on shutdown.
+func serveHTTPSRedirect(redirectPort int, httpsPort func() int) *http.Server {
+	srv := &http.Server{
+		Addr:              ":" + util.Itoa(redirectPort),
+		Handler:           httpsRedirectHandler(httpsPort),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("HTTP redirect listener failed", "err", err)
+		}
+	}()
+	return srv
+}
+
+func httpsRedirectHandler(httpsPort func() int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.Trim(host, "[]")
+		target := "https://" + net.JoinHostPort(host, util.Itoa(httpsPort())) + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusTemporaryRedirect)
+	})
 }
 
 func registerRoutes(s *server.Server, webRoot fs.FS) *http.ServeMux {
@@ -88,17 +207,35 @@ func registerRoutes(s *server.Server, webRoot fs.FS) *http.ServeMux {
 
 	h := handler.New(s)
 
+	mux.Handle("/healthz", http.HandlerFunc(h.HandleHealthz))
+	mux.Handle("/readyz", http.HandlerFunc(h.HandleReadyz))
+	mux.Handle("/metrics", http.HandlerFunc(h.HandleMetrics))
+	mux.Handle("/api/status", http.HandlerFunc(h.HandleStatus))
 	mux.Handle("/api/config", http.HandlerFunc(h.HandleConfig))
 	mux.Handle("/api/shares", http.HandlerFunc(h.HandleShares))
 	mux.Handle("/api/media", http.HandlerFunc(h.HandleMedia))
 	mux.Handle("/api/stream", http.HandlerFunc(h.HandleStream))
 	mux.Handle("/api/subtitle", http.HandlerFunc(h.HandleSubtitle))
 	mux.Handle("/api/probe", http.HandlerFunc(h.HandleProbe))
+	mux.Handle("/api/lyrics", http.HandlerFunc(h.HandleLyrics))
 	mux.Handle("/api/ip", http.HandlerFunc(h.HandleIP))
 	mux.Handle("/api/prefs", http.HandlerFunc(h.HandlePrefs))
 	mux.Handle("/api/progress", http.HandlerFunc(h.HandleProgress))
 	mux.Handle("/api/log", http.HandlerFunc(h.HandleLog))
 	mux.Handle("/api/pin", http.HandlerFunc(h.HandlePIN))
+	mux.Handle("/api/pin/change", http.HandlerFunc(h.HandlePINChange))
+	mux.Handle("/api/login", http.HandlerFunc(h.HandleLogin))
+	mux.Handle("/api/logout", http.HandlerFunc(h.HandleLogout))
+	mux.Handle("/api/me", http.HandlerFunc(h.HandleMe))
+	mux.Handle("/api/favorites", http.HandlerFunc(h.HandleFavorites))
+	mux.Handle("/api/history", http.HandlerFunc(h.HandleHistory))
+	mux.Handle("/api/sessions", http.HandlerFunc(h.HandleSessions))
+	mux.Handle("/api/admin/lockouts", http.HandlerFunc(h.HandleLockouts))
+	mux.Handle("/api/admin/users", http.HandlerFunc(h.HandleUsers))
+	mux.Handle("/api/admin/audit", http.HandlerFunc(h.HandleAudit))
+	mux.Handle("/api/admin/tokens", http.HandlerFunc(h.HandleTokens))
+	mux.Handle("/api/admin/links", http.HandlerFunc(h.HandleLinks))
+	mux.Handle("/api/links/open", http.HandlerFunc(h.HandleLinkOpen))
 
 	mux.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		web.ServeEmbeddedWeb(w, r, webRoot)
@@ -106,25 +243,34 @@ func registerRoutes(s *server.Server, webRoot fs.FS) *http.ServeMux {
 	return mux
 }
 
-func printStartupBanner(cfgPath string, port int) {
-	ips := util.GetLanIPv4s()
-	urls := make([]string, 0, 2+len(ips))
-	urls = append(urls, "http://127.0.0.1:"+util.Itoa(port)+"/")
-	for _, ip := range ips {
-		urls = append(urls, "http://"+ip+":"+util.Itoa(port)+"/")
-	}
-
-	log.Println("配置文件:", cfgPath)
+func printStartupBanner(cfgPath, dataDir string, urls []string, reloader *certs.Reloader, caFile string) {
+	slog.Info("Server starting", "config", cfgPath, "dataDir", dataDir, "urls", urls)
 	fmt.Println("配置文件:", cfgPath)
+	fmt.Println("数据目录:", dataDir)
 	for _, u := range urls {
-		log.Println("访问:", u)
 		fmt.Println("访问:", "\x1b[36m"+u+"\x1b[0m")
 	}
+	if reloader != nil {
+		fp := reloader.Fingerprint()
+		slog.Info("TLS enabled", "sha256", fp, "ca", caFile)
+		fmt.Println("证书指纹 (SHA-256):", fp)
+		if caFile != "" {
+			fmt.Println("根证书:", caFile, "（在设备上安装并信任后即可消除浏览器警告）")
+		}
+	}
 }
 
-func tryAutoOpenBrowser(port int) {
-	localURL := "http://localhost:" + util.Itoa(port) + "/"
-	addr := "127.0.0.1:" + util.Itoa(port)
+func tryAutoOpenBrowser(listen string, port int, https bool) {
+	host, dialHost := "localhost", "127.0.0.1"
+	if !server.IsWildcardHost(listen) {
+		host, dialHost = listen, listen
+	}
+	scheme := "http"
+	if https {
+		scheme = "https"
+	}
+	localURL := scheme + "://" + net.JoinHostPort(host, util.Itoa(port)) + "/"
+	addr := net.JoinHostPort(dialHost, util.Itoa(port))
 
 	deadline := time.Now().Add(3 * time.Second)
 	for time.Now().Before(deadline) {
@@ -1,9 +1,106 @@
 package main
 
 import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
 func TestMain(t *testing.T) {
 	// Dummy test for main package
 }
+
+func TestHTTPSRedirect(t *testing.T) {
+	h := httpsRedirectHandler(func() int { return 8443 })
+	for host, want := range map[string]string{
+		"192.168.1.20:8080": "https://192.168.1.20:8443/api/media?x=1",
+		"localhost":         "https://localhost:8443/api/media?x=1",
+		"[::1]:8080":        "https://[::1]:8443/api/media?x=1",
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/api/media?x=1", nil)
+		req.Host = host
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusTemporaryRedirect || w.Header().Get("Location") != want {
+			t.Errorf("%s: got %d %q, want %q", host, w.Code, w.Header().Get("Location"), want)
+		}
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	dir := t.TempDir()
+	o, rest, err := parseArgs([]string{"--data-dir", dir, "config", "--listen", "127.0.0.1:9000", "show"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(rest, " ") != "config show" {
+		t.Errorf("rest = %q", rest)
+	}
+	if o.listen != "127.0.0.1" || o.port != 9000 {
+		t.Errorf("listen = %q port = %d", o.listen, o.port)
+	}
+	if o.configPath != filepath.Join(dir, "config.json") || o.dbPath() != filepath.Join(dir, "msp.db") {
+		t.Errorf("paths = %q %q", o.configPath, o.dbPath())
+	}
+
+	o, _, err = parseArgs([]string{"--listen", "[::1]:9000", "--port", "8100"}, io.Discard)
+	if err != nil || o.listen != "::1" || o.port != 8100 {
+		t.Errorf("explicit --port: %+v %v", o, err)
+	}
+	if _, _, err := parseArgs([]string{"--bogus"}, io.Discard); err == nil {
+		t.Error("unknown flag accepted")
+	}
+}
+
+func TestCLICommands(t *testing.T) {
+	dir := t.TempDir()
+	runCmd := func(stdin string, args ...string) (int, string) {
+		var out, errOut bytes.Buffer
+		code := run(append([]string{"--data-dir", dir}, args...), strings.NewReader(stdin), &out, &errOut)
+		return code, out.String() + errOut.String()
+	}
+
+	if code, out := runCmd("", "config", "validate"); code != 1 {
+		t.Errorf("validate without config: %d %s", code, out)
+	}
+	if code, out := runCmd("4321\n", "pin", "set"); code != 0 {
+		t.Fatalf("pin set: %d %s", code, out)
+	}
+	if code, out := runCmd("", "config", "validate"); code != 0 {
+		t.Errorf("validate: %d %s", code, out)
+	}
+	code, out := runCmd("", "config", "show")
+	if code != 0 || !strings.Contains(out, `"port"`) || strings.Contains(out, "4321") {
+		t.Errorf("show: %d %s", code, out)
+	}
+	if code, out := runCmd("", "db", "vacuum"); code != 0 {
+		t.Errorf("vacuum: %d %s", code, out)
+	}
+	if code, _ := runCmd("", "frobnicate"); code != 1 {
+		t.Errorf("unknown command: %d", code)
+	}
+
+	yamlPath := filepath.Join(dir, "other", "msp.yaml")
+	_ = os.MkdirAll(filepath.Dir(yamlPath), 0750)
+	if code, out := runCmd("", "config", "convert", yamlPath); code != 0 {
+		t.Fatalf("convert: %d %s", code, out)
+	}
+	if code, out := runCmd("", "--config", yamlPath, "config", "validate"); code != 0 {
+		t.Errorf("validate yaml: %d %s", code, out)
+	}
+	if code, _ := runCmd("", "config", "convert", yamlPath); code != 1 {
+		t.Error("convert should not overwrite an existing file")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"security":{"ipWhitelist":["not-an-ip"]}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if code, out := runCmd("", "config", "validate"); code != 1 || !strings.Contains(out, "not-an-ip") {
+		t.Errorf("validate invalid: %d %s", code, out)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"msp/internal/db"
+	"msp/internal/server"
+)
+
+// drainTimeout is how long open requests and streams may take to finish on
+// shutdown, chosen to fit within the 10 second grace period of docker stop.
+const drainTimeout = 8 * time.Second
+
+// waitForSignals serves until SIGINT or SIGTERM (then shuts down) or until
+// the listener fails. SIGHUP reloads the config file. redirect is the
+// HTTP-to-HTTPS redirect server, if any.
+func waitForSignals(s *server.Server, ln *listener, redirect *http.Server, cancelBackground, cancelRequests context.CancelFunc) error {
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigc)
+	for {
+		select {
+		case err := <-ln.errc:
+			return err
+		case sig := <-sigc:
+			if sig == syscall.SIGHUP {
+				slog.Info("SIGHUP received, reloading config")
+				if err := s.ReloadConfig(); err != nil {
+					slog.Error("Invalid config, keeping previous", "err", err)
+				}
+				continue
+			}
+			// A second signal kills the process the default way.
+			signal.Reset(os.Interrupt, syscall.SIGTERM)
+			slog.Info("Shutting down", "signal", sig.String())
+			shutdown(s, ln, redirect, cancelBackground, cancelRequests, drainTimeout)
+			return nil
+		}
+	}
+}
+
+// shutdown stops accepting connections on every port (the app, ports still
+// being released and the redirect server) and cancels background scans,
+// gives open requests and streams drain to finish, then cancels them (which
+// also stops ffmpeg) and closes the database and the log file.
+func shutdown(s *server.Server, ln *listener, redirect *http.Server, cancelBackground, cancelRequests context.CancelFunc, drain time.Duration) {
+	start := time.Now()
+	cancelBackground()
+	ctx, cancel := context.WithTimeout(context.Background(), drain)
+	defer cancel()
+	if err := shutdownServers(ctx, append(ln.servers(), redirect)...); err != nil {
+		slog.Info("Open connections did not finish in time, closing them", "drain", drain)
+	}
+	cancelRequests()
+	ln.Close()
+	if redirect != nil {
+		_ = redirect.Close()
+	}
+	s.Wait()
+	db.Close()
+	slog.Info("Shutdown complete", "duration", time.Since(start).Round(time.Millisecond))
+	s.CloseLog()
+}
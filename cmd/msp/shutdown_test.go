@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"msp/internal/server"
+	"msp/internal/util"
+)
+
+func TestShutdown(t *testing.T) {
+	s := server.New(filepath.Join(t.TempDir(), "config.json"))
+	if err := s.LoadOrInitConfig(); err != nil {
+		t.Fatal(err)
+	}
+	bgCtx, cancelBackground := context.WithCancel(context.Background())
+	reqCtx, cancelRequests := context.WithCancel(context.Background())
+	s.SetContext(bgCtx)
+	scanStopped := make(chan struct{})
+	s.Go(func(ctx context.Context) {
+		<-ctx.Done()
+		close(scanStopped)
+	})
+
+	// A stream that only ends when its request is cancelled.
+	started, streamStopped := make(chan struct{}), make(chan struct{})
+	ln := newListener(reqCtx, "127.0.0.1", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		close(started)
+		<-r.Context().Done()
+		close(streamStopped)
+	}), nil)
+	oldPort, port := freePort(t), freePort(t)
+	if err := ln.Listen(oldPort); err != nil {
+		t.Fatal(err)
+	}
+	clientDone := make(chan struct{})
+	go func() {
+		defer close(clientDone)
+		res, err := http.Get("http://127.0.0.1:" + util.Itoa(oldPort) + "/")
+		if err == nil {
+			_, _ = io.Copy(io.Discard, res.Body)
+			_ = res.Body.Close()
+		}
+	}()
+	<-started
+
+	// Move to another port while the stream keeps the old one draining
+	if err := ln.Listen(port); err != nil {
+		t.Fatal(err)
+	}
+	redirectPort := freePort(t)
+	redirect := serveHTTPSRedirect(redirectPort, ln.Port)
+	waitListening(t, redirectPort)
+
+	start := time.Now()
+	shutdown(s, ln, redirect, cancelBackground, cancelRequests, 200*time.Millisecond)
+	if d := time.Since(start); d < 200*time.Millisecond || d > 5*time.Second {
+		t.Errorf("shutdown took %s", d)
+	}
+	for name, ch := range map[string]chan struct{}{"scan": scanStopped, "stream": streamStopped, "old port client": clientDone} {
+		select {
+		case <-ch:
+		case <-time.After(time.Second):
+			t.Errorf("%s was not cancelled", name)
+		}
+	}
+	for _, p := range []int{port, oldPort, redirectPort} {
+		if _, err := http.Get("http://127.0.0.1:" + util.Itoa(p) + "/"); err == nil {
+			t.Errorf("port %d still accepts connections", p)
+		}
+	}
+}
+
+// waitListening waits until something accepts connections on port.
+func waitListening(t *testing.T, port int) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		c, err := net.Dial("tcp", "127.0.0.1:"+util.Itoa(port))
+		if err == nil {
+			_ = c.Close()
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("port %d not listening: %v", port, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
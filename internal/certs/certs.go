@@ -0,0 +1,348 @@
+// Package certs provides TLS certificates for the HTTPS listener.
+//
+// It can generate a private local CA together with a server certificate
+// signed by it (so clients only have to trust the CA once), and reloads
+// certificate files from disk when they change.
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// File names used inside the auto-generated certificate directory.
+const (
+	CAFile        = "ca.pem"
+	CAKeyFile     = "ca-key.pem"
+	ServerFile    = "server.pem"
+	ServerKeyFile = "server-key.pem"
+)
+
+const (
+	caValidity     = 10 * 365 * 24 * time.Hour
+	serverValidity = 397 * 24 * time.Hour // browsers reject longer-lived leaf certificates
+	renewBefore    = 30 * 24 * time.Hour
+)
+
+// EnsureAutoCert makes sure dir contains a local CA and a server certificate
+// valid for every entry of hosts (DNS names or IP addresses). The CA is
+// created once and reused while its name constraints cover hosts; the server
+// certificate is re-issued when it is missing, close to expiry or does not
+// cover all hosts (e.g. a new LAN IP). It returns the paths of the server
+// certificate and key.
+func EnsureAutoCert(dir string, hosts []string) (certFile, keyFile string, err error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", "", err
+	}
+	certFile = filepath.Join(dir, ServerFile)
+	keyFile = filepath.Join(dir, ServerKeyFile)
+
+	ca, caKey, err := loadOrCreateCA(filepath.Join(dir, CAFile), filepath.Join(dir, CAKeyFile), hosts)
+	if err != nil {
+		return "", "", err
+	}
+	if leafUsable(certFile, keyFile, ca, hosts) {
+		return certFile, keyFile, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	tmpl, err := newTemplate("MSP Server", serverValidity)
+	if err != nil {
+		return "", "", err
+	}
+	tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+	tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else if h != "" {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return "", "", err
+	}
+	if err := writeKeyPair(certFile, keyFile, der, key); err != nil {
+		return "", "", err
+	}
+	return certFile, keyFile, nil
+}
+
+// localRanges are the addresses the CA may always issue for: loopback,
+// private, CGNAT and link-local ranges.
+var localRanges = []string{
+	"127.0.0.0/8", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "169.254.0.0/16",
+	"::1/128", "fc00::/7", "fe80::/10",
+}
+
+// localDomains are the DNS names (and their subdomains) the CA may always
+// issue for.
+var localDomains = []string{"localhost", "local", "lan", "home.arpa", "internal"}
+
+// loadOrCreateCA returns the CA in certFile/keyFile, creating a new one when
+// there is none, it has expired, or its name constraints do not cover hosts.
+// Users install the CA on their devices, so it is constrained to local names
+// and addresses: a stolen key cannot impersonate public sites.
+func loadOrCreateCA(certFile, keyFile string, hosts []string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	if pair, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil {
+		ca, err := x509.ParseCertificate(pair.Certificate[0])
+		if err != nil {
+			return nil, nil, err
+		}
+		key, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, nil, fmt.Errorf("%s: unsupported CA key type", keyFile)
+		}
+		if time.Now().Before(ca.NotAfter) && caPermits(ca, hosts) {
+			return ca, key, nil
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, nil, fmt.Errorf("load CA: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl, err := newTemplate("MSP Local CA", caValidity)
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl.IsCA = true
+	tmpl.BasicConstraintsValid = true
+	tmpl.MaxPathLenZero = true
+	tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	tmpl.PermittedDNSDomainsCritical = true
+	tmpl.PermittedDNSDomains, tmpl.PermittedIPRanges = nameConstraints(hosts)
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := writeKeyPair(certFile, keyFile, der, key); err != nil {
+		return nil, nil, err
+	}
+	ca, err := x509.ParseCertificate(der)
+	return ca, key, err
+}
+
+// nameConstraints returns the local domains and ranges plus any of hosts
+// outside them, e.g. the machine's host name.
+func nameConstraints(hosts []string) (domains []string, ranges []*net.IPNet) {
+	for _, r := range localRanges {
+		_, n, _ := net.ParseCIDR(r)
+		ranges = append(ranges, n)
+	}
+	domains = append(domains, localDomains...)
+	for _, h := range hosts {
+		if h == "" || permitted(domains, ranges, h) {
+			continue
+		}
+		if ip := net.ParseIP(h); ip != nil {
+			bits := 8 * len(ip.To16())
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			ranges = append(ranges, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		} else {
+			domains = append(domains, strings.ToLower(h))
+		}
+	}
+	return domains, ranges
+}
+
+// caPermits reports whether ca is name-constrained and may issue for all
+// hosts. CAs created by older versions have no constraints.
+func caPermits(ca *x509.Certificate, hosts []string) bool {
+	if len(ca.PermittedDNSDomains) == 0 || len(ca.PermittedIPRanges) == 0 {
+		return false
+	}
+	for _, h := range hosts {
+		if h != "" && !permitted(ca.PermittedDNSDomains, ca.PermittedIPRanges, h) {
+			return false
+		}
+	}
+	return true
+}
+
+func permitted(domains []string, ranges []*net.IPNet, host string) bool {
+	if ip := net.ParseIP(host); ip != nil {
+		for _, n := range ranges {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	host = strings.ToLower(host)
+	for _, d := range domains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// leafUsable reports whether the existing server certificate was issued by
+// ca, is not about to expire and covers all hosts.
+func leafUsable(certFile, keyFile string, ca *x509.Certificate, hosts []string) bool {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return false
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil || leaf.CheckSignatureFrom(ca) != nil {
+		return false
+	}
+	if time.Until(leaf.NotAfter) < renewBefore {
+		return false
+	}
+	for _, h := range hosts {
+		if h != "" && leaf.VerifyHostname(h) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func newTemplate(cn string, validity time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn, Organization: []string{"MSP"}},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(validity),
+	}, nil
+}
+
+func writeKeyPair(certFile, keyFile string, der []byte, key *ecdsa.PrivateKey) error {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+// Fingerprint returns the SHA-256 fingerprint of a DER certificate as
+// colon-separated upper-case hex, the format browsers display.
+func Fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// Reloader serves a certificate/key pair from disk and picks up changes to
+// the files without restarting the server.
+type Reloader struct {
+	certFile, keyFile string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// NewReloader loads the key pair and returns a Reloader for it.
+func NewReloader(certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload re-reads the key pair. On error the previous certificate is kept.
+func (r *Reloader) Reload() error {
+	pair, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.cert = &pair
+	r.modTime = r.filesModTime()
+	r.mu.Unlock()
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Fingerprint returns the SHA-256 fingerprint of the current certificate.
+func (r *Reloader) Fingerprint() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return Fingerprint(r.cert.Certificate[0])
+}
+
+// Watch polls the certificate files and reloads them when either changes.
+// onReload is called after every reload attempt (err is nil on success).
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration, onReload func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.mu.RLock()
+			last := r.modTime
+			r.mu.RUnlock()
+			if !r.filesModTime().After(last) {
+				continue
+			}
+			err := r.Reload()
+			if err != nil {
+				// Don't retry until the files change again
+				r.mu.Lock()
+				r.modTime = r.filesModTime()
+				r.mu.Unlock()
+			}
+			if onReload != nil {
+				onReload(err)
+			}
+		}
+	}
+}
+
+// filesModTime returns the newer modification time of the two files.
+func (r *Reloader) filesModTime() time.Time {
+	var t time.Time
+	for _, p := range []string{r.certFile, r.keyFile} {
+		if st, err := os.Stat(p); err == nil && st.ModTime().After(t) {
+			t = st.ModTime()
+		}
+	}
+	return t
+}
@@ -0,0 +1,173 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEnsureAutoCert(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, err := EnsureAutoCert(dir, []string{"localhost", "127.0.0.1", "192.168.1.20"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caPEM, err := os.ReadFile(filepath.Join(dir, CAFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caPEM) {
+		t.Fatal("Expected CA PEM to parse")
+	}
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(pair.Certificate[0])
+	for _, host := range []string{"localhost", "127.0.0.1", "192.168.1.20"} {
+		if _, err := leaf.Verify(x509.VerifyOptions{Roots: roots, DNSName: host}); err != nil {
+			t.Errorf("Expected certificate to verify for %s: %v", host, err)
+		}
+	}
+
+	// Same hosts: the certificate is reused
+	before, _ := os.ReadFile(certFile)
+	if _, _, err := EnsureAutoCert(dir, []string{"localhost", "127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := os.ReadFile(certFile); string(after) != string(before) {
+		t.Error("Expected existing certificate to be reused")
+	}
+
+	// New LAN address: re-issued by the same CA
+	if _, _, err := EnsureAutoCert(dir, []string{"localhost", "10.0.0.5"}); err != nil {
+		t.Fatal(err)
+	}
+	pair, _ = tls.LoadX509KeyPair(certFile, keyFile)
+	leaf, _ = x509.ParseCertificate(pair.Certificate[0])
+	if _, err := leaf.Verify(x509.VerifyOptions{Roots: roots, DNSName: "10.0.0.5"}); err != nil {
+		t.Errorf("Expected re-issued certificate to cover new address: %v", err)
+	}
+}
+
+func TestCANameConstraints(t *testing.T) {
+	dir := t.TempDir()
+	hosts := []string{"localhost", "127.0.0.1", "192.168.1.20", "mybox"}
+	if _, _, err := EnsureAutoCert(dir, hosts); err != nil {
+		t.Fatal(err)
+	}
+	pair, err := tls.LoadX509KeyPair(filepath.Join(dir, CAFile), filepath.Join(dir, CAKeyFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(pair.Certificate[0])
+	if !ca.PermittedDNSDomainsCritical {
+		t.Error("Expected critical name constraints")
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	// Anyone holding the CA key can sign, but only local names verify
+	issue := func(host string) *x509.Certificate {
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		tmpl, _ := newTemplate(host, time.Hour)
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		if ip := net.ParseIP(host); ip != nil {
+			tmpl.IPAddresses = []net.IP{ip}
+		} else {
+			tmpl.DNSNames = []string{host}
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, pair.PrivateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, _ := x509.ParseCertificate(der)
+		return leaf
+	}
+	for _, host := range []string{"www.example.com", "8.8.8.8"} {
+		if _, err := issue(host).Verify(x509.VerifyOptions{Roots: roots, DNSName: host}); err == nil {
+			t.Errorf("Expected leaf for public name %s to fail verification", host)
+		}
+	}
+	for _, host := range []string{"nas.local", "10.1.2.3", "mybox"} {
+		if _, err := issue(host).Verify(x509.VerifyOptions{Roots: roots, DNSName: host}); err != nil {
+			t.Errorf("Expected leaf for %s to verify: %v", host, err)
+		}
+	}
+}
+
+func TestUnconstrainedCAReplaced(t *testing.T) {
+	dir := t.TempDir()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl, _ := newTemplate("MSP Local CA", time.Hour)
+	tmpl.IsCA = true
+	tmpl.BasicConstraintsValid = true
+	tmpl.KeyUsage = x509.KeyUsageCertSign
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeKeyPair(filepath.Join(dir, CAFile), filepath.Join(dir, CAKeyFile), der, key); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := EnsureAutoCert(dir, []string{"localhost"}); err != nil {
+		t.Fatal(err)
+	}
+	pair, err := tls.LoadX509KeyPair(filepath.Join(dir, CAFile), filepath.Join(dir, CAKeyFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ca, _ := x509.ParseCertificate(pair.Certificate[0]); len(ca.PermittedDNSDomains) == 0 {
+		t.Error("Expected CA without name constraints to be replaced")
+	}
+}
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, err := EnsureAutoCert(dir, []string{"localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := r.Fingerprint()
+	if len(first) != 95 {
+		t.Fatalf("Unexpected fingerprint format: %s", first)
+	}
+
+	// Replace the certificate on disk and reload
+	_ = os.Remove(certFile)
+	if _, _, err := EnsureAutoCert(dir, []string{"localhost"}); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	_ = os.Chtimes(certFile, future, future)
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if r.Fingerprint() == first {
+		t.Error("Expected fingerprint to change after reload")
+	}
+
+	// A broken file keeps the previous certificate
+	current := r.Fingerprint()
+	_ = os.WriteFile(certFile, []byte("garbage"), 0644)
+	if err := r.Reload(); err == nil {
+		t.Error("Expected reload of invalid certificate to fail")
+	}
+	if c, _ := r.GetCertificate(nil); c == nil || r.Fingerprint() != current {
+		t.Error("Expected previous certificate to be kept")
+	}
+}
@@ -1,5 +1,14 @@
 package config
 
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/url"
+	"strings"
+
+	"msp/internal/pinhash"
+)
+
 type Features struct {
 	Speed        bool      `json:"speed"`
 	SpeedOptions []float64 `json:"speedOptions"`
@@ -11,6 +20,40 @@ type Features struct {
 type Share struct {
 	Label string `json:"label"`
 	Path  string `json:"path"`
+
+	// AllowUsers and AllowGroups restrict the share to these user names or
+	// groups (admins always have access). If both are empty, everyone can see it.
+	AllowUsers  []string `json:"allowUsers,omitempty"`
+	AllowGroups []string `json:"allowGroups,omitempty"`
+}
+
+// Restricted reports whether the share has an allow list.
+func (sh Share) Restricted() bool {
+	return len(sh.AllowUsers) > 0 || len(sh.AllowGroups) > 0
+}
+
+// Allows reports whether a user with the given name and groups may access
+// the share. Anonymous callers (empty name) only see unrestricted shares.
+func (sh Share) Allows(user string, groups []string) bool {
+	if !sh.Restricted() {
+		return true
+	}
+	if user == "" {
+		return false
+	}
+	for _, u := range sh.AllowUsers {
+		if strings.EqualFold(u, user) {
+			return true
+		}
+	}
+	for _, want := range sh.AllowGroups {
+		for _, g := range groups {
+			if strings.EqualFold(want, g) {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 type UIConfig struct {
@@ -64,11 +107,116 @@ type SecurityConfig struct {
 	// PINEnabled enables PIN authentication
 	PINEnabled bool `json:"pinEnabled"`
 
-	// PIN is the authentication code (default: "0000")
-	PIN string `json:"pin"`
+	// PIN is a plaintext PIN accepted only as input: on load it is hashed
+	// into PINHash and cleared, so it is never persisted.
+	PIN string `json:"pin,omitempty"`
+
+	// PINHash is the argon2id hash of the PIN (default PIN: "0000")
+	PINHash string `json:"pinHash,omitempty"`
+
+	// AdminPIN is a plaintext admin credential accepted only as input, like PIN.
+	AdminPIN string `json:"adminPin,omitempty"`
+
+	// AdminPINHash is the argon2id hash of the admin credential. Admins may
+	// change the config, manage shares and sessions, and write to the log.
+	// If empty, only localhost can act as admin (see LocalhostAdmin).
+	AdminPINHash string `json:"adminPinHash,omitempty"`
+
+	// LocalhostAdmin grants the admin role to requests from the local machine
+	// without a credential (default: true)
+	LocalhostAdmin *bool `json:"localhostAdmin"`
+
+	// LinkSecret is the hex-encoded HMAC key used to sign share links.
+	// It is generated on first start; changing it invalidates all links.
+	LinkSecret string `json:"linkSecret,omitempty"`
+
+	// TrustedProxies lists reverse proxies (IPs, CIDR ranges or named ranges)
+	// whose Forwarded / X-Forwarded-For / X-Real-IP headers are honoured.
+	// If empty, forwarded headers are ignored and the TCP peer address is used.
+	TrustedProxies []string `json:"trustedProxies"`
+
+	// AllowedOrigins lists extra browser origins ("https://media.example.com")
+	// that may send state-changing requests and receive CORS headers. Local
+	// host names, loopback and LAN addresses are always allowed.
+	AllowedOrigins []string `json:"allowedOrigins"`
+
+	// SessionTTLHours is how long a PIN login session stays valid (default: 168, i.e. 7 days)
+	SessionTTLHours int `json:"sessionTTLHours"`
+
+	// MaxFailedAttempts is the number of wrong PINs from one IP before it is locked out (default: 5)
+	MaxFailedAttempts int `json:"maxFailedAttempts"`
+
+	// GlobalMaxFailedAttempts locks PIN login for everyone after this many
+	// failures across all IPs within 10 minutes (default: 50)
+	GlobalMaxFailedAttempts int `json:"globalMaxFailedAttempts"`
+
+	// LockoutMinutes is the base lockout duration; it doubles on each repeated lockout (default: 15)
+	LockoutMinutes int `json:"lockoutMinutes"`
+
+	// AuditRetentionDays is how long audit events are kept (default: 90)
+	AuditRetentionDays int `json:"auditRetentionDays"`
+
+	// AuditMaxEvents caps the number of stored audit events (default: 10000)
+	AuditMaxEvents int `json:"auditMaxEvents"`
+}
+
+// TLSConfig configures the built-in HTTPS listener. Changes take effect on
+// restart; certificate files themselves are reloaded when they change.
+type TLSConfig struct {
+	// Enabled serves HTTPS on Port instead of plain HTTP (default: false)
+	Enabled bool `json:"enabled"`
+
+	// CertFile and KeyFile are PEM files of a user-supplied certificate.
+	// If both are empty, a local CA and a server certificate covering
+	// localhost and all LAN addresses are generated automatically.
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+
+	// RedirectPort, if > 0, serves plain HTTP on this port and redirects
+	// every request to HTTPS
+	RedirectPort int `json:"redirectPort"`
+}
+
+// MetricsConfig controls the Prometheus endpoint /metrics. Like every
+// route it is subject to security.ipWhitelist and ipBlacklist, but it needs
+// no PIN unless RequireToken is set, so by default only the local machine
+// may read it.
+type MetricsConfig struct {
+	// Enabled serves /metrics (default: true)
+	Enabled *bool `json:"enabled"`
+
+	// AllowIPs limits /metrics to these IPs, CIDR ranges or named ranges.
+	// An empty list allows loopback addresses only (default: ["loopback"])
+	AllowIPs []string `json:"allowIPs"`
+
+	// RequireToken requires an API token of any scope, sent as
+	// "Authorization: Bearer <token>" (default: false)
+	RequireToken bool `json:"requireToken"`
+}
+
+// LogRotateConfig controls rotation of the log file. Rotated files are named
+// msp.log.20060102-150405 (with .gz when compressed) next to the log file.
+type LogRotateConfig struct {
+	// MaxSizeMB rotates the log file before it grows past this size (default: 10)
+	MaxSizeMB int `json:"maxSizeMB"`
+
+	// Daily also rotates at the first record of each new day (default: false)
+	Daily bool `json:"daily"`
+
+	// MaxBackups is the number of rotated files to keep (default: 5)
+	MaxBackups int `json:"maxBackups"`
+
+	// MaxAgeDays deletes rotated files older than this; 0 keeps them (default: 0)
+	MaxAgeDays int `json:"maxAgeDays"`
+
+	// Compress gzips rotated files (default: false)
+	Compress bool `json:"compress"`
 }
 
 type Config struct {
+	// ConfigVersion is the schema version of the file, see Migrate.
+	ConfigVersion int `json:"configVersion"`
+
 	Port      int             `json:"port"`
 	Shares    []Share         `json:"shares"`
 	Features  Features        `json:"features"`
@@ -76,8 +224,12 @@ type Config struct {
 	Playback  PlaybackConfig  `json:"playback"`
 	Blacklist BlacklistConfig `json:"blacklist"`
 	Security  SecurityConfig  `json:"security"`
+	TLS       TLSConfig       `json:"tls"`
+	Metrics   MetricsConfig   `json:"metrics"`
 	LogLevel  string          `json:"logLevel"`
+	LogFormat string          `json:"logFormat"` // "text" or "json"
 	LogFile   string          `json:"logFile"`
+	LogRotate LogRotateConfig `json:"logRotate"`
 	MaxItems  int             `json:"maxItems"`
 }
 
@@ -88,9 +240,10 @@ func stringPtr(v string) *string { return &v }
 // Default configuration values
 func Default() Config {
 	return Config{
-		Port:     8099,
-		MaxItems: 0, // 0 means unlimited (full scan), ideal for SQLite-backed incremental scanning
-		Shares:   []Share{},
+		ConfigVersion: CurrentVersion,
+		Port:          8099,
+		MaxItems:      0, // 0 means unlimited (full scan), ideal for SQLite-backed incremental scanning
+		Shares:        []Share{},
 		Features: Features{
 			Speed:        true,
 			SpeedOptions: []float64{0.5, 0.75, 1, 1.25, 1.5, 2},
@@ -128,13 +281,33 @@ func Default() Config {
 			SizeRule:   "",
 		},
 		Security: SecurityConfig{
-			IPWhitelist: []string{},
-			IPBlacklist: []string{},
-			PINEnabled:  false,
-			PIN:         "0000",
+			IPWhitelist:     []string{},
+			IPBlacklist:     []string{},
+			PINEnabled:      false,
+			PIN:             "0000",
+			LocalhostAdmin:  boolPtr(true),
+			TrustedProxies:  []string{},
+			AllowedOrigins:  []string{},
+			SessionTTLHours: 168,
+
+			MaxFailedAttempts:       5,
+			GlobalMaxFailedAttempts: 50,
+			LockoutMinutes:          15,
+
+			AuditRetentionDays: 90,
+			AuditMaxEvents:     10000,
+		},
+		Metrics: MetricsConfig{
+			Enabled:  boolPtr(true),
+			AllowIPs: []string{"loopback"},
+		},
+		LogLevel:  "info",
+		LogFormat: "text",
+		LogFile:   "",
+		LogRotate: LogRotateConfig{
+			MaxSizeMB:  10,
+			MaxBackups: 5,
 		},
-		LogLevel: "info",
-		LogFile:  "",
 	}
 }
 
@@ -150,12 +323,17 @@ func ApplyDefaults(cfg *Config) bool {
 	changed = applyPlaybackDefaults(cfg) || changed
 	changed = applyBlacklistDefaults(cfg) || changed
 	changed = applySecurityDefaults(cfg) || changed
+	changed = applyMetricsDefaults(cfg) || changed
 
 	return changed
 }
 
 func applyBaseDefaults(cfg *Config) bool {
 	changed := false
+	if cfg.ConfigVersion == 0 {
+		cfg.ConfigVersion = CurrentVersion
+		changed = true
+	}
 	if cfg.Port <= 0 {
 		cfg.Port = 8099
 		changed = true
@@ -168,6 +346,12 @@ func applyBaseDefaults(cfg *Config) bool {
 		cfg.LogLevel = "info"
 		changed = true
 	}
+	if cfg.LogFormat == "" {
+		cfg.LogFormat = "text"
+		changed = true
+	}
+	changed = setDefaultInt(&cfg.LogRotate.MaxSizeMB, 10) || changed
+	changed = setDefaultInt(&cfg.LogRotate.MaxBackups, 5) || changed
 	return changed
 }
 
@@ -234,6 +418,14 @@ func setDefaultBool(dst **bool, v bool) bool {
 	return true
 }
 
+func setDefaultInt(dst *int, v int) bool {
+	if *dst > 0 {
+		return false
+	}
+	*dst = v
+	return true
+}
+
 func setDefaultString(dst **string, v string) bool {
 	if *dst != nil {
 		return false
@@ -269,9 +461,94 @@ func applySecurityDefaults(cfg *Config) bool {
 		cfg.Security.IPBlacklist = []string{}
 		changed = true
 	}
-	if cfg.Security.PIN == "" {
+	if cfg.Security.PIN == "" && cfg.Security.PINHash == "" {
 		cfg.Security.PIN = "0000"
 		changed = true
 	}
+	changed = setDefaultBool(&cfg.Security.LocalhostAdmin, true) || changed
+	if cfg.Security.TrustedProxies == nil {
+		cfg.Security.TrustedProxies = []string{}
+		changed = true
+	}
+	if cfg.Security.AllowedOrigins == nil {
+		cfg.Security.AllowedOrigins = []string{}
+		changed = true
+	}
+	if cfg.Security.SessionTTLHours <= 0 {
+		cfg.Security.SessionTTLHours = 168
+		changed = true
+	}
+	changed = setDefaultInt(&cfg.Security.MaxFailedAttempts, 5) || changed
+	changed = setDefaultInt(&cfg.Security.GlobalMaxFailedAttempts, 50) || changed
+	changed = setDefaultInt(&cfg.Security.LockoutMinutes, 15) || changed
+	changed = setDefaultInt(&cfg.Security.AuditRetentionDays, 90) || changed
+	changed = setDefaultInt(&cfg.Security.AuditMaxEvents, 10000) || changed
+	return changed
+}
+
+func applyMetricsDefaults(cfg *Config) bool {
+	changed := setDefaultBool(&cfg.Metrics.Enabled, true)
+	if cfg.Metrics.AllowIPs == nil {
+		cfg.Metrics.AllowIPs = []string{"loopback"}
+		changed = true
+	}
 	return changed
 }
+
+// NormalizeOrigin returns origin as lower-case "scheme://host[:port]", or ""
+// if it is not an http(s) origin without path, query or credentials.
+func NormalizeOrigin(origin string) string {
+	u, err := url.Parse(strings.TrimSuffix(strings.TrimSpace(origin), "/"))
+	if err != nil || u.Host == "" || u.User != nil || u.Path != "" || u.RawQuery != "" || u.Fragment != "" {
+		return ""
+	}
+	scheme := strings.ToLower(u.Scheme)
+	if scheme != "http" && scheme != "https" {
+		return ""
+	}
+	return scheme + "://" + strings.ToLower(u.Host)
+}
+
+// PrepareSecrets replaces a plaintext security.pin / security.adminPin with
+// its hash and generates a missing link signing key. It returns true if the
+// config was changed and needs to be saved.
+func PrepareSecrets(cfg *Config) (bool, error) {
+	if cfg == nil {
+		return false, nil
+	}
+	changed := false
+	if cfg.Security.LinkSecret == "" {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return false, err
+		}
+		cfg.Security.LinkSecret = hex.EncodeToString(key)
+		changed = true
+	}
+	for _, f := range []struct{ plain, hash *string }{
+		{&cfg.Security.PIN, &cfg.Security.PINHash},
+		{&cfg.Security.AdminPIN, &cfg.Security.AdminPINHash},
+	} {
+		if *f.plain == "" {
+			continue
+		}
+		h, err := pinhash.Hash(*f.plain)
+		if err != nil {
+			return false, err
+		}
+		*f.hash = h
+		*f.plain = ""
+		changed = true
+	}
+	return changed, nil
+}
+
+// Redacted returns a copy of cfg with secrets removed, for API responses.
+func (cfg Config) Redacted() Config {
+	cfg.Security.PIN = ""
+	cfg.Security.PINHash = ""
+	cfg.Security.AdminPIN = ""
+	cfg.Security.AdminPINHash = ""
+	cfg.Security.LinkSecret = ""
+	return cfg
+}
@@ -9,3 +9,63 @@ func TestConfigDefaults(t *testing.T) {
 		t.Error("Expected default port to be set")
 	}
 }
+
+func TestValidateSecurity(t *testing.T) {
+	c := Default()
+	c.Security.IPWhitelist = []string{"192.168.1.0/25", "private"}
+	if err := ValidateSecurity(c.Security); err != nil {
+		t.Errorf("Expected valid security config, got %v", err)
+	}
+	c.Security.AllowedOrigins = []string{"https://media.example.com", "http://10.0.0.2:8099/"}
+	if err := ValidateSecurity(c.Security); err != nil {
+		t.Errorf("Expected valid origins, got %v", err)
+	}
+	c.Security.AllowedOrigins = []string{"media.example.com"}
+	if err := ValidateSecurity(c.Security); err == nil {
+		t.Error("Expected origin without scheme to be rejected")
+	}
+	c.Security.AllowedOrigins = nil
+	c.Security.IPBlacklist = []string{"10.0.0.0/40"}
+	if err := ValidateSecurity(c.Security); err == nil {
+		t.Error("Expected invalid CIDR to be rejected")
+	}
+}
+
+func TestValidateTLS(t *testing.T) {
+	c := Default()
+	c.TLS = TLSConfig{Enabled: true, RedirectPort: 8080}
+	if err := Validate(c); err != nil {
+		t.Errorf("Expected auto-generated TLS config to be valid, got %v", err)
+	}
+	c.TLS.CertFile = "cert.pem"
+	if err := Validate(c); err == nil {
+		t.Error("Expected certFile without keyFile to be rejected")
+	}
+	c.TLS = TLSConfig{Enabled: true, RedirectPort: c.Port}
+	if err := Validate(c); err == nil {
+		t.Error("Expected redirectPort equal to port to be rejected")
+	}
+}
+
+func TestShareAllows(t *testing.T) {
+	open := Share{Path: "/media"}
+	if !open.Allows("", nil) {
+		t.Error("Expected unrestricted share to allow anonymous access")
+	}
+	kids := Share{Path: "/kids", AllowUsers: []string{"Dad"}, AllowGroups: []string{"kids"}}
+	cases := []struct {
+		user   string
+		groups []string
+		want   bool
+	}{
+		{"", nil, false},
+		{"dad", nil, true},
+		{"tom", []string{"Kids"}, true},
+		{"guest", []string{"friends"}, false},
+	}
+	for _, c := range cases {
+		if got := kids.Allows(c.user, c.groups); got != c.want {
+			t.Errorf("Allows(%q, %v) = %v, want %v", c.user, c.groups, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// EnvPrefix starts every environment variable that overrides a config field.
+// The rest of the name is the field's JSON path in upper snake case, e.g.
+// MSP_PORT, MSP_LOG_LEVEL or MSP_SECURITY_PIN_ENABLED.
+const EnvPrefix = "MSP_"
+
+// Sources of an effective config value, see FieldSources.
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+)
+
+// EnvField is a config field set by an environment variable.
+type EnvField struct {
+	Path  string // JSON path, e.g. "security.pinEnabled"
+	Var   string // e.g. "MSP_SECURITY_PIN_ENABLED"
+	value string
+}
+
+// Env is the environment layer of the config: fields whose values come from
+// MSP_* variables and therefore override the config file.
+type Env []EnvField
+
+// derivedSecrets are fields computed from an overridden plaintext field, so
+// they belong to the environment layer as well.
+var derivedSecrets = map[string]string{
+	"security.pin":      "security.pinHash",
+	"security.adminPin": "security.adminPinHash",
+}
+
+// envFields maps variable names to JSON paths of all leaf config fields.
+var envFields = func() map[string]string {
+	m := map[string]string{}
+	for _, p := range leafPaths(reflect.TypeOf(Config{}), "") {
+		if p != "configVersion" { // describes the file, not a setting
+			m[EnvVarName(p)] = p
+		}
+	}
+	return m
+}()
+
+func leafPaths(t reflect.Type, prefix string) []string {
+	var out []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := jsonName(f)
+		if name == "" {
+			continue
+		}
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		if f.Type.Kind() == reflect.Struct {
+			out = append(out, leafPaths(f.Type, name)...)
+		} else {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+func jsonName(f reflect.StructField) string {
+	if !f.IsExported() {
+		return ""
+	}
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		name = f.Name
+	}
+	return name
+}
+
+// EnvVarName returns the environment variable for a JSON path:
+// "security.sessionTTLHours" becomes "MSP_SECURITY_SESSION_TTL_HOURS".
+func EnvVarName(path string) string {
+	var b strings.Builder
+	b.WriteString(EnvPrefix)
+	for i, seg := range strings.Split(path, ".") {
+		if i > 0 {
+			b.WriteByte('_')
+		}
+		r := []rune(seg)
+		for j, c := range r {
+			if j > 0 && unicode.IsUpper(c) &&
+				(!unicode.IsUpper(r[j-1]) || (j+1 < len(r) && unicode.IsLower(r[j+1]))) {
+				b.WriteByte('_')
+			}
+			b.WriteRune(unicode.ToUpper(c))
+		}
+	}
+	return b.String()
+}
+
+// ParseEnv collects the MSP_* variables of environ ("KEY=value" entries, as
+// returned by os.Environ) that name config fields. Empty values count as
+// unset. Other MSP_* variables (MSP_HOME, ...) are ignored.
+func ParseEnv(environ []string) (Env, error) {
+	var env Env
+	for _, kv := range environ {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || v == "" || !strings.HasPrefix(k, EnvPrefix) {
+			continue
+		}
+		path, ok := envFields[k]
+		if !ok {
+			continue
+		}
+		f := EnvField{Path: path, Var: k, value: v}
+		scratch := Default()
+		if err := f.apply(&scratch); err != nil {
+			return nil, err
+		}
+		env = append(env, f)
+	}
+	sort.Slice(env, func(i, j int) bool { return env[i].Path < env[j].Path })
+	return env, nil
+}
+
+// Apply sets every overridden field of cfg.
+func (e Env) Apply(cfg *Config) error {
+	for _, f := range e {
+		if err := f.apply(cfg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f EnvField) apply(cfg *Config) error {
+	if err := setFromEnv(fieldByPath(cfg, f.Path), f.value); err != nil {
+		return fmt.Errorf("%s: invalid value %q: %w", f.Var, f.value, err)
+	}
+	return nil
+}
+
+// Lookup returns the variable that overrides path (or a secret derived from
+// it), if any.
+func (e Env) Lookup(path string) (string, bool) {
+	for _, f := range e {
+		if f.Path == path || derivedSecrets[f.Path] == path {
+			return f.Var, true
+		}
+	}
+	return "", false
+}
+
+// Vars maps the JSON path of every overridden field to its variable.
+func (e Env) Vars() map[string]string {
+	m := make(map[string]string, len(e))
+	for _, f := range e {
+		m[f.Path] = f.Var
+	}
+	return m
+}
+
+// Restore copies the overridden fields (and secrets derived from them) from
+// src into dst. It keeps environment values out of the config file and
+// makes them immune to edits of the effective config.
+func (e Env) Restore(dst *Config, src Config) {
+	for _, f := range e {
+		for _, p := range []string{f.Path, derivedSecrets[f.Path]} {
+			if p != "" {
+				fieldByPath(dst, p).Set(fieldByPath(&src, p))
+			}
+		}
+	}
+}
+
+// Conflicts returns the overridden fields whose value in cfg differs from
+// effective. Secrets are skipped since API responses never contain them.
+func (e Env) Conflicts(cfg, effective Config) []EnvField {
+	var out []EnvField
+	for _, f := range e {
+		if _, secret := derivedSecrets[f.Path]; secret || strings.HasSuffix(f.Path, "Hash") || f.Path == "security.linkSecret" {
+			continue
+		}
+		if !sameValue(fieldByPath(&cfg, f.Path), fieldByPath(&effective, f.Path)) {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+func sameValue(a, b reflect.Value) bool {
+	if a.Kind() == reflect.Slice && a.Len() == 0 && b.Len() == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
+
+// fieldByPath returns the settable field of cfg at a JSON path.
+func fieldByPath(cfg *Config, path string) reflect.Value {
+	v := reflect.ValueOf(cfg).Elem()
+	for _, seg := range strings.Split(path, ".") {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if jsonName(t.Field(i)) == seg {
+				v = v.Field(i)
+				break
+			}
+		}
+	}
+	return v
+}
+
+// setFromEnv parses raw into v. Lists are comma-separated (shares are
+// separated like PATH, since paths may contain commas) or a JSON array.
+func setFromEnv(v reflect.Value, raw string) error {
+	s := strings.TrimSpace(raw)
+	// Start from zero so a JSON list never reuses the file config's array.
+	v.Set(reflect.Zero(v.Type()))
+	switch v.Kind() {
+	case reflect.Ptr:
+		n := reflect.New(v.Type().Elem())
+		if err := setFromEnv(n.Elem(), raw); err != nil {
+			return err
+		}
+		v.Set(n)
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(n))
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	case reflect.Slice:
+		if strings.HasPrefix(s, "[") {
+			return json.Unmarshal([]byte(s), v.Addr().Interface())
+		}
+		elem := v.Type().Elem()
+		sep := ","
+		if elem == reflect.TypeOf(Share{}) {
+			sep = string(os.PathListSeparator)
+		}
+		out := reflect.MakeSlice(v.Type(), 0, 4)
+		for _, part := range strings.Split(s, sep) {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			e := reflect.New(elem).Elem()
+			if elem == reflect.TypeOf(Share{}) {
+				e.Set(reflect.ValueOf(Share{Label: filepath.Base(part), Path: part}))
+			} else if err := setFromEnv(e, part); err != nil {
+				return err
+			}
+			out = reflect.Append(out, e)
+		}
+		v.Set(out)
+	default:
+		return json.Unmarshal([]byte(s), v.Addr().Interface())
+	}
+	return nil
+}
+
+// FieldSources reports where each effective value comes from: SourceEnv for
+// overridden fields, SourceFile for fields present in the config file
+// (fileJSON) and SourceDefault for the rest.
+func FieldSources(fileJSON []byte, env Env) map[string]string {
+	var raw map[string]any
+	_ = json.Unmarshal(fileJSON, &raw)
+	out := map[string]string{}
+	for _, p := range leafPaths(reflect.TypeOf(Config{}), "") {
+		if _, ok := env.Lookup(p); ok {
+			out[p] = SourceEnv
+		} else if hasPath(raw, p) {
+			out[p] = SourceFile
+		} else {
+			out[p] = SourceDefault
+		}
+	}
+	return out
+}
+
+func hasPath(m map[string]any, path string) bool {
+	seg, rest, nested := strings.Cut(path, ".")
+	v, ok := m[seg]
+	if !ok || v == nil {
+		return false
+	}
+	if !nested {
+		return true
+	}
+	sub, ok := v.(map[string]any)
+	return ok && hasPath(sub, rest)
+}
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEnvVarName(t *testing.T) {
+	for path, want := range map[string]string{
+		"port":                     "MSP_PORT",
+		"logLevel":                 "MSP_LOG_LEVEL",
+		"security.pinEnabled":      "MSP_SECURITY_PIN_ENABLED",
+		"security.sessionTTLHours": "MSP_SECURITY_SESSION_TTL_HOURS",
+		"security.ipWhitelist":     "MSP_SECURITY_IP_WHITELIST",
+		"playback.audio.transcode": "MSP_PLAYBACK_AUDIO_TRANSCODE",
+	} {
+		if got := EnvVarName(path); got != want {
+			t.Errorf("EnvVarName(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestParseEnv(t *testing.T) {
+	env, err := ParseEnv([]string{
+		"MSP_PORT=9000",
+		"MSP_SHARES=/srv/movies",
+		"MSP_SECURITY_PIN_ENABLED=true",
+		"MSP_SECURITY_IP_WHITELIST=private, 203.0.113.7",
+		"MSP_UI_SHOW_OTHERS=1",
+		"MSP_FEATURES_SPEED_OPTIONS=[1, 2]",
+		"MSP_LOG_LEVEL=",    // empty counts as unset
+		"MSP_HOME=/ignored", // not a config field
+		"PATH=/usr/bin",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(env) != 6 {
+		t.Fatalf("got %d fields: %+v", len(env), env)
+	}
+
+	cfg := Default()
+	if err := env.Apply(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != 9000 || !cfg.Security.PINEnabled || !*cfg.UI.ShowOthers || cfg.LogLevel != "info" {
+		t.Errorf("scalars not applied: %+v", cfg)
+	}
+	if len(cfg.Shares) != 1 || cfg.Shares[0].Path != "/srv/movies" || cfg.Shares[0].Label != "movies" {
+		t.Errorf("shares = %+v", cfg.Shares)
+	}
+	if strings.Join(cfg.Security.IPWhitelist, "|") != "private|203.0.113.7" {
+		t.Errorf("ipWhitelist = %q", cfg.Security.IPWhitelist)
+	}
+	if len(cfg.Features.SpeedOptions) != 2 || cfg.Features.SpeedOptions[1] != 2 {
+		t.Errorf("speedOptions = %v", cfg.Features.SpeedOptions)
+	}
+
+	if _, err := ParseEnv([]string{"MSP_PORT=eighty"}); err == nil || !strings.Contains(err.Error(), "MSP_PORT") {
+		t.Errorf("invalid value: %v", err)
+	}
+}
+
+func TestEnvRestoreAndConflicts(t *testing.T) {
+	env, err := ParseEnv([]string{"MSP_PORT=9000", "MSP_SECURITY_PIN=1234"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := Default()
+	file.Security.PINHash = "file-hash"
+	eff := file
+	if err := env.Apply(&eff); err != nil {
+		t.Fatal(err)
+	}
+	eff.Security.PINHash = "env-hash"
+
+	// Saving the effective config keeps the file's own port and PIN.
+	out := eff
+	out.LogLevel = "debug"
+	env.Restore(&out, file)
+	if out.Port != 8099 || out.Security.PIN != "0000" || out.Security.PINHash != "file-hash" || out.LogLevel != "debug" {
+		t.Errorf("restored = port %d pin %q hash %q level %q", out.Port, out.Security.PIN, out.Security.PINHash, out.LogLevel)
+	}
+
+	submitted := eff.Redacted()
+	if c := env.Conflicts(submitted, eff); len(c) != 0 {
+		t.Errorf("unchanged config conflicts: %+v", c)
+	}
+	submitted.Port = 9100
+	if c := env.Conflicts(submitted, eff); len(c) != 1 || c[0].Var != "MSP_PORT" {
+		t.Errorf("conflicts = %+v", c)
+	}
+
+	src := FieldSources([]byte(`{"port": 8099, "security": {"pinEnabled": true}}`), env)
+	for path, want := range map[string]string{
+		"port":                SourceEnv,
+		"security.pinHash":    SourceEnv,
+		"security.pinEnabled": SourceFile,
+		"logLevel":            SourceDefault,
+	} {
+		if src[path] != want {
+			t.Errorf("source of %s = %q, want %q", path, src[path], want)
+		}
+	}
+}
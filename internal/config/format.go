@@ -0,0 +1,201 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Format is a config file format.
+type Format string
+
+// Supported config file formats. Keys are the same in every format
+// ("logLevel", "security.pinEnabled", ...).
+const (
+	FormatJSON Format = "json"
+	FormatYAML Format = "yaml"
+	FormatTOML Format = "toml"
+)
+
+// FormatOf detects the format of a config file by its extension; anything
+// other than .yaml, .yml or .toml is JSON.
+func FormatOf(path string) Format {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".toml":
+		return FormatTOML
+	}
+	return FormatJSON
+}
+
+// Load decodes a config file in format f, upgrading older schema versions
+// (see Migrate). It also returns the file as JSON after migration and the
+// applied migrations.
+func Load(b []byte, f Format) (Config, []byte, []Migration, error) {
+	j := b
+	if f != FormatJSON {
+		var err error
+		if j, err = toJSON(b, f); err != nil {
+			return Config{}, nil, nil, err
+		}
+	}
+	j, applied, err := Migrate(j)
+	if err != nil {
+		return Config{}, nil, nil, err
+	}
+	cfg, err := parse(j, f == FormatJSON)
+	if err != nil {
+		return Config{}, nil, nil, err
+	}
+	return cfg, j, applied, nil
+}
+
+// toJSON converts a YAML or TOML document to JSON. Syntax errors report
+// the line (and column, if known) in the original document.
+func toJSON(b []byte, f Format) ([]byte, error) {
+	var m map[string]any
+	switch f {
+	case FormatYAML:
+		if err := yaml.Unmarshal(b, &m); err != nil {
+			return nil, err
+		}
+	case FormatTOML:
+		if err := toml.Unmarshal(b, &m); err != nil {
+			var derr *toml.DecodeError
+			if errors.As(err, &derr) {
+				line, col := derr.Position()
+				return nil, fmt.Errorf("line %d, column %d: %v", line, col, derr)
+			}
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown config format %q", f)
+	}
+	if m == nil {
+		m = map[string]any{} // empty document
+	}
+	return json.Marshal(m)
+}
+
+// Encode writes cfg in format f. For YAML, comments of prev (the file being
+// replaced, may be nil) are kept on the keys that still exist. TOML files
+// are rewritten without comments.
+func Encode(cfg Config, f Format, prev []byte) ([]byte, error) {
+	j, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	switch f {
+	case FormatJSON:
+		return j, nil
+	case FormatYAML:
+		return encodeYAML(j, prev)
+	case FormatTOML:
+		return encodeTOML(j)
+	}
+	return nil, fmt.Errorf("unknown config format %q", f)
+}
+
+func encodeYAML(j, prev []byte) ([]byte, error) {
+	// JSON is YAML, so this keeps the struct's field order.
+	var doc yaml.Node
+	if err := yaml.Unmarshal(j, &doc); err != nil {
+		return nil, err
+	}
+	blockStyle(&doc)
+	var old yaml.Node
+	if len(prev) > 0 && yaml.Unmarshal(prev, &old) == nil {
+		copyComments(&doc, &old)
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// blockStyle turns the flow style JSON was decoded with into block style,
+// except for empty collections, which stay "[]" and "{}".
+func blockStyle(n *yaml.Node) {
+	if (n.Kind == yaml.SequenceNode || n.Kind == yaml.MappingNode) && len(n.Content) > 0 {
+		n.Style = 0
+	}
+	if n.Kind == yaml.ScalarNode && n.Style == yaml.DoubleQuotedStyle {
+		n.Style = 0 // quoted again by the encoder where needed
+	}
+	for _, c := range n.Content {
+		blockStyle(c)
+	}
+}
+
+// copyComments copies the comments of old onto the matching nodes of n:
+// mapping entries are matched by key, sequence items by index.
+func copyComments(n, old *yaml.Node) {
+	n.HeadComment, n.LineComment, n.FootComment = old.HeadComment, old.LineComment, old.FootComment
+	switch {
+	case n.Kind == yaml.DocumentNode && old.Kind == yaml.DocumentNode && len(n.Content) > 0 && len(old.Content) > 0:
+		copyComments(n.Content[0], old.Content[0])
+	case n.Kind == yaml.MappingNode && old.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			for k := 0; k+1 < len(old.Content); k += 2 {
+				if n.Content[i].Value == old.Content[k].Value {
+					copyComments(n.Content[i], old.Content[k])
+					copyComments(n.Content[i+1], old.Content[k+1])
+					break
+				}
+			}
+		}
+	case n.Kind == yaml.SequenceNode && old.Kind == yaml.SequenceNode:
+		for i := 0; i < len(n.Content) && i < len(old.Content); i++ {
+			copyComments(n.Content[i], old.Content[i])
+		}
+	}
+}
+
+func encodeTOML(j []byte) ([]byte, error) {
+	d := json.NewDecoder(bytes.NewReader(j))
+	d.UseNumber()
+	var m map[string]any
+	if err := d.Decode(&m); err != nil {
+		return nil, err
+	}
+	return toml.Marshal(tomlValue(m))
+}
+
+// tomlValue prepares decoded JSON for TOML, which has no null and tells
+// integers from floats.
+func tomlValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			if e == nil {
+				delete(v, k)
+			} else {
+				v[k] = tomlValue(e)
+			}
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = tomlValue(e)
+		}
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	return v
+}
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormatOf(t *testing.T) {
+	for path, want := range map[string]Format{
+		"config.json":       FormatJSON,
+		"/etc/msp/msp.YAML": FormatYAML,
+		"config.yml":        FormatYAML,
+		"config.toml":       FormatTOML,
+		"config":            FormatJSON,
+	} {
+		if got := FormatOf(path); got != want {
+			t.Errorf("FormatOf(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestLoadFormats(t *testing.T) {
+	for f, doc := range map[Format]string{
+		FormatYAML: "port: 9000\nshares:\n  - label: Movies\n    path: /srv/movies\nsecurity:\n  ipWhitelist: [private]\n",
+		FormatTOML: "port = 9000\n[security]\nipWhitelist = ['private']\n[[shares]]\nlabel = 'Movies'\npath = '/srv/movies'\n",
+	} {
+		c, _, applied, err := Load([]byte(doc), f)
+		if err != nil {
+			t.Fatalf("%s: %v", f, err)
+		}
+		if c.Port != 9000 || len(c.Shares) != 1 || c.Shares[0].Label != "Movies" || len(c.Security.IPWhitelist) != 1 || len(applied) == 0 {
+			t.Errorf("%s: %+v", f, c)
+		}
+
+		// What Encode writes, Load reads back.
+		ApplyDefaults(&c)
+		b, err := Encode(c, f, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if back, _, _, err := Load(b, f); err != nil || back.Port != 9000 || back.Features.SpeedOptions[0] != 0.5 {
+			t.Errorf("%s round trip: %v\n%s", f, err, b)
+		}
+	}
+
+	if _, _, _, err := Load([]byte("port = 9000\nlogLevel = \n"), FormatTOML); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Expected TOML syntax error on line 2, got %v", err)
+	}
+	_, _, _, err := Load([]byte("port: [1]\n"), FormatYAML)
+	if f := FieldErrors(err); len(f) != 1 || f[0].Field != "port" || strings.Contains(f[0].Message, "JSON") {
+		t.Errorf("Expected type error for port without JSON position, got %v", err)
+	}
+}
+
+func TestEncodeYAMLKeepsComments(t *testing.T) {
+	prev := []byte("# MSP config\nport: 8099 # change me\nsecurity:\n  # LAN only\n  ipWhitelist: [private]\n")
+	c, _, _, err := Load(prev, FormatYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ApplyDefaults(&c)
+	c.Port = 9000
+	b, err := Encode(c, FormatYAML, prev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(b)
+	for _, want := range []string{"# MSP config", "port: 9000 # change me", "# LAN only", "- private"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// CurrentVersion is the config schema version written by this build. Bump it
+// together with a new entry in migrations whenever a field is renamed,
+// moved or changes its meaning.
+const CurrentVersion = 1
+
+// Migration upgrades a config file from schema version From to From+1.
+// It works on the decoded JSON object, so it can still read fields that no
+// longer exist in Config.
+type Migration struct {
+	From        int
+	Description string
+	Apply       func(m map[string]any) error
+}
+
+// migrations is the upgrade chain: entry i upgrades version i to i+1, so
+// its length must equal CurrentVersion.
+var migrations = []Migration{
+	{
+		From:        0,
+		Description: "record the schema version in unversioned config files",
+		Apply:       func(map[string]any) error { return nil },
+	},
+}
+
+// Migrate upgrades the config file b to CurrentVersion and returns the new
+// file content and the applied migrations. Files without "configVersion"
+// are version 0. If nothing needs to change, b is returned as is; so is
+// malformed JSON, which Parse reports in detail.
+func Migrate(b []byte) ([]byte, []Migration, error) {
+	return migrate(b, migrations)
+}
+
+// migrate upgrades b along chain to version len(chain).
+func migrate(b []byte, chain []Migration) ([]byte, []Migration, error) {
+	var m map[string]any
+	if err := json.Unmarshal(b, &m); err != nil || m == nil {
+		return b, nil, nil
+	}
+	version := 0
+	if v, ok := m["configVersion"]; ok && v != nil {
+		f, ok := v.(float64)
+		if !ok || f < 0 || f != float64(int(f)) {
+			return nil, nil, ValidationError{{Field: "configVersion", Message: "must be a non-negative integer"}}
+		}
+		version = int(f)
+	}
+	if version > len(chain) {
+		return nil, nil, fmt.Errorf("config version %d is newer than this build supports (%d); upgrade msp", version, len(chain))
+	}
+
+	var applied []Migration
+	for ; version < len(chain); version++ {
+		mig := chain[version]
+		if err := mig.Apply(m); err != nil {
+			return nil, nil, fmt.Errorf("migrating config from version %d: %w", mig.From, err)
+		}
+		m["configVersion"] = version + 1
+		applied = append(applied, mig)
+	}
+	if len(applied) == 0 {
+		return b, nil, nil
+	}
+	out, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, nil, err
+	}
+	return out, applied, nil
+}
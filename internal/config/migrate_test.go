@@ -0,0 +1,56 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	if len(migrations) != CurrentVersion {
+		t.Fatalf("%d migrations for version %d", len(migrations), CurrentVersion)
+	}
+	for i, m := range migrations {
+		if m.From != i {
+			t.Errorf("migrations[%d].From = %d", i, m.From)
+		}
+	}
+
+	out, applied, err := Migrate([]byte(`{"port": 9000}`))
+	if err != nil || len(applied) != CurrentVersion {
+		t.Fatalf("Migrate: %d applied, %v", len(applied), err)
+	}
+	if c, err := Parse(out); err != nil || c.ConfigVersion != CurrentVersion || c.Port != 9000 {
+		t.Errorf("migrated config: %+v, %v", c, err)
+	}
+	if _, applied, _ := Migrate(out); len(applied) != 0 {
+		t.Error("current config should not be migrated again")
+	}
+	if _, _, err := Migrate([]byte(`{"configVersion": 99}`)); err == nil {
+		t.Error("Expected newer config version to be rejected")
+	}
+}
+
+func TestMigrateChain(t *testing.T) {
+	chain := []Migration{
+		{From: 0, Apply: func(map[string]any) error { return nil }},
+		{From: 1, Apply: func(m map[string]any) error {
+			// e.g. a renamed field
+			if v, ok := m["logfile"]; ok {
+				m["logFile"] = v
+				delete(m, "logfile")
+			}
+			return nil
+		}},
+	}
+	out, applied, err := migrate([]byte(`{"configVersion": 1, "logfile": "/var/log/msp.log"}`), chain)
+	if err != nil || len(applied) != 1 || applied[0].From != 1 {
+		t.Fatalf("migrate: %+v, %v", applied, err)
+	}
+	var m map[string]any
+	if err := json.Unmarshal(out, &m); err != nil {
+		t.Fatal(err)
+	}
+	if m["logFile"] != "/var/log/msp.log" || m["logfile"] != nil || m["configVersion"] != float64(2) {
+		t.Errorf("migrated = %v", m)
+	}
+}
@@ -0,0 +1,392 @@
+package config
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"msp/internal/ipmatch"
+	"msp/internal/pinhash"
+)
+
+// FieldError is a validation error of one config field.
+type FieldError struct {
+	Field   string `json:"field"` // JSON path, e.g. "shares[1].path"
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string { return e.Field + ": " + e.Message }
+
+// ValidationError lists every invalid field of a config.
+type ValidationError []FieldError
+
+func (e ValidationError) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// FieldErrors returns the field errors wrapped in err, if any.
+func FieldErrors(err error) []FieldError {
+	var ve ValidationError
+	if errors.As(err, &ve) {
+		return ve
+	}
+	return nil
+}
+
+type validator struct {
+	errs ValidationError
+}
+
+func (v *validator) add(field, format string, args ...any) {
+	v.errs = append(v.errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.errs
+}
+
+// Validate checks the whole config and reports every invalid field.
+// The error is a ValidationError. Clashing shares are not errors here, see
+// ValidateShares.
+func Validate(cfg Config) error {
+	var v validator
+	v.base(cfg)
+	v.shares(cfg.Shares)
+	v.blacklist(cfg.Blacklist)
+	v.security(cfg.Security)
+	v.tls(cfg)
+	v.metrics(cfg.Metrics)
+	return v.err()
+}
+
+// ValidateShares reports duplicate labels and share roots that are equal to
+// or nested inside another share (their files would be indexed twice).
+// Clashes between two shares that are unchanged from accepted, the shares
+// of the running config, are left out: older versions allowed them, so a
+// config that already has them keeps loading and saving. With no accepted
+// shares every clash is reported, which the server logs as warnings.
+func ValidateShares(shares, accepted []Share) error {
+	var v validator
+	v.shareClashes(shares, accepted)
+	return v.err()
+}
+
+// ValidateSecurity checks that every IP whitelist/blacklist/trusted proxy
+// entry is a valid IP, CIDR range or named range, and that stored secrets
+// are well-formed.
+func ValidateSecurity(sec SecurityConfig) error {
+	var v validator
+	v.security(sec)
+	return v.err()
+}
+
+// ValidateTLS checks that a user-supplied certificate has both files and
+// that the redirect port does not clash with the main port.
+func ValidateTLS(cfg Config) error {
+	var v validator
+	v.tls(cfg)
+	return v.err()
+}
+
+var logLevels = []string{"debug", "info", "warn", "error", "none"}
+
+func (v *validator) base(cfg Config) {
+	if cfg.ConfigVersion > CurrentVersion {
+		v.add("configVersion", "newer than this build supports (%d)", CurrentVersion)
+	}
+	if cfg.Port < 1 || cfg.Port > 65535 {
+		v.add("port", "must be between 1 and 65535")
+	}
+	level := strings.ToLower(cfg.LogLevel)
+	valid := false
+	for _, l := range logLevels {
+		valid = valid || level == l
+	}
+	if !valid {
+		v.add("logLevel", "must be one of %s", strings.Join(logLevels, ", "))
+	}
+	if f := strings.ToLower(cfg.LogFormat); f != "text" && f != "json" {
+		v.add("logFormat", "must be text or json")
+	}
+	if cfg.LogRotate.MaxSizeMB < 0 {
+		v.add("logRotate.maxSizeMB", "must not be negative")
+	}
+	if cfg.LogRotate.MaxBackups < 0 {
+		v.add("logRotate.maxBackups", "must not be negative")
+	}
+	if cfg.LogRotate.MaxAgeDays < 0 {
+		v.add("logRotate.maxAgeDays", "must not be negative")
+	}
+	if cfg.MaxItems < 0 {
+		v.add("maxItems", "must not be negative")
+	}
+	for i, s := range cfg.Features.SpeedOptions {
+		if s <= 0 || s > 16 {
+			v.add(fmt.Sprintf("features.speedOptions[%d]", i), "must be greater than 0 and at most 16")
+		}
+	}
+}
+
+// shares rejects empty paths.
+func (v *validator) shares(shares []Share) {
+	for i, sh := range shares {
+		if strings.TrimSpace(sh.Path) == "" {
+			v.add(fmt.Sprintf("shares[%d].path", i), "must not be empty")
+		}
+	}
+}
+
+// shareClashes reports duplicate labels and equal or nested roots, except
+// between two shares found unchanged in accepted.
+func (v *validator) shareClashes(shares, accepted []Share) {
+	roots := make([]string, len(shares))
+	labels := make([]string, len(shares))
+	known := make([]bool, len(shares))
+	for i, sh := range shares {
+		if p := strings.TrimSpace(sh.Path); p != "" {
+			roots[i] = filepath.Clean(p)
+		}
+		labels[i] = strings.ToLower(strings.TrimSpace(sh.Label))
+		for _, a := range accepted {
+			if a.Label == sh.Label && samePathString(filepath.Clean(strings.TrimSpace(a.Path)), roots[i]) {
+				known[i] = true
+				break
+			}
+		}
+	}
+	for i, sh := range shares {
+		for j := 0; j < i; j++ {
+			if known[i] && known[j] {
+				continue
+			}
+			if labels[i] != "" && labels[i] == labels[j] {
+				v.add(fmt.Sprintf("shares[%d].label", i), "duplicate of shares[%d].label %q", j, sh.Label)
+				break
+			}
+		}
+	}
+	for i := range roots {
+		for j := 0; j < i; j++ {
+			if roots[i] == "" || roots[j] == "" || known[i] && known[j] {
+				continue
+			}
+			switch {
+			case samePathString(roots[i], roots[j]):
+				v.add(fmt.Sprintf("shares[%d].path", i), "same folder as shares[%d]", j)
+			case nestedPath(roots[j], roots[i]):
+				v.add(fmt.Sprintf("shares[%d].path", i), "inside shares[%d] (%s)", j, roots[j])
+			case nestedPath(roots[i], roots[j]):
+				v.add(fmt.Sprintf("shares[%d].path", i), "contains shares[%d] (%s)", j, roots[j])
+			}
+		}
+	}
+}
+
+func samePathString(a, b string) bool {
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+// nestedPath reports whether p lies strictly inside root.
+func nestedPath(root, p string) bool {
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		root, p = strings.ToLower(root), strings.ToLower(p)
+	}
+	rel, err := filepath.Rel(root, p)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+// blacklist checks /regex/ rules and the size rule.
+func (v *validator) blacklist(bl BlacklistConfig) {
+	for _, l := range []struct {
+		name  string
+		rules []string
+	}{
+		{"extensions", bl.Extensions},
+		{"filenames", bl.Filenames},
+		{"folders", bl.Folders},
+	} {
+		for i, rule := range l.rules {
+			rule = strings.TrimSpace(rule)
+			if len(rule) > 2 && strings.HasPrefix(rule, "/") && strings.HasSuffix(rule, "/") {
+				if _, err := regexp.Compile(rule[1 : len(rule)-1]); err != nil {
+					v.add(fmt.Sprintf("blacklist.%s[%d]", l.name, i), "invalid regular expression: %v", err)
+				}
+			}
+		}
+	}
+	if err := checkSizeRule(bl.SizeRule); err != nil {
+		v.add("blacklist.sizeRule", "%v", err)
+	}
+}
+
+var sizeRuleUnits = []struct {
+	suffix string
+	bytes  float64
+}{{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}}
+
+// checkSizeRule validates "<100MB", ">=1GB", "10MB-2GB" and the like.
+func checkSizeRule(rule string) error {
+	rule = strings.TrimSpace(strings.ToUpper(rule))
+	if rule == "" {
+		return nil
+	}
+	const format = `expected "<SIZE", "<=SIZE", ">SIZE", ">=SIZE" or "MIN-MAX" (e.g. "<100MB", "10MB-2GB")`
+	if lo, hi, ok := strings.Cut(rule, "-"); ok {
+		min, err1 := parseSizeStrict(lo)
+		max, err2 := parseSizeStrict(hi)
+		if err1 != nil || err2 != nil {
+			return errors.New(format)
+		}
+		if max <= 0 || min > max {
+			return fmt.Errorf("range %q is empty", rule)
+		}
+		return nil
+	}
+	for _, op := range []string{">=", "<=", ">", "<"} {
+		if rest, ok := strings.CutPrefix(rule, op); ok {
+			if _, err := parseSizeStrict(rest); err != nil {
+				return errors.New(format)
+			}
+			return nil
+		}
+	}
+	return errors.New(format)
+}
+
+func parseSizeStrict(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	unit := 1.0
+	for _, u := range sizeRuleUnits {
+		if rest, ok := strings.CutSuffix(s, u.suffix); ok {
+			s, unit = strings.TrimSpace(rest), u.bytes
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * unit, nil
+}
+
+func (v *validator) security(sec SecurityConfig) {
+	for _, l := range []struct {
+		name    string
+		entries []string
+	}{
+		{"ipWhitelist", sec.IPWhitelist},
+		{"ipBlacklist", sec.IPBlacklist},
+		{"trustedProxies", sec.TrustedProxies},
+	} {
+		for i, e := range l.entries {
+			if strings.TrimSpace(e) == "" {
+				continue
+			}
+			if _, err := ipmatch.ParseRule(e); err != nil {
+				v.add(fmt.Sprintf("security.%s[%d]", l.name, i), "%v", err)
+			}
+		}
+	}
+	for i, o := range sec.AllowedOrigins {
+		if NormalizeOrigin(o) == "" {
+			v.add(fmt.Sprintf("security.allowedOrigins[%d]", i), "invalid origin %q (expected scheme://host[:port])", o)
+		}
+	}
+	if sec.PINHash != "" && !pinhash.IsHash(sec.PINHash) {
+		v.add("security.pinHash", "%v", pinhash.ErrInvalidHash)
+	}
+	if sec.AdminPINHash != "" && !pinhash.IsHash(sec.AdminPINHash) {
+		v.add("security.adminPinHash", "%v", pinhash.ErrInvalidHash)
+	}
+	if sec.LinkSecret != "" {
+		if b, err := hex.DecodeString(sec.LinkSecret); err != nil || len(b) < 16 {
+			v.add("security.linkSecret", "must be at least 16 hex-encoded bytes")
+		}
+	}
+	if sec.SessionTTLHours < 0 {
+		v.add("security.sessionTTLHours", "must not be negative")
+	}
+	if sec.LockoutMinutes < 0 {
+		v.add("security.lockoutMinutes", "must not be negative")
+	}
+}
+
+func (v *validator) tls(cfg Config) {
+	if (cfg.TLS.CertFile == "") != (cfg.TLS.KeyFile == "") {
+		v.add("tls", "certFile and keyFile must be set together")
+	}
+	if cfg.TLS.RedirectPort < 0 || cfg.TLS.RedirectPort > 65535 {
+		v.add("tls.redirectPort", "out of range")
+	} else if cfg.TLS.RedirectPort != 0 && cfg.TLS.RedirectPort == cfg.Port {
+		v.add("tls.redirectPort", "must differ from port")
+	}
+}
+
+func (v *validator) metrics(m MetricsConfig) {
+	for i, e := range m.AllowIPs {
+		if strings.TrimSpace(e) == "" {
+			continue
+		}
+		if _, err := ipmatch.ParseRule(e); err != nil {
+			v.add(fmt.Sprintf("metrics.allowIPs[%d]", i), "%v", err)
+		}
+	}
+}
+
+// Parse decodes a config file. Syntax errors report the line and column,
+// type errors the field path.
+func Parse(b []byte) (Config, error) {
+	return parse(b, true)
+}
+
+// parse decodes JSON; positions are left out of type errors when b was
+// converted from another format and they would not match the file.
+func parse(b []byte, positions bool) (Config, error) {
+	var cfg Config
+	err := json.Unmarshal(b, &cfg)
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case err == nil:
+		return cfg, nil
+	case errors.As(err, &syntaxErr):
+		line, col := lineCol(b, syntaxErr.Offset)
+		return Config{}, fmt.Errorf("line %d, column %d: %v", line, col, syntaxErr)
+	case errors.As(err, &typeErr):
+		msg := fmt.Sprintf("expected %s, got %s", typeErr.Type, typeErr.Value)
+		if positions {
+			line, col := lineCol(b, typeErr.Offset)
+			msg = fmt.Sprintf("expected %s, got JSON %s (line %d, column %d)", typeErr.Type, typeErr.Value, line, col)
+		}
+		return Config{}, ValidationError{{Field: typeErr.Field, Message: msg}}
+	}
+	return Config{}, err
+}
+
+func lineCol(b []byte, offset int64) (line, col int) {
+	line, col = 1, 1
+	for i := int64(0); i < offset && i < int64(len(b)); i++ {
+		if b[i] == '\n' {
+			line, col = line+1, 1
+		} else {
+			col++
+		}
+	}
+	return line, col
+}
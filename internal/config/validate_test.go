@@ -0,0 +1,98 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateFields(t *testing.T) {
+	c := Default()
+	c.Port = 70000
+	c.LogLevel = "verbose"
+	c.LogRotate.MaxAgeDays = -1
+	c.Shares = []Share{
+		{Label: "Movies", Path: "/srv/media/movies"},
+		{Label: "movies", Path: "/srv/media"},
+		{Label: "Music", Path: ""},
+	}
+	c.Blacklist.Filenames = []string{"sample", "/[a-/"}
+	c.Blacklist.SizeRule = "100MB"
+	c.Security.IPWhitelist = []string{"192.168.1.0/24", "10.0.0.0/40"}
+
+	want := []string{
+		"port",
+		"logLevel",
+		"logRotate.maxAgeDays",
+		"shares[2].path",
+		"blacklist.filenames[1]",
+		"blacklist.sizeRule",
+		"security.ipWhitelist[1]",
+	}
+	fields := FieldErrors(Validate(c))
+	got := make([]string, len(fields))
+	for i, f := range fields {
+		got[i] = f.Field
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("fields = %v, want %v", got, want)
+	}
+
+	ok := Default()
+	for _, rule := range []string{"", "<100MB", ">= 1.5GB", "10MB-2GB", "<512"} {
+		ok.Blacklist.SizeRule = rule
+		if err := Validate(ok); err != nil {
+			t.Errorf("size rule %q: %v", rule, err)
+		}
+	}
+	ok.Blacklist.SizeRule = "2GB-10MB"
+	if err := Validate(ok); err == nil {
+		t.Error("Expected empty size range to be rejected")
+	}
+}
+
+func TestValidateShares(t *testing.T) {
+	shares := []Share{
+		{Label: "Movies", Path: "/a/Movies"},
+		{Label: "movies", Path: "/b/Movies"},
+		{Label: "Media", Path: "/a"},
+	}
+	fields := func(err error) string {
+		var got []string
+		for _, f := range FieldErrors(err) {
+			got = append(got, f.Field)
+		}
+		return strings.Join(got, " ")
+	}
+	if got := fields(ValidateShares(shares, nil)); got != "shares[1].label shares[2].path" {
+		t.Errorf("all clashes: %s", got)
+	}
+
+	// Clashes an older version saved are accepted; new ones are not
+	if err := ValidateShares(shares, shares); err != nil {
+		t.Errorf("Expected accepted shares to pass, got %v", err)
+	}
+	added := append(shares[:3:3], Share{Label: "Movies", Path: "/a/Movies/4K"})
+	if got := fields(ValidateShares(added, shares)); got != "shares[3].label shares[3].path shares[3].path" {
+		t.Errorf("new share: %s", got)
+	}
+
+	c := Default()
+	c.Shares = shares
+	c.Features.SpeedOptions = []float64{0}
+	if f := FieldErrors(Validate(c)); len(f) != 1 || f[0].Message != "must be greater than 0 and at most 16" {
+		t.Errorf("Validate: %+v", f)
+	}
+}
+
+func TestParse(t *testing.T) {
+	if _, err := Parse([]byte("{\n  \"port\": 8099,\n  \"logLevel\": \"info\"\n  \"maxItems\": 1\n}")); err == nil || !strings.Contains(err.Error(), "line 4") {
+		t.Errorf("Expected syntax error on line 4, got %v", err)
+	}
+	_, err := Parse([]byte(`{"port": "8099"}`))
+	if f := FieldErrors(err); len(f) != 1 || f[0].Field != "port" {
+		t.Errorf("Expected type error for port, got %v", err)
+	}
+	if c, err := Parse([]byte(`{"port": 9000}`)); err != nil || c.Port != 9000 {
+		t.Errorf("Parse = %+v, %v", c.Port, err)
+	}
+}
@@ -0,0 +1,97 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"msp/internal/types"
+)
+
+// AuditFilter selects audit events; zero fields match everything.
+type AuditFilter struct {
+	Types  []string
+	IP     string
+	Actor  string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+	Offset int
+}
+
+func AddAuditEvent(ctx context.Context, ev *types.AuditEvent) error {
+	if DB == nil {
+		return ErrNoDB
+	}
+	return DB.WithContext(ctx).Create(ev).Error
+}
+
+// ListAuditEvents returns matching events newest first, and the total number
+// of matches ignoring Limit and Offset.
+func ListAuditEvents(ctx context.Context, f AuditFilter) ([]types.AuditEvent, int64, error) {
+	if DB == nil {
+		return []types.AuditEvent{}, 0, nil
+	}
+	q := DB.WithContext(ctx).Model(&types.AuditEvent{})
+	if len(f.Types) > 0 {
+		q = q.Where("type IN ?", f.Types)
+	}
+	if f.IP != "" {
+		q = q.Where("ip = ?", f.IP)
+	}
+	if f.Actor != "" {
+		q = q.Where("lower(actor) = lower(?)", f.Actor)
+	}
+	if !f.Since.IsZero() {
+		q = q.Where("time >= ?", f.Since)
+	}
+	if !f.Until.IsZero() {
+		q = q.Where("time < ?", f.Until)
+	}
+
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	out := []types.AuditEvent{}
+	q = q.Order("time desc, id desc").Offset(f.Offset)
+	if f.Limit > 0 {
+		q = q.Limit(f.Limit)
+	}
+	err := q.Find(&out).Error
+	return out, total, err
+}
+
+// HasAuditEvent reports whether an event of the given type exists for ip.
+func HasAuditEvent(ctx context.Context, typ, ip string) (bool, error) {
+	if DB == nil {
+		return false, nil
+	}
+	var n int64
+	err := DB.WithContext(ctx).Model(&types.AuditEvent{}).Where("type = ? AND ip = ?", typ, ip).Limit(1).Count(&n).Error
+	return n > 0, err
+}
+
+// PruneAuditEvents deletes events older than before (unless before is zero)
+// and, if maxEvents > 0, all but the newest maxEvents events.
+func PruneAuditEvents(ctx context.Context, before time.Time, maxEvents int) (int64, error) {
+	if DB == nil {
+		return 0, nil
+	}
+	var n int64
+	if !before.IsZero() {
+		res := DB.WithContext(ctx).Where("time < ?", before).Delete(&types.AuditEvent{})
+		if res.Error != nil {
+			return 0, res.Error
+		}
+		n = res.RowsAffected
+	}
+	if maxEvents > 0 {
+		res := DB.WithContext(ctx).Exec(
+			"DELETE FROM audit_events WHERE id NOT IN (SELECT id FROM audit_events ORDER BY time DESC, id DESC LIMIT ?)", maxEvents)
+		if res.Error != nil {
+			return n, res.Error
+		}
+		n += res.RowsAffected
+	}
+	return n, nil
+}
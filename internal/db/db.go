@@ -2,11 +2,12 @@ package db
 
 import (
 	"context"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
 	"msp/internal/types"
 	"os"
 	"path/filepath"
-	"time"
 
 	"github.com/glebarez/sqlite"
 	"gorm.io/gorm"
@@ -16,17 +17,8 @@ import (
 
 var DB *gorm.DB
 
-func newGormLogger() logger.Interface {
-	return logger.New(
-		log.New(log.Writer(), "", log.LstdFlags|log.Lmicroseconds),
-		logger.Config{
-			SlowThreshold:             2 * time.Second,
-			LogLevel:                  logger.Error,
-			IgnoreRecordNotFoundError: true,
-			Colorful:                  false,
-		},
-	)
-}
+// openedPath is the database file opened by Init, for Size.
+var openedPath string
 
 func Init(dbPath string) error {
 	if dbPath == "" {
@@ -48,6 +40,10 @@ func Init(dbPath string) error {
 	if err != nil {
 		return err
 	}
+	openedPath = dbPath
+	if err := registerMetrics(DB); err != nil {
+		return err
+	}
 
 	// 连接池性能调优
 	sqlDB, err := DB.DB()
@@ -56,39 +52,74 @@ func Init(dbPath string) error {
 		sqlDB.SetMaxIdleConns(1)
 
 		if _, err := sqlDB.Exec("PRAGMA journal_mode=WAL;"); err != nil {
-			log.Printf("DB Warn: failed to set WAL mode: %v", err)
+			slog.Warn("Failed to set WAL mode", "err", err)
 		}
 		if _, err := sqlDB.Exec("PRAGMA synchronous=NORMAL;"); err != nil {
-			log.Printf("DB Warn: failed to set synchronous mode: %v", err)
+			slog.Warn("Failed to set synchronous mode", "err", err)
 		}
 		if _, err := sqlDB.Exec("PRAGMA cache_size=-2000;"); err != nil {
-			log.Printf("DB Warn: failed to set cache size: %v", err)
+			slog.Warn("Failed to set cache size", "err", err)
 		}
 	}
 
-	return DB.AutoMigrate(&types.MediaItem{}, &types.MediaScan{}, &types.UserPref{}, &types.PlaybackProgress{})
+	if err := migrateUserScoped(&types.PlaybackProgress{}, "playback_progresses", "media_id, time, updated_at"); err != nil {
+		return err
+	}
+	if err := migrateUserScoped(&types.UserPref{}, "user_prefs", "key, value, updated_at"); err != nil {
+		return err
+	}
+
+	return DB.AutoMigrate(&types.MediaItem{}, &types.MediaScan{}, &types.UserPref{}, &types.PlaybackProgress{}, &types.Session{},
+		&types.User{}, &types.Favorite{}, &types.HistoryEntry{}, &types.ShareLink{}, &types.AuditEvent{}, &types.APIToken{})
 }
 
-func GetProgress(ctx context.Context, mediaID string) (float64, error) {
+// migrateUserScoped rebuilds a table created before per-user data existed
+// with the (user_id, ...) primary key. Existing rows become the shared
+// profile (user_id 0).
+func migrateUserScoped(model any, table string, cols string) error {
+	m := DB.Migrator()
+	if !m.HasTable(table) || m.HasColumn(model, "UserID") {
+		return nil
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		legacy := table + "_legacy"
+		if err := tx.Migrator().RenameTable(table, legacy); err != nil {
+			return err
+		}
+		if err := tx.Migrator().CreateTable(model); err != nil {
+			return err
+		}
+		//nolint:gosec // Table and column names are constants
+		q := fmt.Sprintf("INSERT INTO %s (user_id, %s) SELECT 0, %s FROM %s", table, cols, cols, legacy)
+		if err := tx.Exec(q).Error; err != nil {
+			return err
+		}
+		return tx.Migrator().DropTable(legacy)
+	})
+}
+
+func GetProgress(ctx context.Context, userID uint, mediaID string) (float64, error) {
 	if DB == nil || mediaID == "" {
 		return 0, nil
 	}
 	var p types.PlaybackProgress
 	// Use silent logger to avoid "record not found" spam in logs
-	err := DB.Session(&gorm.Session{Logger: logger.Default.LogMode(logger.Silent)}).WithContext(ctx).First(&p, "media_id = ?", mediaID).Error
+	err := DB.Session(&gorm.Session{Logger: logger.Default.LogMode(logger.Silent)}).WithContext(ctx).
+		First(&p, "user_id = ? AND media_id = ?", userID, mediaID).Error
 	if err == gorm.ErrRecordNotFound {
 		return 0, nil
 	}
 	return p.Time, err
 }
 
-func SetProgress(ctx context.Context, mediaID string, t float64) error {
+func SetProgress(ctx context.Context, userID uint, mediaID string, t float64) error {
 	if DB == nil || mediaID == "" {
 		return nil
 	}
 	return DB.WithContext(ctx).Clauses(clause.OnConflict{
 		UpdateAll: true,
 	}).Create(&types.PlaybackProgress{
+		UserID:  userID,
 		MediaID: mediaID,
 		Time:    t,
 	}).Error
@@ -195,12 +226,12 @@ func CountMediaItems(ctx context.Context, scanID int64, kind string) (int, error
 	return int(count), err
 }
 
-func GetAllPrefs(ctx context.Context) (map[string]string, error) {
+func GetAllPrefs(ctx context.Context, userID uint) (map[string]string, error) {
 	if DB == nil {
 		return map[string]string{}, nil
 	}
 	var prefs []types.UserPref
-	if err := DB.WithContext(ctx).Find(&prefs).Error; err != nil {
+	if err := DB.WithContext(ctx).Where("user_id = ?", userID).Find(&prefs).Error; err != nil {
 		return nil, err
 	}
 	out := make(map[string]string, len(prefs))
@@ -210,7 +241,7 @@ func GetAllPrefs(ctx context.Context) (map[string]string, error) {
 	return out, nil
 }
 
-func SetPrefs(ctx context.Context, kv map[string]string) error {
+func SetPrefs(ctx context.Context, userID uint, kv map[string]string) error {
 	if DB == nil || len(kv) == 0 {
 		return nil
 	}
@@ -220,7 +251,7 @@ func SetPrefs(ctx context.Context, kv map[string]string) error {
 		if k == "" {
 			continue
 		}
-		prefs = append(prefs, types.UserPref{Key: k, Value: v})
+		prefs = append(prefs, types.UserPref{UserID: userID, Key: k, Value: v})
 	}
 
 	return DB.WithContext(ctx).Clauses(clause.OnConflict{
@@ -228,6 +259,14 @@ func SetPrefs(ctx context.Context, kv map[string]string) error {
 	}).Create(&prefs).Error
 }
 
+// Vacuum rebuilds the database file to reclaim free pages.
+func Vacuum(ctx context.Context) error {
+	if DB == nil {
+		return ErrNoDB
+	}
+	return DB.WithContext(ctx).Exec("VACUUM").Error
+}
+
 func Close() {
 	if DB != nil {
 		sqlDB, _ := DB.DB()
@@ -236,3 +275,30 @@ func Close() {
 		}
 	}
 }
+
+// Ping checks that the database is open and answers queries.
+func Ping(ctx context.Context) error {
+	if DB == nil {
+		return errors.New("database not open")
+	}
+	sqlDB, err := DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+// Size returns the size in bytes of the database file including its
+// write-ahead log, or 0 if no database is open.
+func Size() int64 {
+	if DB == nil || openedPath == "" {
+		return 0
+	}
+	var n int64
+	for _, p := range []string{openedPath, openedPath + "-wal"} {
+		if fi, err := os.Stat(p); err == nil {
+			n += fi.Size()
+		}
+	}
+	return n
+}
@@ -1,6 +1,16 @@
 package db
 
-import "testing"
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"msp/internal/types"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
 
 func TestDBStatus(t *testing.T) {
 	// Simple placeholder to satisfy go test
@@ -8,3 +18,109 @@ func TestDBStatus(t *testing.T) {
 		t.Log("DB initialized")
 	}
 }
+
+func TestMigrateUserScoped(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "msp.db")
+
+	// Create tables with the pre-user schema
+	legacy, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		"CREATE TABLE playback_progresses (media_id text PRIMARY KEY, time real NOT NULL, updated_at datetime)",
+		"INSERT INTO playback_progresses VALUES ('m1', 42.5, CURRENT_TIMESTAMP)",
+		"CREATE TABLE user_prefs (key text PRIMARY KEY, value text, updated_at datetime)",
+		"INSERT INTO user_prefs VALUES ('lang', 'zh', CURRENT_TIMESTAMP)",
+	} {
+		if err := legacy.Exec(q).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	sqlDB, _ := legacy.DB()
+	_ = sqlDB.Close()
+
+	if err := Init(path); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	t.Cleanup(func() {
+		Close()
+		DB = nil
+	})
+	ctx := context.Background()
+
+	// Legacy rows become the shared profile
+	if got, _ := GetProgress(ctx, 0, "m1"); got != 42.5 {
+		t.Errorf("Expected migrated progress 42.5, got %v", got)
+	}
+	prefs, _ := GetAllPrefs(ctx, 0)
+	if prefs["lang"] != "zh" {
+		t.Errorf("Expected migrated pref, got %v", prefs)
+	}
+
+	// Per-user rows are isolated
+	if err := SetProgress(ctx, 7, "m1", 10); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := GetProgress(ctx, 0, "m1"); got != 42.5 {
+		t.Errorf("Expected shared progress unchanged, got %v", got)
+	}
+	if got, _ := GetProgress(ctx, 7, "m1"); got != 10 {
+		t.Errorf("Expected user progress 10, got %v", got)
+	}
+}
+
+func TestAuditEvents(t *testing.T) {
+	if err := Init(filepath.Join(t.TempDir(), "msp.db")); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	t.Cleanup(func() {
+		Close()
+		DB = nil
+	})
+	ctx := context.Background()
+
+	now := time.Now()
+	for i, ev := range []types.AuditEvent{
+		{Time: now.AddDate(0, 0, -100), Type: types.AuditLogin, IP: "10.0.0.1"},
+		{Time: now.Add(-3 * time.Minute), Type: types.AuditLoginFailed, IP: "10.0.0.2"},
+		{Time: now.Add(-2 * time.Minute), Type: types.AuditLoginFailed, IP: "10.0.0.3"},
+		{Time: now.Add(-time.Minute), Type: types.AuditConfigChange, Actor: "Admin",
+			Changes: []types.FieldChange{{Field: "port", Old: "8099", New: "8100"}}},
+	} {
+		if err := AddAuditEvent(ctx, &ev); err != nil {
+			t.Fatalf("event %d: %v", i, err)
+		}
+	}
+
+	events, total, err := ListAuditEvents(ctx, AuditFilter{Types: []string{types.AuditLoginFailed}, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 || len(events) != 1 || events[0].IP != "10.0.0.3" {
+		t.Errorf("Expected newest of 2 failures, got total=%d %+v", total, events)
+	}
+	events, _, _ = ListAuditEvents(ctx, AuditFilter{Actor: "admin"})
+	if len(events) != 1 || len(events[0].Changes) != 1 || events[0].Changes[0].New != "8100" {
+		t.Errorf("Expected config change with diff, got %+v", events)
+	}
+	if ok, _ := HasAuditEvent(ctx, types.AuditLogin, "10.0.0.1"); !ok {
+		t.Error("Expected HasAuditEvent to find login")
+	}
+
+	// Retention drops the 100-day-old event, the cap keeps the newest 2
+	n, err := PruneAuditEvents(ctx, now.AddDate(0, 0, -90), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, total, _ = ListAuditEvents(ctx, AuditFilter{})
+	if n != 2 || total != 2 || events[1].IP != "10.0.0.3" {
+		t.Errorf("Expected 2 pruned and 2 newest kept, got n=%d total=%d %+v", n, total, events)
+	}
+
+	// Without age-based retention the cap still applies
+	n, err = PruneAuditEvents(ctx, time.Time{}, 1)
+	if _, total, _ = ListAuditEvents(ctx, AuditFilter{}); err != nil || n != 1 || total != 1 {
+		t.Errorf("Expected the cap alone to keep 1 event, got n=%d total=%d err=%v", n, total, err)
+	}
+}
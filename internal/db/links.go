@@ -0,0 +1,66 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"msp/internal/types"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func CreateShareLink(ctx context.Context, link *types.ShareLink) error {
+	if DB == nil {
+		return ErrNoDB
+	}
+	// Opportunistically drop expired links
+	if err := DB.WithContext(ctx).Where("expires_at <= ?", time.Now()).Delete(&types.ShareLink{}).Error; err != nil {
+		return err
+	}
+	return DB.WithContext(ctx).Create(link).Error
+}
+
+// GetShareLink returns the unexpired link with the given ID.
+func GetShareLink(ctx context.Context, id string) (types.ShareLink, bool, error) {
+	if DB == nil || id == "" {
+		return types.ShareLink{}, false, nil
+	}
+	var link types.ShareLink
+	err := DB.Session(&gorm.Session{Logger: logger.Default.LogMode(logger.Silent)}).WithContext(ctx).
+		Where("id = ? AND expires_at > ?", id, time.Now()).First(&link).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return types.ShareLink{}, false, nil
+	}
+	return link, err == nil, err
+}
+
+func ListShareLinks(ctx context.Context) ([]types.ShareLink, error) {
+	if DB == nil {
+		return []types.ShareLink{}, nil
+	}
+	out := []types.ShareLink{}
+	err := DB.WithContext(ctx).Where("expires_at > ?", time.Now()).Order("created_at desc").Find(&out).Error
+	return out, err
+}
+
+func DeleteShareLink(ctx context.Context, id string) (bool, error) {
+	if DB == nil || id == "" {
+		return false, nil
+	}
+	res := DB.WithContext(ctx).Where("id = ?", id).Delete(&types.ShareLink{})
+	return res.RowsAffected > 0, res.Error
+}
+
+// UseShareLink counts one use of a link. It returns false when the link has
+// reached its use limit.
+func UseShareLink(ctx context.Context, id string) (bool, error) {
+	if DB == nil || id == "" {
+		return false, nil
+	}
+	res := DB.WithContext(ctx).Model(&types.ShareLink{}).
+		Where("id = ? AND (max_uses = 0 OR uses < max_uses)", id).
+		UpdateColumn("uses", gorm.Expr("uses + 1"))
+	return res.RowsAffected > 0, res.Error
+}
@@ -0,0 +1,68 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// slowQuery is the duration above which statements are logged as warnings.
+const slowQuery = 2 * time.Second
+
+// slogLogger routes GORM's log output through the default slog logger, so
+// it follows the configured level and format and carries request IDs.
+// Failed statements are errors, slow ones warnings and all others debug
+// records.
+type slogLogger struct {
+	level logger.LogLevel
+}
+
+func newGormLogger() logger.Interface {
+	return slogLogger{level: logger.Info}
+}
+
+func (l slogLogger) LogMode(level logger.LogLevel) logger.Interface {
+	l.level = level
+	return l
+}
+
+func (l slogLogger) Info(ctx context.Context, msg string, args ...any) {
+	if l.level >= logger.Info {
+		slog.InfoContext(ctx, fmt.Sprintf(msg, args...), "component", "db")
+	}
+}
+
+func (l slogLogger) Warn(ctx context.Context, msg string, args ...any) {
+	if l.level >= logger.Warn {
+		slog.WarnContext(ctx, fmt.Sprintf(msg, args...), "component", "db")
+	}
+}
+
+func (l slogLogger) Error(ctx context.Context, msg string, args ...any) {
+	if l.level >= logger.Error {
+		slog.ErrorContext(ctx, fmt.Sprintf(msg, args...), "component", "db")
+	}
+}
+
+func (l slogLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if l.level <= logger.Silent {
+		return
+	}
+	elapsed := time.Since(begin)
+	switch {
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound) && l.level >= logger.Error:
+		sql, rows := fc()
+		slog.ErrorContext(ctx, "Query failed", "component", "db", "sql", sql, "rows", rows, "duration", elapsed, "err", err)
+	case elapsed > slowQuery && l.level >= logger.Warn:
+		sql, rows := fc()
+		slog.WarnContext(ctx, "Slow query", "component", "db", "sql", sql, "rows", rows, "duration", elapsed)
+	case l.level >= logger.Info && slog.Default().Enabled(ctx, slog.LevelDebug):
+		sql, rows := fc()
+		slog.DebugContext(ctx, "Query", "component", "db", "sql", sql, "rows", rows, "duration", elapsed)
+	}
+}
@@ -0,0 +1,45 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+
+	"msp/internal/metrics"
+)
+
+const metricsStartKey = "msp:metrics_start"
+
+// registerMetrics times every statement run through g for
+// metrics.DBQueryDuration.
+func registerMetrics(g *gorm.DB) error {
+	cb := g.Callback()
+	return errors.Join(
+		cb.Create().Before("gorm:create").Register("msp:metrics_start_create", startTimer),
+		cb.Create().After("gorm:create").Register("msp:metrics_observe_create", observe("create")),
+		cb.Query().Before("gorm:query").Register("msp:metrics_start_query", startTimer),
+		cb.Query().After("gorm:query").Register("msp:metrics_observe_query", observe("query")),
+		cb.Update().Before("gorm:update").Register("msp:metrics_start_update", startTimer),
+		cb.Update().After("gorm:update").Register("msp:metrics_observe_update", observe("update")),
+		cb.Delete().Before("gorm:delete").Register("msp:metrics_start_delete", startTimer),
+		cb.Delete().After("gorm:delete").Register("msp:metrics_observe_delete", observe("delete")),
+		cb.Row().Before("gorm:row").Register("msp:metrics_start_row", startTimer),
+		cb.Row().After("gorm:row").Register("msp:metrics_observe_row", observe("row")),
+		cb.Raw().Before("gorm:raw").Register("msp:metrics_start_raw", startTimer),
+		cb.Raw().After("gorm:raw").Register("msp:metrics_observe_raw", observe("raw")),
+	)
+}
+
+func startTimer(tx *gorm.DB) {
+	tx.InstanceSet(metricsStartKey, time.Now())
+}
+
+func observe(op string) func(*gorm.DB) {
+	hist := metrics.DBQueryDuration.WithLabelValues(op)
+	return func(tx *gorm.DB) {
+		if v, ok := tx.InstanceGet(metricsStartKey); ok {
+			hist.Observe(time.Since(v.(time.Time)).Seconds())
+		}
+	}
+}
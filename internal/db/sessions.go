@@ -0,0 +1,99 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"msp/internal/types"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// ErrNoDB is returned by operations that cannot work without the database.
+var ErrNoDB = errors.New("database not initialized")
+
+func CreateSession(ctx context.Context, sess *types.Session) error {
+	if DB == nil {
+		return ErrNoDB
+	}
+	// Opportunistically drop expired sessions
+	if err := DeleteExpiredSessions(ctx); err != nil {
+		return err
+	}
+	return DB.WithContext(ctx).Create(sess).Error
+}
+
+// GetSessionByTokenHash returns the unexpired session matching tokenHash.
+func GetSessionByTokenHash(ctx context.Context, tokenHash string) (types.Session, bool, error) {
+	if DB == nil || tokenHash == "" {
+		return types.Session{}, false, nil
+	}
+	var sess types.Session
+	err := DB.Session(&gorm.Session{Logger: logger.Default.LogMode(logger.Silent)}).WithContext(ctx).
+		Where("token_hash = ? AND expires_at > ?", tokenHash, time.Now()).
+		First(&sess).Error
+	if err == gorm.ErrRecordNotFound {
+		return types.Session{}, false, nil
+	}
+	return sess, err == nil, err
+}
+
+func TouchSession(ctx context.Context, id string, ip string, at time.Time) error {
+	if DB == nil || id == "" {
+		return nil
+	}
+	return DB.WithContext(ctx).Model(&types.Session{}).Where("id = ?", id).
+		Updates(map[string]any{"last_seen_at": at, "ip": ip}).Error
+}
+
+// SetSessionCSRFToken stores the CSRF token of a session created before
+// CSRF tokens existed.
+func SetSessionCSRFToken(ctx context.Context, id, token string) error {
+	if DB == nil || id == "" {
+		return nil
+	}
+	return DB.WithContext(ctx).Model(&types.Session{}).Where("id = ?", id).Update("csrf_token", token).Error
+}
+
+func ListSessions(ctx context.Context) ([]types.Session, error) {
+	if DB == nil {
+		return []types.Session{}, nil
+	}
+	var out []types.Session
+	err := DB.WithContext(ctx).Where("expires_at > ?", time.Now()).Order("last_seen_at desc").Find(&out).Error
+	return out, err
+}
+
+func DeleteSession(ctx context.Context, id string) (bool, error) {
+	if DB == nil || id == "" {
+		return false, nil
+	}
+	res := DB.WithContext(ctx).Where("id = ?", id).Delete(&types.Session{})
+	return res.RowsAffected > 0, res.Error
+}
+
+// DeleteSessionsExcept revokes every session except keepID (which may be empty).
+func DeleteSessionsExcept(ctx context.Context, keepID string) (int64, error) {
+	if DB == nil {
+		return 0, nil
+	}
+	res := DB.WithContext(ctx).Where("id != ?", keepID).Delete(&types.Session{})
+	return res.RowsAffected, res.Error
+}
+
+// DeleteUserSessions revokes every session of userID.
+func DeleteUserSessions(ctx context.Context, userID uint) error {
+	if DB == nil || userID == 0 {
+		return nil
+	}
+	return DB.WithContext(ctx).Where("user_id = ?", userID).Delete(&types.Session{}).Error
+}
+
+func DeleteExpiredSessions(ctx context.Context) error {
+	if DB == nil {
+		return nil
+	}
+	return DB.WithContext(ctx).Where("expires_at <= ?", time.Now()).Delete(&types.Session{}).Error
+}
@@ -0,0 +1,58 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"msp/internal/types"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func CreateAPIToken(ctx context.Context, tok *types.APIToken) error {
+	if DB == nil {
+		return ErrNoDB
+	}
+	return DB.WithContext(ctx).Create(tok).Error
+}
+
+// GetAPITokenByHash returns the unexpired token matching tokenHash.
+func GetAPITokenByHash(ctx context.Context, tokenHash string) (types.APIToken, bool, error) {
+	if DB == nil || tokenHash == "" {
+		return types.APIToken{}, false, nil
+	}
+	var tok types.APIToken
+	err := DB.Session(&gorm.Session{Logger: logger.Default.LogMode(logger.Silent)}).WithContext(ctx).
+		Where("token_hash = ? AND (expires_at IS NULL OR expires_at > ?)", tokenHash, time.Now()).
+		First(&tok).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return types.APIToken{}, false, nil
+	}
+	return tok, err == nil, err
+}
+
+func ListAPITokens(ctx context.Context) ([]types.APIToken, error) {
+	if DB == nil {
+		return []types.APIToken{}, nil
+	}
+	out := []types.APIToken{}
+	err := DB.WithContext(ctx).Order("created_at desc").Find(&out).Error
+	return out, err
+}
+
+func DeleteAPIToken(ctx context.Context, id string) (bool, error) {
+	if DB == nil || id == "" {
+		return false, nil
+	}
+	res := DB.WithContext(ctx).Where("id = ?", id).Delete(&types.APIToken{})
+	return res.RowsAffected > 0, res.Error
+}
+
+func TouchAPIToken(ctx context.Context, id string, at time.Time) error {
+	if DB == nil || id == "" {
+		return nil
+	}
+	return DB.WithContext(ctx).Model(&types.APIToken{}).Where("id = ?", id).Update("last_used_at", at).Error
+}
@@ -0,0 +1,136 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"msp/internal/types"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+)
+
+func CreateUser(ctx context.Context, u *types.User) error {
+	if DB == nil {
+		return ErrNoDB
+	}
+	return DB.WithContext(ctx).Create(u).Error
+}
+
+func GetUser(ctx context.Context, id uint) (types.User, bool, error) {
+	if DB == nil || id == 0 {
+		return types.User{}, false, nil
+	}
+	var u types.User
+	err := DB.Session(&gorm.Session{Logger: logger.Default.LogMode(logger.Silent)}).WithContext(ctx).First(&u, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return types.User{}, false, nil
+	}
+	return u, err == nil, err
+}
+
+// GetUserByName looks up a user by name (case-insensitive).
+func GetUserByName(ctx context.Context, name string) (types.User, bool, error) {
+	if DB == nil || name == "" {
+		return types.User{}, false, nil
+	}
+	var u types.User
+	err := DB.Session(&gorm.Session{Logger: logger.Default.LogMode(logger.Silent)}).WithContext(ctx).
+		Where("lower(name) = lower(?)", name).First(&u).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return types.User{}, false, nil
+	}
+	return u, err == nil, err
+}
+
+func ListUsers(ctx context.Context) ([]types.User, error) {
+	if DB == nil {
+		return []types.User{}, nil
+	}
+	out := []types.User{}
+	err := DB.WithContext(ctx).Order("lower(name)").Find(&out).Error
+	return out, err
+}
+
+func UpdateUser(ctx context.Context, u *types.User) error {
+	if DB == nil {
+		return ErrNoDB
+	}
+	return DB.WithContext(ctx).Save(u).Error
+}
+
+// DeleteUser removes a user together with its sessions and per-user data.
+func DeleteUser(ctx context.Context, id uint) (bool, error) {
+	if DB == nil || id == 0 {
+		return false, nil
+	}
+	var deleted bool
+	err := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, model := range []any{&types.Session{}, &types.PlaybackProgress{}, &types.UserPref{}, &types.Favorite{}, &types.HistoryEntry{}} {
+			if err := tx.Where("user_id = ?", id).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		res := tx.Delete(&types.User{}, id)
+		deleted = res.RowsAffected > 0
+		return res.Error
+	})
+	return deleted, err
+}
+
+func ListFavorites(ctx context.Context, userID uint) ([]types.Favorite, error) {
+	if DB == nil {
+		return []types.Favorite{}, nil
+	}
+	out := []types.Favorite{}
+	err := DB.WithContext(ctx).Where("user_id = ?", userID).Order("created_at desc").Find(&out).Error
+	return out, err
+}
+
+func AddFavorite(ctx context.Context, userID uint, mediaID string) error {
+	if DB == nil || mediaID == "" {
+		return nil
+	}
+	return DB.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&types.Favorite{UserID: userID, MediaID: mediaID, CreatedAt: time.Now()}).Error
+}
+
+func RemoveFavorite(ctx context.Context, userID uint, mediaID string) error {
+	if DB == nil || mediaID == "" {
+		return nil
+	}
+	return DB.WithContext(ctx).Where("user_id = ? AND media_id = ?", userID, mediaID).Delete(&types.Favorite{}).Error
+}
+
+// TouchHistory records that userID played mediaID at the given time.
+func TouchHistory(ctx context.Context, userID uint, mediaID string, at time.Time) error {
+	if DB == nil || mediaID == "" {
+		return nil
+	}
+	return DB.WithContext(ctx).Clauses(clause.OnConflict{
+		UpdateAll: true,
+	}).Create(&types.HistoryEntry{UserID: userID, MediaID: mediaID, PlayedAt: at}).Error
+}
+
+// ListHistory returns the most recently played items first. limit <= 0 means no limit.
+func ListHistory(ctx context.Context, userID uint, limit int) ([]types.HistoryEntry, error) {
+	if DB == nil {
+		return []types.HistoryEntry{}, nil
+	}
+	out := []types.HistoryEntry{}
+	q := DB.WithContext(ctx).Where("user_id = ?", userID).Order("played_at desc")
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	err := q.Find(&out).Error
+	return out, err
+}
+
+func ClearHistory(ctx context.Context, userID uint) error {
+	if DB == nil {
+		return nil
+	}
+	return DB.WithContext(ctx).Where("user_id = ?", userID).Delete(&types.HistoryEntry{}).Error
+}
@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"msp/internal/db"
+	"msp/internal/types"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// audit records a security event for r, filling in the client IP and, if
+// not set, the acting user name or role.
+func (h *Handler) audit(r *http.Request, ev types.AuditEvent) {
+	ev.IP = h.s.ClientIP(r)
+	if ev.Actor == "" {
+		p := principalOf(r)
+		if p.User != nil {
+			ev.Actor = p.User.Name
+		} else {
+			ev.Actor = p.Role
+		}
+	}
+	h.s.Audit(ev)
+}
+
+// HandleAudit lists audit events, newest first. Query parameters: type
+// (comma-separated), ip, actor, since/until (RFC 3339), limit and offset.
+func (h *Handler) HandleAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	f := db.AuditFilter{
+		IP:    strings.TrimSpace(q.Get("ip")),
+		Actor: strings.TrimSpace(q.Get("actor")),
+		Limit: defaultAuditLimit,
+	}
+	for _, t := range strings.Split(q.Get("type"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			f.Types = append(f.Types, t)
+		}
+	}
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{{"since", &f.Since}, {"until", &f.Until}} {
+		v := strings.TrimSpace(q.Get(p.name))
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, types.AuditResponse{Error: &types.ApiError{Message: p.name + " 需为 RFC 3339 时间"}})
+			return
+		}
+		*p.dst = t
+	}
+	if n, err := strconv.Atoi(q.Get("limit")); err == nil && n > 0 {
+		f.Limit = min(n, maxAuditLimit)
+	}
+	if n, err := strconv.Atoi(q.Get("offset")); err == nil && n > 0 {
+		f.Offset = n
+	}
+
+	events, total, err := db.ListAuditEvents(r.Context(), f)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "ListAuditEvents failed", "err", err)
+		writeJSON(w, http.StatusInternalServerError, types.AuditResponse{Error: &types.ApiError{Message: "读取审计日志失败"}})
+		return
+	}
+	writeJSON(w, http.StatusOK, types.AuditResponse{Events: events, Total: total})
+}
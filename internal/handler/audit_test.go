@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"msp/internal/types"
+)
+
+func TestAuditTrail(t *testing.T) {
+	s := newAuthTestServer(t)
+	h := New(s)
+	admin := principal{Role: RoleAdmin}
+
+	for _, pin := range []string{"0000", "4321"} {
+		h.HandlePIN(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/pin", bytes.NewBufferString(`{"pin":"`+pin+`"}`)))
+	}
+	w := httptest.NewRecorder()
+	h.HandleConfig(w, withPrincipal(httptest.NewRequest(http.MethodPost, "/api/config", bytes.NewBufferString(`{"port":9100}`)), admin))
+	if w.Code != http.StatusOK {
+		t.Fatalf("config update: %d %s", w.Code, w.Body.String())
+	}
+
+	query := func(target string) types.AuditResponse {
+		w := httptest.NewRecorder()
+		h.HandleAudit(w, withPrincipal(httptest.NewRequest(http.MethodGet, target, nil), admin))
+		var resp types.AuditResponse
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp
+	}
+	if resp := query("/api/admin/audit?type=login,login_failed"); resp.Total != 2 || resp.Events[0].Type != types.AuditLogin {
+		t.Errorf("Expected failed and successful login, got %+v", resp)
+	}
+	resp := query("/api/admin/audit?type=config_change")
+	if resp.Total != 1 || resp.Events[0].Actor != RoleAdmin {
+		t.Fatalf("Expected one config change by admin, got %+v", resp)
+	}
+	found := false
+	for _, c := range resp.Events[0].Changes {
+		found = found || (c.Field == "port" && c.New == "9100")
+	}
+	if !found {
+		t.Errorf("Expected port change in diff, got %+v", resp.Events[0].Changes)
+	}
+
+	w = httptest.NewRecorder()
+	h.HandleAudit(w, withPrincipal(httptest.NewRequest(http.MethodGet, "/api/admin/audit?since=yesterday", nil), admin))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected bad since to be rejected, got %d", w.Code)
+	}
+}
@@ -0,0 +1,442 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"msp/internal/config"
+	"msp/internal/db"
+	"msp/internal/media"
+	"msp/internal/pinhash"
+	"msp/internal/server"
+	"msp/internal/types"
+	"msp/internal/util"
+)
+
+const (
+	// SessionCookie holds the random session token issued by /api/pin.
+	SessionCookie = "msp_session"
+	// legacyPINCookie stored the raw PIN in older versions; it is cleared on login.
+	legacyPINCookie = "msp_pin"
+
+	// sessionTouchInterval throttles last-seen updates to one write per minute.
+	sessionTouchInterval = time.Minute
+
+	// RoleViewer may browse and play media.
+	RoleViewer = "viewer"
+	// RoleAdmin may additionally change the config, shares, sessions and logs.
+	RoleAdmin = "admin"
+)
+
+// pinMatches checks a PIN against the stored argon2id hash.
+func pinMatches(got, hash string) bool {
+	return pinhash.Verify(got, hash)
+}
+
+// principal is the identity WithSecurity attaches to each API request.
+type principal struct {
+	Role string
+	// User is nil for the shared profile (PIN, localhost or anonymous access).
+	User *types.User
+	// Link is set when the request is authorized by a share link.
+	Link *types.ShareLink
+	// Session is the login session carried by the cookie, if it was used.
+	Session *types.Session
+	// Token is set when the request is authorized by an API token.
+	Token *types.APIToken
+}
+
+// UserID returns the user's ID, or 0 for the shared profile.
+func (p principal) UserID() uint {
+	if p.User == nil {
+		return 0
+	}
+	return p.User.ID
+}
+
+type principalKey struct{}
+
+func withPrincipal(r *http.Request, p principal) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), principalKey{}, p))
+}
+
+// principalOf returns the request's principal. Requests that did not pass
+// through WithSecurity are treated as anonymous viewers.
+func principalOf(r *http.Request) principal {
+	if p, ok := r.Context().Value(principalKey{}).(principal); ok {
+		return p
+	}
+	return principal{Role: RoleViewer}
+}
+
+// sharesFor returns the shares visible to the request's principal.
+// Admins see every share; a share link sees its target, and for a file
+// the subtitles next to it.
+func sharesFor(r *http.Request, shares []config.Share) []config.Share {
+	p := principalOf(r)
+	if p.Link != nil {
+		target, _ := util.DecodeID(p.Link.MediaID)
+		target = util.NormalizePath(target)
+		out := []config.Share{{Label: p.Link.Label, Path: target}}
+		if !p.Link.IsDir {
+			for _, sub := range media.FindSidecarSubtitles(target) {
+				if path, err := util.DecodeID(sub.ID); err == nil {
+					out = append(out, config.Share{Label: p.Link.Label, Path: path})
+				}
+			}
+		}
+		return out
+	}
+	if p.Role == RoleAdmin {
+		return append([]config.Share(nil), shares...)
+	}
+	if p.User == nil {
+		return util.SharesFor(shares, "", nil)
+	}
+	return util.SharesFor(shares, p.User.Name, p.User.Groups)
+}
+
+// issueSession creates a session for the client and sets the session cookie.
+// userID is 0 for PIN logins.
+func issueSession(w http.ResponseWriter, r *http.Request, s *server.Server, label, role string, userID uint) error {
+	token, err := util.NewToken(32)
+	if err != nil {
+		return err
+	}
+	id, err := util.NewToken(9)
+	if err != nil {
+		return err
+	}
+	csrf, err := util.NewToken(24)
+	if err != nil {
+		return err
+	}
+
+	ttl := time.Duration(s.Config().Security.SessionTTLHours) * time.Hour
+	now := time.Now()
+	if label == "" {
+		label = deviceLabel(r.UserAgent())
+	}
+	sess := types.Session{
+		ID:         id,
+		TokenHash:  util.HashToken(token),
+		CSRFToken:  csrf,
+		Label:      label,
+		Role:       role,
+		UserID:     userID,
+		IP:         s.ClientIP(r),
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(ttl),
+	}
+	if err := db.CreateSession(r.Context(), &sess); err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(ttl.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.SetCookie(w, &http.Cookie{Name: legacyPINCookie, Value: "", Path: "/", MaxAge: -1})
+	setCSRFCookie(w, r, &sess)
+	return nil
+}
+
+// currentSession looks up the session referenced by the request cookie.
+func currentSession(r *http.Request) (types.Session, bool) {
+	c, err := r.Cookie(SessionCookie)
+	if err != nil || c.Value == "" {
+		return types.Session{}, false
+	}
+	sess, ok, err := db.GetSessionByTokenHash(r.Context(), util.HashToken(c.Value))
+	if err != nil || !ok {
+		return types.Session{}, false
+	}
+	return sess, true
+}
+
+// pinRole returns the role granted by pin, or "" if it matches neither the
+// admin credential nor (when PIN auth is enabled) the viewer PIN.
+func pinRole(sec config.SecurityConfig, pin string) string {
+	switch {
+	case sec.AdminPINHash != "" && pinMatches(pin, sec.AdminPINHash):
+		return RoleAdmin
+	case sec.PINEnabled && pinMatches(pin, sec.PINHash):
+		return RoleViewer
+	}
+	return ""
+}
+
+// pinTarget is the role a wrong PIN is counted against: the admin role
+// whenever an admin credential exists, since any guess may be aimed at it.
+func pinTarget(sec config.SecurityConfig) string {
+	if sec.AdminPINHash != "" {
+		return RoleAdmin
+	}
+	return RoleViewer
+}
+
+// authenticate resolves the principal of the request from (in order) a share
+// link, an API token, a user session, localhost admin mode, the X-PIN header
+// and a PIN session. Without
+// credentials it is an anonymous viewer when PIN auth is disabled; ok is
+// false if the request is not authenticated.
+func authenticate(s *server.Server, w http.ResponseWriter, r *http.Request, sec config.SecurityConfig) (principal, bool) {
+	if token := r.URL.Query().Get("link"); token != "" && linkRoutes[r.URL.Path] {
+		return authenticateLink(s, w, r, token)
+	}
+	if secret, ok := bearerToken(r); ok {
+		return authenticateToken(s, r, secret)
+	}
+
+	sess, hasSession := currentSession(r)
+	if hasSession && sess.UserID != 0 {
+		u, ok, err := db.GetUser(r.Context(), sess.UserID)
+		if err != nil || !ok {
+			return principal{}, false
+		}
+		touchSession(s, r, sess)
+		role := RoleViewer
+		if u.Role == RoleAdmin {
+			role = RoleAdmin
+		}
+		return principal{Role: role, User: &u, Session: &sess}, true
+	}
+
+	if sec.LocalhostAdmin != nil && *sec.LocalhostAdmin && s.IsLocalRequest(r) {
+		return principal{Role: RoleAdmin}, true
+	}
+
+	if hdr := r.Header.Get("X-PIN"); hdr != "" {
+		ip := s.ClientIP(r)
+		role := pinRole(sec, hdr)
+		if role == "" {
+			s.RecordLoginFailure(ip, pinTarget(sec), "")
+			return principal{}, false
+		}
+		return principal{Role: role}, true
+	}
+
+	if hasSession {
+		touchSession(s, r, sess)
+		if sess.Role == RoleAdmin {
+			return principal{Role: RoleAdmin, Session: &sess}, true
+		}
+		return principal{Role: RoleViewer, Session: &sess}, true
+	}
+
+	if !sec.PINEnabled {
+		return principal{Role: RoleViewer}, true
+	}
+	return principal{}, false
+}
+
+func touchSession(s *server.Server, r *http.Request, sess types.Session) {
+	if now := time.Now(); now.Sub(sess.LastSeenAt) >= sessionTouchInterval {
+		_ = db.TouchSession(r.Context(), sess.ID, s.ClientIP(r), now)
+	}
+}
+
+// HandlePINChange replaces the viewer PIN or admin credential after verifying
+// the old one (not needed when no admin credential is set yet). Other
+// sessions are revoked so devices that knew the old PIN must log in again.
+func (h *Handler) HandlePINChange(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req types.PINChangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "JSON 解析失败"})
+		return
+	}
+	newPIN := strings.TrimSpace(req.NewPIN)
+	if newPIN == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "新 PIN 不能为空"})
+		return
+	}
+
+	role := strings.ToLower(strings.TrimSpace(req.Role))
+	if role == "" {
+		role = RoleViewer
+	}
+	if role != RoleViewer && role != RoleAdmin {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "不支持的 role（viewer/admin）"})
+		return
+	}
+	field := "security.pinHash"
+	if role == RoleAdmin {
+		field = "security.adminPinHash"
+	}
+	if v := h.s.EnvVar(field); v != "" {
+		writeJSON(w, http.StatusConflict, map[string]any{"error": "PIN 由环境变量 " + v + " 设置，不能修改"})
+		return
+	}
+
+	sec := h.s.Config().Security
+	oldHash := sec.PINHash
+	if role == RoleAdmin {
+		oldHash = sec.AdminPINHash
+	}
+	clientIP := h.s.ClientIP(r)
+	if oldHash != "" {
+		if !pinMatches(req.OldPIN, oldHash) {
+			h.s.RecordLoginFailure(clientIP, role, "")
+			writeJSON(w, http.StatusForbidden, map[string]any{"error": "原 PIN 错误"})
+			return
+		}
+		h.s.RecordLoginSuccess(clientIP, role)
+	}
+
+	hash, err := pinhash.Hash(newPIN)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "pinhash.Hash failed", "err", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": "写入配置失败"})
+		return
+	}
+	if err := h.s.UpdateConfig(func(cfg *config.Config) {
+		if role == RoleAdmin {
+			cfg.Security.AdminPIN = ""
+			cfg.Security.AdminPINHash = hash
+		} else {
+			cfg.Security.PIN = ""
+			cfg.Security.PINHash = hash
+		}
+	}); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": "写入配置失败"})
+		return
+	}
+	slog.InfoContext(r.Context(), "PIN changed", "role", role, "ip", clientIP)
+	h.audit(r, types.AuditEvent{Type: types.AuditPINChange, Detail: "role=" + role})
+
+	cur, _ := currentSession(r)
+	if _, err := db.DeleteSessionsExcept(r.Context(), cur.ID); err != nil {
+		slog.ErrorContext(r.Context(), "DeleteSessionsExcept failed", "err", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleSessions lists active login sessions (GET) and revokes them (POST).
+func (h *Handler) HandleSessions(w http.ResponseWriter, r *http.Request) {
+	cur, _ := currentSession(r)
+
+	switch r.Method {
+	case http.MethodGet:
+		list, err := db.ListSessions(r.Context())
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, types.SessionsResponse{Error: &types.ApiError{Message: "读取会话失败"}})
+			return
+		}
+		for i := range list {
+			list[i].Current = list[i].ID == cur.ID
+		}
+		writeJSON(w, http.StatusOK, types.SessionsResponse{Sessions: list})
+	case http.MethodPost:
+		var req types.SessionsOpRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, types.SessionsResponse{Error: &types.ApiError{Message: "JSON 解析失败"}})
+			return
+		}
+		switch strings.ToLower(strings.TrimSpace(req.Op)) {
+		case "revoke":
+			if req.ID == "" {
+				writeJSON(w, http.StatusBadRequest, types.SessionsResponse{Error: &types.ApiError{Message: "缺少 id"}})
+				return
+			}
+			ok, err := db.DeleteSession(r.Context(), req.ID)
+			if err != nil {
+				writeJSON(w, http.StatusInternalServerError, types.SessionsResponse{Error: &types.ApiError{Message: "撤销会话失败"}})
+				return
+			}
+			if !ok {
+				writeJSON(w, http.StatusNotFound, types.SessionsResponse{Error: &types.ApiError{Message: "会话不存在"}})
+				return
+			}
+			if req.ID == cur.ID {
+				http.SetCookie(w, &http.Cookie{Name: SessionCookie, Value: "", Path: "/", MaxAge: -1})
+			}
+		case "revokeothers":
+			if _, err := db.DeleteSessionsExcept(r.Context(), cur.ID); err != nil {
+				writeJSON(w, http.StatusInternalServerError, types.SessionsResponse{Error: &types.ApiError{Message: "撤销会话失败"}})
+				return
+			}
+		default:
+			writeJSON(w, http.StatusBadRequest, types.SessionsResponse{Error: &types.ApiError{Message: "不支持的 op（revoke/revokeOthers）"}})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleLockouts lists PIN brute-force lockouts (GET) and clears them (POST).
+func (h *Handler) HandleLockouts(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]any{"lockouts": h.s.Lockouts()})
+	case http.MethodPost:
+		var req types.LockoutsOpRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": "JSON 解析失败"})
+			return
+		}
+		if strings.ToLower(strings.TrimSpace(req.Op)) != "clear" {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": "不支持的 op（clear）"})
+			return
+		}
+		n := h.s.ClearLockouts(strings.TrimSpace(req.IP))
+		writeJSON(w, http.StatusOK, map[string]any{"cleared": n})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// deviceLabel builds a short human-readable label like "Chrome on Android".
+func deviceLabel(ua string) string {
+	if ua == "" {
+		return "Unknown device"
+	}
+	browser := "Browser"
+	switch {
+	case strings.Contains(ua, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(ua, "Firefox/"):
+		browser = "Firefox"
+	case strings.Contains(ua, "Chrome/"):
+		browser = "Chrome"
+	case strings.Contains(ua, "Safari/"):
+		browser = "Safari"
+	case strings.Contains(ua, "curl/"):
+		browser = "curl"
+	}
+	platform := ""
+	switch {
+	case strings.Contains(ua, "Android"):
+		platform = "Android"
+	case strings.Contains(ua, "iPhone"):
+		platform = "iPhone"
+	case strings.Contains(ua, "iPad"):
+		platform = "iPad"
+	case strings.Contains(ua, "Windows"):
+		platform = "Windows"
+	case strings.Contains(ua, "Mac OS X"):
+		platform = "macOS"
+	case strings.Contains(ua, "Linux"):
+		platform = "Linux"
+	}
+	if platform == "" {
+		return browser
+	}
+	return browser + " on " + platform
+}
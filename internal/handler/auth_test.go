@@ -0,0 +1,245 @@
+package handler
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"msp/internal/config"
+	"msp/internal/db"
+	"msp/internal/pinhash"
+	"msp/internal/server"
+)
+
+// newAuthTestServer returns a server with PIN auth enabled and a temporary DB.
+func newAuthTestServer(t *testing.T) *server.Server {
+	t.Helper()
+	tmpDir := t.TempDir()
+	if err := db.Init(filepath.Join(tmpDir, "msp.db")); err != nil {
+		t.Fatalf("db.Init: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		db.DB = nil
+	})
+
+	s := server.New(filepath.Join(tmpDir, "config.json"))
+	_ = s.UpdateConfig(func(cfg *config.Config) {
+		*cfg = config.Default()
+		cfg.Security.PINEnabled = true
+		cfg.Security.PIN = "4321"
+		if _, err := config.PrepareSecrets(cfg); err != nil {
+			t.Fatal(err)
+		}
+	})
+	return s
+}
+
+func TestPINSessionFlow(t *testing.T) {
+	s := newAuthTestServer(t)
+	h := New(s)
+	protected := WithSecurity(s, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	// Wrong PIN: no cookie
+	w := httptest.NewRecorder()
+	h.HandlePIN(w, httptest.NewRequest(http.MethodPost, "/api/pin", bytes.NewBufferString(`{"pin":"0000"}`)))
+	if len(w.Result().Cookies()) != 0 {
+		t.Fatal("Expected no cookie for wrong PIN")
+	}
+
+	// Correct PIN: session cookie that is not the PIN
+	w = httptest.NewRecorder()
+	h.HandlePIN(w, httptest.NewRequest(http.MethodPost, "/api/pin", bytes.NewBufferString(`{"pin":"4321"}`)))
+	var sess *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == SessionCookie {
+			sess = c
+		}
+	}
+	if sess == nil || sess.Value == "" || sess.Value == "4321" {
+		t.Fatalf("Expected opaque session cookie, got %+v", sess)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/media", nil)
+	req.AddCookie(sess)
+	w = httptest.NewRecorder()
+	protected.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected 200 with session cookie, got %d", w.Code)
+	}
+
+	// Raw PIN cookie is no longer accepted
+	req = httptest.NewRequest(http.MethodGet, "/api/media", nil)
+	req.AddCookie(&http.Cookie{Name: "msp_pin", Value: "4321"})
+	w = httptest.NewRecorder()
+	protected.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for legacy PIN cookie, got %d", w.Code)
+	}
+
+	// Revoke all sessions, cookie stops working
+	if _, err := db.DeleteSessionsExcept(req.Context(), ""); err != nil {
+		t.Fatal(err)
+	}
+	req = httptest.NewRequest(http.MethodGet, "/api/media", nil)
+	req.AddCookie(sess)
+	w = httptest.NewRecorder()
+	protected.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 after revoke, got %d", w.Code)
+	}
+}
+
+func TestPinMatches(t *testing.T) {
+	hash, err := pinhash.Hash("1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pinMatches("1234", hash) {
+		t.Error("Expected correct PIN to match")
+	}
+	if pinMatches("12345", hash) || pinMatches("", hash) || pinMatches("1234", "1234") {
+		t.Error("Expected wrong PIN or plaintext hash to fail")
+	}
+}
+
+func TestHandlePINChange(t *testing.T) {
+	s := newAuthTestServer(t)
+	h := New(s)
+
+	change := func(body string) int {
+		w := httptest.NewRecorder()
+		h.HandlePINChange(w, httptest.NewRequest(http.MethodPost, "/api/pin/change", bytes.NewBufferString(body)))
+		return w.Code
+	}
+
+	if code := change(`{"oldPin":"0000","newPin":"9999"}`); code != http.StatusForbidden {
+		t.Errorf("Expected 403 for wrong old PIN, got %d", code)
+	}
+	if code := change(`{"oldPin":"4321","newPin":" "}`); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for empty new PIN, got %d", code)
+	}
+	if code := change(`{"oldPin":"4321","newPin":"9999"}`); code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", code)
+	}
+
+	sec := s.Config().Security
+	if sec.PIN != "" || !pinMatches("9999", sec.PINHash) || pinMatches("4321", sec.PINHash) {
+		t.Errorf("Expected new PIN hash to be stored, got %+v", sec)
+	}
+
+	// First admin credential needs no old PIN; changing it afterwards does
+	if code := change(`{"role":"admin","newPin":"root"}`); code != http.StatusNoContent {
+		t.Fatalf("Expected 204 setting admin PIN, got %d", code)
+	}
+	if code := change(`{"role":"admin","newPin":"root2"}`); code != http.StatusForbidden {
+		t.Errorf("Expected 403 changing admin PIN without old PIN, got %d", code)
+	}
+	if !pinMatches("root", s.Config().Security.AdminPINHash) {
+		t.Error("Expected admin PIN hash to be stored")
+	}
+}
+
+func TestAdminRole(t *testing.T) {
+	s := newAuthTestServer(t)
+	_ = s.UpdateConfig(func(cfg *config.Config) {
+		cfg.Security.AdminPIN = "admin-secret"
+		if _, err := config.PrepareSecrets(cfg); err != nil {
+			t.Fatal(err)
+		}
+	})
+	h := New(s)
+	protected := WithSecurity(s, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	do := func(method, path, pin, remote string, hdr map[string]string) int {
+		req := httptest.NewRequest(method, path, nil)
+		if remote != "" {
+			req.RemoteAddr = remote
+		}
+		if pin != "" {
+			req.Header.Set("X-PIN", pin)
+		}
+		for k, v := range hdr {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		protected.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Viewer PIN: browsing allowed, admin routes forbidden
+	if code := do(http.MethodGet, "/api/config", "4321", "", nil); code != http.StatusOK {
+		t.Errorf("Expected viewer GET /api/config 200, got %d", code)
+	}
+	for _, path := range []string{"/api/config", "/api/shares", "/api/log", "/api/admin/lockouts"} {
+		if code := do(http.MethodPost, path, "4321", "", nil); code != http.StatusForbidden {
+			t.Errorf("Expected viewer POST %s 403, got %d", path, code)
+		}
+	}
+
+	// Admin credential unlocks admin routes
+	if code := do(http.MethodPost, "/api/config", "admin-secret", "", nil); code != http.StatusOK {
+		t.Errorf("Expected admin POST /api/config 200, got %d", code)
+	}
+
+	// Localhost is admin without credentials, unless forwarded by an untrusted proxy
+	if code := do(http.MethodPost, "/api/shares", "", "127.0.0.1:5000", nil); code != http.StatusOK {
+		t.Errorf("Expected localhost admin 200, got %d", code)
+	}
+	if code := do(http.MethodPost, "/api/shares", "", "127.0.0.1:5000", map[string]string{"X-Forwarded-For": "203.0.113.9"}); code != http.StatusUnauthorized {
+		t.Errorf("Expected proxied request to be unauthorized, got %d", code)
+	}
+
+	// Admin login issues an admin session
+	w := httptest.NewRecorder()
+	h.HandlePIN(w, httptest.NewRequest(http.MethodPost, "/api/pin", bytes.NewBufferString(`{"pin":"admin-secret"}`)))
+	if !bytes.Contains(w.Body.Bytes(), []byte(`"role":"admin"`)) {
+		t.Errorf("Expected admin role in login response, got %s", w.Body.String())
+	}
+}
+
+func TestViewerPINDoesNotResetAdminFailures(t *testing.T) {
+	s := newAuthTestServer(t)
+	_ = s.UpdateConfig(func(cfg *config.Config) {
+		cfg.Security.AdminPIN = "admin-secret"
+		cfg.Security.MaxFailedAttempts = 2
+		if _, err := config.PrepareSecrets(cfg); err != nil {
+			t.Fatal(err)
+		}
+	})
+	protected := WithSecurity(s, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	do := func(pin string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/config", nil)
+		req.Header.Set("X-PIN", pin)
+		w := httptest.NewRecorder()
+		protected.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Valid viewer requests between admin guesses must not reset the count
+	if code := do("0000"); code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 for wrong PIN, got %d", code)
+	}
+	time.Sleep(1100 * time.Millisecond) // first backoff
+	if code := do("4321"); code != http.StatusOK {
+		t.Fatalf("Expected viewer PIN 200, got %d", code)
+	}
+	if code := do("0001"); code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 for wrong PIN, got %d", code)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	if code := do("4321"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected 429 after interleaved admin guesses, got %d", code)
+	}
+	if code := do("0002"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected 429 for further guesses, got %d", code)
+	}
+}
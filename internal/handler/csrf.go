@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"msp/internal/db"
+	"msp/internal/server"
+	"msp/internal/types"
+	"msp/internal/util"
+)
+
+const (
+	// CSRFCookie holds the CSRF token of the current session. It is readable
+	// by scripts so the frontend can echo it in CSRFHeader.
+	CSRFCookie = "msp_csrf"
+	CSRFHeader = "X-CSRF-Token"
+)
+
+// isMutating reports whether the request method may change server state.
+func isMutating(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
+
+// requestOrigin returns the browser origin of r from the Origin header, or
+// from the Referer when Origin is absent. It is empty for non-browser clients.
+func requestOrigin(r *http.Request) string {
+	if o := r.Header.Get("Origin"); o != "" {
+		return o
+	}
+	if ref := r.Header.Get("Referer"); ref != "" {
+		if u, err := url.Parse(ref); err == nil && u.Host != "" {
+			return u.Scheme + "://" + u.Host
+		}
+		return ref
+	}
+	return ""
+}
+
+// checkOrigin applies CORS and Origin/Referer validation to an API request.
+// Origins in security.allowedOrigins get credentialed CORS headers; other
+// cross-site origins may not change state. It writes the response and
+// returns false if the request must stop.
+func checkOrigin(s *server.Server, w http.ResponseWriter, r *http.Request) bool {
+	origin := requestOrigin(r)
+	cors := r.Header.Get("Origin") != "" && s.CORSAllowed(origin)
+	if cors {
+		h := w.Header()
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Set("Access-Control-Allow-Credentials", "true")
+		h.Add("Vary", "Origin")
+	}
+
+	if r.Method == http.MethodOptions {
+		if !cors {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return false
+		}
+		h := w.Header()
+		h.Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		h.Set("Access-Control-Allow-Headers", "Content-Type, Range, X-PIN, "+CSRFHeader)
+		h.Set("Access-Control-Max-Age", "600")
+		w.WriteHeader(http.StatusNoContent)
+		return false
+	}
+
+	if !isMutating(r) || origin == "" || s.OriginAllowed(r, origin) {
+		return true
+	}
+	slog.InfoContext(r.Context(), "Cross-site request blocked", "method", r.Method, "path", r.URL.Path, "origin", origin, "ip", s.ClientIP(r))
+	writeJSON(w, http.StatusForbidden, map[string]any{"error": types.ApiError{Message: "跨站请求被拒绝"}})
+	return false
+}
+
+// validCSRF reports whether a mutating request carries the session's CSRF token.
+func validCSRF(r *http.Request, sess *types.Session) bool {
+	got := r.Header.Get(CSRFHeader)
+	return got != "" && subtle.ConstantTimeCompare([]byte(got), []byte(sess.CSRFToken)) == 1
+}
+
+// ensureCSRFToken gives a session created before CSRF tokens existed a token.
+func ensureCSRFToken(r *http.Request, sess *types.Session) {
+	if sess.CSRFToken != "" {
+		return
+	}
+	token, err := util.NewToken(24)
+	if err != nil {
+		return
+	}
+	if err := db.SetSessionCSRFToken(r.Context(), sess.ID, token); err == nil {
+		sess.CSRFToken = token
+	}
+}
+
+// setCSRFCookie hands the session's CSRF token to the frontend unless the
+// request already carries it.
+func setCSRFCookie(w http.ResponseWriter, r *http.Request, sess *types.Session) {
+	if sess.CSRFToken == "" {
+		return
+	}
+	if c, err := r.Cookie(CSRFCookie); err == nil && c.Value == sess.CSRFToken {
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     CSRFCookie,
+		Value:    sess.CSRFToken,
+		Path:     "/",
+		MaxAge:   int(time.Until(sess.ExpiresAt).Seconds()),
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"msp/internal/config"
+)
+
+func TestOriginAndCSRF(t *testing.T) {
+	s := newAuthTestServer(t)
+	h := New(s)
+	_ = s.UpdateConfig(func(cfg *config.Config) {
+		cfg.Security.AllowedOrigins = []string{"https://media.example.com"}
+	})
+	protected := WithSecurity(s, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	w := httptest.NewRecorder()
+	h.HandlePIN(w, httptest.NewRequest(http.MethodPost, "/api/pin", bytes.NewBufferString(`{"pin":"4321"}`)))
+	var sess, csrf *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		switch c.Name {
+		case SessionCookie:
+			sess = c
+		case CSRFCookie:
+			csrf = c
+		}
+	}
+	if sess == nil || csrf == nil || csrf.HttpOnly {
+		t.Fatalf("Expected session and script-readable CSRF cookies, got %v", w.Result().Cookies())
+	}
+
+	post := func(origin, token string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/prefs", bytes.NewBufferString(`{}`))
+		req.RemoteAddr = "192.0.2.1:1234"
+		req.AddCookie(sess)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		if token != "" {
+			req.Header.Set(CSRFHeader, token)
+		}
+		w := httptest.NewRecorder()
+		protected.ServeHTTP(w, req)
+		return w.Code
+	}
+	if code := post("http://localhost:8099", csrf.Value); code != http.StatusOK {
+		t.Errorf("Expected local origin with token to pass, got %d", code)
+	}
+	if code := post("http://localhost:8099", ""); code != http.StatusForbidden {
+		t.Errorf("Expected missing CSRF token to be rejected, got %d", code)
+	}
+	if code := post("http://localhost:8099", "wrong"); code != http.StatusForbidden {
+		t.Errorf("Expected wrong CSRF token to be rejected, got %d", code)
+	}
+	if code := post("http://evil.example", csrf.Value); code != http.StatusForbidden {
+		t.Errorf("Expected foreign origin to be rejected, got %d", code)
+	}
+	for _, origin := range []string{"http://localhost:3000", "https://localhost:8099", "http://127.0.0.1"} {
+		if code := post(origin, csrf.Value); code != http.StatusForbidden {
+			t.Errorf("Expected local origin %s on another scheme or port to be rejected, got %d", origin, code)
+		}
+	}
+	if code := post("null", csrf.Value); code != http.StatusForbidden {
+		t.Errorf("Expected opaque origin to be rejected, got %d", code)
+	}
+	if code := post("https://media.example.com", csrf.Value); code != http.StatusOK {
+		t.Errorf("Expected allowed origin to pass, got %d", code)
+	}
+
+	// Same-origin follows the listener's scheme and port
+	s.SetListener("", true, func() int { return 443 })
+	if code := post("https://localhost", csrf.Value); code != http.StatusOK {
+		t.Errorf("Expected HTTPS origin on the served port to pass, got %d", code)
+	}
+	if code := post("http://localhost:8099", csrf.Value); code != http.StatusForbidden {
+		t.Errorf("Expected HTTP origin to be rejected when serving HTTPS, got %d", code)
+	}
+
+	// Referer is used when Origin is absent
+	req := httptest.NewRequest(http.MethodPost, "/api/pin", bytes.NewBufferString(`{"pin":"4321"}`))
+	req.Header.Set("Referer", "http://evil.example/page")
+	w = httptest.NewRecorder()
+	protected.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected foreign referer to be rejected on login, got %d", w.Code)
+	}
+
+	// CORS preflight: only allowed origins get credentialed CORS headers
+	preflight := func(origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, "/api/prefs", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		w := httptest.NewRecorder()
+		protected.ServeHTTP(w, req)
+		return w
+	}
+	if w := preflight("https://media.example.com"); w.Code != http.StatusNoContent ||
+		w.Header().Get("Access-Control-Allow-Origin") != "https://media.example.com" ||
+		w.Header().Get("Access-Control-Allow-Credentials") != "true" {
+		t.Errorf("Expected CORS preflight to succeed, got %d %v", w.Code, w.Header())
+	}
+	if w := preflight("http://evil.example"); w.Code != http.StatusForbidden || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("Expected CORS preflight to be refused, got %d %v", w.Code, w.Header())
+	}
+}
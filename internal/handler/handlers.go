@@ -3,20 +3,25 @@ package handler
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
-	"log"
+	"log/slog"
 	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"msp/internal/config"
 	"msp/internal/db"
+	"msp/internal/logging"
 	"msp/internal/media"
+	"msp/internal/metrics"
 	"msp/internal/server"
 	"msp/internal/service"
 	"msp/internal/types"
@@ -39,6 +44,7 @@ func (h *Handler) HandleConfig(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
 		view := h.configService.GetConfigView()
+		view.Config.Shares = sharesFor(r, view.Config.Shares)
 		writeJSON(w, http.StatusOK, view)
 	case http.MethodPost:
 		var cfg config.Config
@@ -47,11 +53,23 @@ func (h *Handler) HandleConfig(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		old := h.s.Config()
 		newCfg, err := h.configService.UpdateConfig(cfg)
+		if errors.Is(err, service.ErrInvalidConfig) {
+			writeJSON(w, http.StatusBadRequest, types.ConfigResponse{Error: &types.ApiError{Message: "配置校验失败", Fields: config.FieldErrors(err)}})
+			return
+		}
+		if errors.Is(err, service.ErrEnvOverride) {
+			writeJSON(w, http.StatusConflict, types.ConfigResponse{Error: &types.ApiError{Message: err.Error()}})
+			return
+		}
 		if err != nil {
 			writeJSON(w, http.StatusInternalServerError, types.ConfigResponse{Error: &types.ApiError{Message: "写入配置失败"}})
 			return
 		}
+		if changes := server.ConfigDiff(old, h.s.Config()); len(changes) > 0 {
+			h.audit(r, types.AuditEvent{Type: types.AuditConfigChange, Changes: changes})
+		}
 		writeJSON(w, http.StatusOK, types.ConfigResponse{Config: newCfg})
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
@@ -70,9 +88,18 @@ func (h *Handler) HandleShares(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if v := h.s.EnvVar("shares"); v != "" {
+		writeJSON(w, http.StatusConflict, types.SharesOpResponse{Error: &types.ApiError{Message: "共享目录由环境变量 " + v + " 设置，不能修改"}})
+		return
+	}
+
 	op, p, label := normalizeSharesOp(req)
-	newCfg, err := h.applySharesOp(op, p, label)
+	newCfg, err := h.applySharesOp(op, config.Share{Label: label, Path: p, AllowUsers: req.AllowUsers, AllowGroups: req.AllowGroups})
 
+	if fields := config.FieldErrors(err); fields != nil {
+		writeJSON(w, http.StatusBadRequest, types.SharesOpResponse{Error: &types.ApiError{Message: "配置校验失败", Fields: fields}})
+		return
+	}
 	if err != nil {
 		if strings.Contains(err.Error(), "exists") || strings.Contains(err.Error(), "missing") {
 			writeJSON(w, http.StatusBadRequest, types.SharesOpResponse{Error: &types.ApiError{Message: err.Error()}})
@@ -82,8 +109,12 @@ func (h *Handler) HandleShares(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.s.InvalidateMediaCache()
-	writeJSON(w, http.StatusOK, types.SharesOpResponse{Config: newCfg})
+	typ := types.AuditShareAdd
+	if op == "remove" {
+		typ = types.AuditShareRemove
+	}
+	h.audit(r, types.AuditEvent{Type: typ, Detail: strings.TrimSpace(label + " " + p)})
+	writeJSON(w, http.StatusOK, types.SharesOpResponse{Config: newCfg.Redacted()})
 }
 
 func normalizeSharesOp(req types.SharesOpRequest) (op string, path string, label string) {
@@ -96,25 +127,32 @@ func normalizeSharesOp(req types.SharesOpRequest) (op string, path string, label
 	return op, path, label
 }
 
-func (h *Handler) applySharesOp(op string, path string, label string) (config.Config, error) {
+func (h *Handler) applySharesOp(op string, sh config.Share) (config.Config, error) {
 	switch op {
 	case "add":
-		return h.handleShareAdd(path, label)
+		return h.handleShareAdd(sh)
 	case "remove":
-		return h.handleShareRemove(path)
+		return h.handleShareRemove(sh.Path)
 	default:
 		return config.Config{}, fmt.Errorf("不支持的 op（add/remove）")
 	}
 }
 
-func (h *Handler) handleShareAdd(p, label string) (config.Config, error) {
-	if p == "" || !util.IsExistingDir(p) {
+func (h *Handler) handleShareAdd(sh config.Share) (config.Config, error) {
+	if sh.Path == "" || !util.IsExistingDir(sh.Path) {
 		return config.Config{}, fmt.Errorf("目录不存在或不可访问")
 	}
+	// Reject labels and folders that clash with existing shares up front,
+	// since UpdateConfig cannot fail on them.
+	current := h.s.Config().Shares
+	candidate := util.DedupeShares(util.NormalizeShares(append(slices.Clone(current), sh)))
+	if err := config.ValidateShares(candidate, current); err != nil {
+		return config.Config{}, err
+	}
 
 	var newCfg config.Config
 	err := h.s.UpdateConfig(func(cfg *config.Config) {
-		cfg.Shares = append(cfg.Shares, config.Share{Label: label, Path: p})
+		cfg.Shares = append(cfg.Shares, sh)
 		cfg.Shares = util.NormalizeShares(cfg.Shares)
 		cfg.Shares = util.DedupeShares(cfg.Shares)
 		newCfg = *cfg
@@ -154,9 +192,9 @@ func (h *Handler) HandleIP(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) HandlePrefs(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
-		prefs, err := db.GetAllPrefs(r.Context())
+		prefs, err := db.GetAllPrefs(r.Context(), principalOf(r).UserID())
 		if err != nil {
-			log.Printf("Error in GetAllPrefs: %v", err)
+			slog.ErrorContext(r.Context(), "GetAllPrefs failed", "err", err)
 			writeJSON(w, http.StatusInternalServerError, types.PrefsResponse{Error: &types.ApiError{Message: "读取偏好失败"}})
 			return
 		}
@@ -171,8 +209,8 @@ func (h *Handler) HandlePrefs(w http.ResponseWriter, r *http.Request) {
 			writeJSON(w, http.StatusBadRequest, types.PrefsResponse{Error: &types.ApiError{Message: "缺少 prefs"}})
 			return
 		}
-		if err := db.SetPrefs(r.Context(), req.Prefs); err != nil {
-			log.Printf("Error in SetPrefs: %v", err)
+		if err := db.SetPrefs(r.Context(), principalOf(r).UserID(), req.Prefs); err != nil {
+			slog.ErrorContext(r.Context(), "SetPrefs failed", "err", err)
 			writeJSON(w, http.StatusInternalServerError, types.PrefsResponse{Error: &types.ApiError{Message: "写入偏好失败"}})
 			return
 		}
@@ -190,9 +228,9 @@ func (h *Handler) HandleProgress(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "missing id", http.StatusBadRequest)
 			return
 		}
-		t, err := db.GetProgress(r.Context(), id)
+		t, err := db.GetProgress(r.Context(), principalOf(r).UserID(), id)
 		if err != nil {
-			log.Printf("Error in GetProgress: %v", err)
+			slog.ErrorContext(r.Context(), "GetProgress failed", "media_id", id, "err", err)
 			writeJSON(w, http.StatusInternalServerError, map[string]any{"error": "读取进度失败"})
 			return
 		}
@@ -210,11 +248,15 @@ func (h *Handler) HandleProgress(w http.ResponseWriter, r *http.Request) {
 			writeJSON(w, http.StatusBadRequest, map[string]any{"error": "缺少 id"})
 			return
 		}
-		if err := db.SetProgress(r.Context(), req.ID, req.Time); err != nil {
-			log.Printf("Error in SetProgress: %v", err)
+		uid := principalOf(r).UserID()
+		if err := db.SetProgress(r.Context(), uid, req.ID, req.Time); err != nil {
+			slog.ErrorContext(r.Context(), "SetProgress failed", "media_id", req.ID, "err", err)
 			writeJSON(w, http.StatusInternalServerError, map[string]any{"error": "保存进度失败"})
 			return
 		}
+		if err := db.TouchHistory(r.Context(), uid, req.ID, time.Now()); err != nil {
+			slog.ErrorContext(r.Context(), "TouchHistory failed", "media_id", req.ID, "err", err)
+		}
 		w.WriteHeader(http.StatusNoContent)
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
@@ -232,12 +274,13 @@ func (h *Handler) HandleLog(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if req.Msg != "" {
-		h.s.Log(req.Level, req.Msg)
+		slog.Log(r.Context(), logging.ParseLevel(req.Level), req.Msg, "source", "web", "ip", h.s.ClientIP(r))
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
-// HandlePIN validates PIN authentication
+// HandlePIN validates the viewer PIN or admin credential and issues a
+// session with the matching role
 func (h *Handler) HandlePIN(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed)
@@ -245,7 +288,7 @@ func (h *Handler) HandlePIN(w http.ResponseWriter, r *http.Request) {
 	}
 
 	cfg := h.s.Config()
-	if !cfg.Security.PINEnabled {
+	if !cfg.Security.PINEnabled && cfg.Security.AdminPINHash == "" {
 		writeJSON(w, http.StatusOK, map[string]any{
 			"valid":   true,
 			"enabled": false,
@@ -254,7 +297,8 @@ func (h *Handler) HandlePIN(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		PIN string `json:"pin"`
+		PIN   string `json:"pin"`
+		Label string `json:"label"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		writeJSON(w, http.StatusBadRequest, map[string]any{
@@ -264,23 +308,42 @@ func (h *Handler) HandlePIN(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	valid := req.PIN == cfg.Security.PIN
-	if valid {
-		// Set cookie for future requests
-		http.SetCookie(w, &http.Cookie{
-			Name:     "msp_pin",
-			Value:    req.PIN,
-			Path:     "/",
-			MaxAge:   86400 * 7, // 7 days
-			HttpOnly: true,
-			SameSite: http.SameSiteStrictMode,
+	// Viewers need no PIN when PIN auth is disabled; only admin logins count
+	if !cfg.Security.PINEnabled && req.PIN == "" {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"valid":   true,
+			"enabled": false,
 		})
+		return
 	}
 
-	writeJSON(w, http.StatusOK, map[string]any{
+	clientIP := h.s.ClientIP(r)
+	role := pinRole(cfg.Security, req.PIN)
+	valid := role != ""
+	if !valid {
+		h.s.RecordLoginFailure(clientIP, pinTarget(cfg.Security), "")
+	} else {
+		h.s.RecordLoginSuccess(clientIP, role)
+		// Issue a server-side session instead of storing the PIN in a cookie
+		if err := issueSession(w, r, h.s, strings.TrimSpace(req.Label), role, 0); err != nil {
+			slog.ErrorContext(r.Context(), "issueSession failed", "err", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]any{
+				"valid": false,
+				"error": "创建会话失败",
+			})
+			return
+		}
+		h.audit(r, types.AuditEvent{Type: types.AuditLogin, Actor: role, Detail: "PIN"})
+	}
+
+	resp := map[string]any{
 		"valid":   valid,
-		"enabled": true,
-	})
+		"enabled": cfg.Security.PINEnabled,
+	}
+	if valid {
+		resp["role"] = role
+	}
+	writeJSON(w, http.StatusOK, resp)
 }
 
 func (h *Handler) HandleMedia(w http.ResponseWriter, r *http.Request) {
@@ -294,7 +357,11 @@ func (h *Handler) HandleMedia(w http.ResponseWriter, r *http.Request) {
 	blacklist := cfg.Blacklist
 
 	refresh := r.URL.Query().Get("refresh") == "1"
-	resp, etag := h.s.GetOrBuildMediaCache(r.Context(), shares, blacklist, refresh)
+	resp, tag := h.s.GetOrBuildMediaCache(r.Context(), shares, blacklist, refresh)
+	if visible := sharesFor(r, shares); len(visible) != len(shares) {
+		filterMediaByShares(&resp, visible)
+		tag = shareScopedTag(tag, visible)
+	}
 
 	resp.VideosTotal = len(resp.Videos)
 	resp.AudiosTotal = len(resp.Audios)
@@ -308,12 +375,63 @@ func (h *Handler) HandleMedia(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if writeNotModifiedIfMatch(w, r, etag, refresh) {
+	if writeNotModifiedIfMatch(w, r, weakETag(tag), refresh) {
 		return
 	}
 	writeJSON(w, http.StatusOK, resp)
 }
 
+// filterMediaByShares drops items outside the visible shares. It allocates
+// new slices so the shared media cache is left untouched.
+func filterMediaByShares(resp *types.MediaResponse, visible []config.Share) {
+	roots := make([]string, 0, len(visible))
+	for _, sh := range visible {
+		roots = append(roots, util.NormalizePath(sh.Path))
+	}
+	keep := func(items []types.MediaItem) []types.MediaItem {
+		out := make([]types.MediaItem, 0, len(items))
+		for _, it := range items {
+			p, err := util.DecodeID(it.ID)
+			if err != nil {
+				continue
+			}
+			for _, root := range roots {
+				if util.WithinRoot(root, p) {
+					out = append(out, it)
+					break
+				}
+			}
+		}
+		return out
+	}
+	resp.Shares = visible
+	resp.Videos = keep(resp.Videos)
+	resp.Audios = keep(resp.Audios)
+	resp.Images = keep(resp.Images)
+	resp.Others = keep(resp.Others)
+}
+
+// shareScopedTag makes the media version tag depend on the visible shares
+// so clients with different access never share a cached response.
+func shareScopedTag(tag string, visible []config.Share) string {
+	if tag == "" {
+		return ""
+	}
+	hs := fnv.New32a()
+	for _, sh := range visible {
+		_, _ = io.WriteString(hs, sh.Path+"\n")
+	}
+	return fmt.Sprintf("%s-%08x", tag, hs.Sum32())
+}
+
+// weakETag renders a version tag as a weak ETag, W/"tag".
+func weakETag(tag string) string {
+	if tag == "" {
+		return ""
+	}
+	return `W/"` + tag + `"`
+}
+
 func parseLimitParam(r *http.Request) int {
 	v := strings.TrimSpace(r.URL.Query().Get("limit"))
 	if v == "" {
@@ -386,11 +504,16 @@ func (h *Handler) HandleStream(w http.ResponseWriter, r *http.Request) {
 		if h.tryServeTranscode(w, r, target, ext) {
 			return
 		}
-		log.Printf("[WARN] Transcode failed for %s, falling back to direct play", target)
+		slog.WarnContext(r.Context(), "Transcode failed, falling back to direct play", "media_id", r.URL.Query().Get("id"), "path", target)
 	}
 
 	// Direct Play
-	h.serveDirect(w, r, f, st, ct)
+	download := r.URL.Query().Get("download") == "1"
+	if link := principalOf(r).Link; download && link != nil && !link.AllowDownload {
+		http.Error(w, "download not allowed", http.StatusForbidden)
+		return
+	}
+	h.serveDirect(w, r, f, st, ct, download)
 }
 
 func (h *Handler) resolveMediaTarget(w http.ResponseWriter, r *http.Request) (string, *os.File, os.FileInfo, error) {
@@ -408,8 +531,7 @@ func (h *Handler) resolveMediaTarget(w http.ResponseWriter, r *http.Request) (st
 	//nolint:gosec // Validated via util.DecodeID and IsAllowedFile below
 	target = util.NormalizePath(target)
 
-	cfg := h.s.Config()
-	shares := append([]config.Share(nil), cfg.Shares...)
+	shares := sharesFor(r, h.s.Config().Shares)
 
 	if !util.IsAllowedFile(target, shares) {
 		http.Error(w, "not allowed", http.StatusForbidden)
@@ -493,7 +615,7 @@ func (h *Handler) tryServeTranscode(w http.ResponseWriter, r *http.Request, targ
 
 	stream, err := media.TranscodeStream(r.Context(), target, opts)
 	if err != nil {
-		log.Printf("[WARN] Transcode stream error: %v", err)
+		slog.WarnContext(r.Context(), "Transcode stream failed", "media_id", r.URL.Query().Get("id"), "err", err)
 		return false
 	}
 	defer func() { _ = stream.Close() }()
@@ -506,16 +628,23 @@ func (h *Handler) tryServeTranscode(w http.ResponseWriter, r *http.Request, targ
 	w.Header().Set("X-MSP-Transcode", "1")
 	w.Header().Set("Cache-Control", "no-store")
 	w.Header().Del("Content-Length")
-	_, _ = io.Copy(w, stream)
+	n, _ := io.Copy(w, stream)
+	metrics.StreamedBytes.WithLabelValues("transcode").Add(float64(n))
 	return true
 }
 
-func (h *Handler) serveDirect(w http.ResponseWriter, r *http.Request, f *os.File, st os.FileInfo, ct string) {
+func (h *Handler) serveDirect(w http.ResponseWriter, r *http.Request, f *os.File, st os.FileInfo, ct string, download bool) {
+	disposition := "inline"
+	if download {
+		disposition = "attachment"
+	}
 	w.Header().Set("Content-Type", ct)
 	w.Header().Set("Accept-Ranges", "bytes")
 	w.Header().Set("Cache-Control", "no-store")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", st.Name()))
-	http.ServeContent(w, r, st.Name(), time.Time{}, f)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("%s; filename=%q", disposition, st.Name()))
+	cw := &countingWriter{ResponseWriter: w}
+	http.ServeContent(cw, r, st.Name(), time.Time{}, f)
+	metrics.StreamedBytes.WithLabelValues("direct").Add(float64(cw.n))
 }
 
 func (h *Handler) HandleProbe(w http.ResponseWriter, r *http.Request) {
@@ -538,8 +667,7 @@ func (h *Handler) HandleProbe(w http.ResponseWriter, r *http.Request) {
 	//nolint:gosec // Validated via util.DecodeID
 	target = util.NormalizePath(target)
 
-	cfg := h.s.Config()
-	shares := append([]config.Share(nil), cfg.Shares...)
+	shares := sharesFor(r, h.s.Config().Shares)
 
 	if !util.IsAllowedFile(target, shares) {
 		writeJSON(w, http.StatusForbidden, types.ProbeResponse{Error: &types.ApiError{Message: "not allowed"}})
@@ -583,6 +711,43 @@ func (h *Handler) HandleSubtitle(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// HandleLyrics returns parsed lyrics for an audio item.
+// The id is the audio file ID; sidecar .lrc files take priority over embedded tags.
+func (h *Handler) HandleLyrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		writeJSON(w, http.StatusBadRequest, types.LyricsResponse{Error: &types.ApiError{Message: "missing id"}})
+		return
+	}
+
+	target, err := util.DecodeID(id)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.LyricsResponse{Error: &types.ApiError{Message: "bad id"}})
+		return
+	}
+	//nolint:gosec // Validated via util.DecodeID
+	target = util.NormalizePath(target)
+
+	shares := sharesFor(r, h.s.Config().Shares)
+
+	if !util.IsAllowedFile(target, shares) {
+		writeJSON(w, http.StatusForbidden, types.LyricsResponse{Error: &types.ApiError{Message: "not allowed"}})
+		return
+	}
+
+	resp, ok := media.LoadLyrics(target)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, types.LyricsResponse{Lines: []types.LyricLine{}, Error: &types.ApiError{Message: "no lyrics"}})
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
 func (h *Handler) serveVTT(w http.ResponseWriter, r *http.Request, f *os.File, st os.FileInfo) {
 	w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
 	w.Header().Set("Cache-Control", "private, max-age=0")
@@ -610,6 +775,6 @@ func writeJSON(w http.ResponseWriter, status int, v any) {
 	enc := json.NewEncoder(w)
 	enc.SetEscapeHTML(false)
 	if err := enc.Encode(v); err != nil {
-		log.Printf("writeJSON encode error: %v", err)
+		slog.Error("writeJSON encode failed", "err", err)
 	}
 }
@@ -0,0 +1,311 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"msp/internal/config"
+	"msp/internal/db"
+	"msp/internal/media"
+	"msp/internal/server"
+	"msp/internal/types"
+	"msp/internal/util"
+)
+
+const (
+	// roleLink is the role of requests authorized by a share link.
+	roleLink = "link"
+
+	defaultLinkHours = 24
+	maxLinkHours     = 24 * 365
+)
+
+// linkRoutes are the endpoints that accept ?link= instead of a login.
+var linkRoutes = map[string]bool{
+	"/api/stream":     true,
+	"/api/subtitle":   true,
+	"/api/links/open": true,
+}
+
+// signLink returns the HMAC-SHA256 signature of a link's ID, target and expiry.
+func signLink(secret string, link types.ShareLink) string {
+	key, _ := hex.DecodeString(secret)
+	mac := hmac.New(sha256.New, key)
+	_, _ = fmt.Fprintf(mac, "%s\n%s\n%d", link.ID, link.MediaID, link.ExpiresAt.Unix())
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// linkToken is the value of the ?link= parameter: "<id>.<signature>".
+func linkToken(secret string, link types.ShareLink) string {
+	return link.ID + "." + signLink(secret, link)
+}
+
+// linkURL returns the relative URL a guest opens for link.
+func linkURL(link types.ShareLink, token string) string {
+	if link.IsDir {
+		return "/api/links/open?link=" + url.QueryEscape(token)
+	}
+	return "/api/stream?id=" + url.QueryEscape(link.MediaID) + "&link=" + url.QueryEscape(token)
+}
+
+// authenticateLink validates a share link token. The link must exist, be
+// unexpired, carry a valid signature, point into a configured share and
+// have uses left, unless the request belongs to a playback that was already
+// counted. Starting a playback counts a use and sets a playback cookie.
+func authenticateLink(s *server.Server, w http.ResponseWriter, r *http.Request, token string) (principal, bool) {
+	id, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return principal{}, false
+	}
+	link, found, err := db.GetShareLink(r.Context(), id)
+	if err != nil || !found {
+		return principal{}, false
+	}
+	cfg := s.Config()
+	secret := cfg.Security.LinkSecret
+	if !hmac.Equal([]byte(sig), []byte(signLink(secret, link))) {
+		return principal{}, false
+	}
+	target, err := util.DecodeID(link.MediaID)
+	if err != nil || !linkTargetShared(util.NormalizePath(target), link.IsDir, cfg.Shares) {
+		return principal{}, false
+	}
+
+	counts := countsAsLinkUse(r)
+	if playing, ok := linkPlayback(secret, r, link); ok && (!counts || playing == r.URL.Query().Get("id")) {
+		return principal{Role: roleLink, Link: &link}, true
+	}
+	if link.MaxUses > 0 && link.Uses >= link.MaxUses {
+		return principal{}, false
+	}
+	if counts {
+		ok, err := db.UseShareLink(r.Context(), link.ID)
+		if err != nil || !ok {
+			return principal{}, false
+		}
+		link.Uses++
+		setLinkPlayback(w, r, secret, link)
+	}
+	return principal{Role: roleLink, Link: &link}, true
+}
+
+// countsAsLinkUse reports whether the request streams a media file and
+// therefore starts a playback or download, unless it carries the playback
+// cookie of that file. Subtitles are free.
+func countsAsLinkUse(r *http.Request) bool {
+	if r.URL.Path != "/api/stream" {
+		return false
+	}
+	target, err := util.DecodeID(r.URL.Query().Get("id"))
+	return err != nil || !media.IsSubtitleExt(strings.ToLower(filepath.Ext(target)))
+}
+
+// linkPlaybackTTL is how long the requests of one playback through a share
+// link (seeking, subtitles) are covered by the use it was counted as.
+const linkPlaybackTTL = 6 * time.Hour
+
+func linkPlaybackCookie(link types.ShareLink) string {
+	return "msp_link_" + link.ID
+}
+
+// signLinkPlayback returns the signature of a playback cookie.
+func signLinkPlayback(secret string, link types.ShareLink, mediaID string, exp int64) string {
+	key, _ := hex.DecodeString(secret)
+	mac := hmac.New(sha256.New, key)
+	_, _ = fmt.Fprintf(mac, "playback\n%s\n%s\n%d", link.ID, mediaID, exp)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// setLinkPlayback sets the playback cookie for the media file r streams:
+// "<media id>.<expiry>.<signature>".
+func setLinkPlayback(w http.ResponseWriter, r *http.Request, secret string, link types.ShareLink) {
+	mediaID := r.URL.Query().Get("id")
+	expires := time.Now().Add(linkPlaybackTTL)
+	if link.ExpiresAt.Before(expires) {
+		expires = link.ExpiresAt
+	}
+	exp := expires.Unix()
+	http.SetCookie(w, &http.Cookie{
+		Name:     linkPlaybackCookie(link),
+		Value:    fmt.Sprintf("%s.%d.%s", mediaID, exp, signLinkPlayback(secret, link, mediaID, exp)),
+		Path:     "/api/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// linkPlayback returns the media ID of the unexpired playback of link that
+// r belongs to, if any.
+func linkPlayback(secret string, r *http.Request, link types.ShareLink) (string, bool) {
+	c, err := r.Cookie(linkPlaybackCookie(link))
+	if err != nil {
+		return "", false
+	}
+	parts := strings.Split(c.Value, ".")
+	if len(parts) != 3 {
+		return "", false
+	}
+	mediaID, sig := parts[0], parts[2]
+	exp, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() >= exp {
+		return "", false
+	}
+	if !hmac.Equal([]byte(sig), []byte(signLinkPlayback(secret, link, mediaID, exp))) {
+		return "", false
+	}
+	return mediaID, true
+}
+
+// linkTargetShared reports whether a linked file or folder is still inside
+// a configured share, so removing a share also disables its links.
+func linkTargetShared(p string, isDir bool, shares []config.Share) bool {
+	if !isDir {
+		return util.IsAllowedFile(p, shares)
+	}
+	if !util.IsExistingDir(p) {
+		return false
+	}
+	for _, sh := range shares {
+		if root := util.NormalizePath(sh.Path); root != "" && util.WithinRoot(root, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleLinks lists (GET), creates and revokes (POST) share links.
+func (h *Handler) HandleLinks(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		links, err := db.ListShareLinks(r.Context())
+		if err != nil {
+			slog.ErrorContext(r.Context(), "ListShareLinks failed", "err", err)
+			writeJSON(w, http.StatusInternalServerError, types.LinksResponse{Error: &types.ApiError{Message: "读取链接失败"}})
+			return
+		}
+		writeJSON(w, http.StatusOK, types.LinksResponse{Links: links})
+	case http.MethodPost:
+		var req types.LinksOpRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, types.LinksResponse{Error: &types.ApiError{Message: "JSON 解析失败"}})
+			return
+		}
+		switch strings.ToLower(strings.TrimSpace(req.Op)) {
+		case "create":
+			h.createLink(w, r, req)
+		case "revoke":
+			ok, err := db.DeleteShareLink(r.Context(), req.ID)
+			if err != nil {
+				writeJSON(w, http.StatusInternalServerError, types.LinksResponse{Error: &types.ApiError{Message: "撤销链接失败"}})
+				return
+			}
+			if !ok {
+				writeJSON(w, http.StatusNotFound, types.LinksResponse{Error: &types.ApiError{Message: "链接不存在"}})
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			writeJSON(w, http.StatusBadRequest, types.LinksResponse{Error: &types.ApiError{Message: "不支持的 op（create/revoke）"}})
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) createLink(w http.ResponseWriter, r *http.Request, req types.LinksOpRequest) {
+	if req.ID == "" {
+		writeJSON(w, http.StatusBadRequest, types.LinksResponse{Error: &types.ApiError{Message: "缺少 id"}})
+		return
+	}
+	target, err := util.DecodeID(req.ID)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.LinksResponse{Error: &types.ApiError{Message: "bad id"}})
+		return
+	}
+	target = util.NormalizePath(target)
+	cfg := h.s.Config()
+	isDir := util.IsExistingDir(target)
+	if !linkTargetShared(target, isDir, cfg.Shares) {
+		writeJSON(w, http.StatusForbidden, types.LinksResponse{Error: &types.ApiError{Message: "not allowed"}})
+		return
+	}
+
+	hours := req.ExpiresHours
+	if hours <= 0 {
+		hours = defaultLinkHours
+	}
+	if hours > maxLinkHours {
+		hours = maxLinkHours
+	}
+	if req.MaxUses < 0 {
+		req.MaxUses = 0
+	}
+	label := strings.TrimSpace(req.Label)
+	if label == "" {
+		label = filepath.Base(target)
+	}
+
+	id, err := util.NewToken(12)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.LinksResponse{Error: &types.ApiError{Message: "创建链接失败"}})
+		return
+	}
+	now := time.Now()
+	link := types.ShareLink{
+		ID:            id,
+		MediaID:       util.EncodeID(target),
+		IsDir:         isDir,
+		Label:         label,
+		AllowDownload: req.AllowDownload,
+		MaxUses:       req.MaxUses,
+		CreatedBy:     principalOf(r).UserID(),
+		CreatedAt:     now,
+		ExpiresAt:     now.Add(time.Duration(hours * float64(time.Hour))).Truncate(time.Second),
+	}
+	if err := db.CreateShareLink(r.Context(), &link); err != nil {
+		slog.ErrorContext(r.Context(), "CreateShareLink failed", "err", err)
+		writeJSON(w, http.StatusInternalServerError, types.LinksResponse{Error: &types.ApiError{Message: "创建链接失败"}})
+		return
+	}
+	slog.InfoContext(r.Context(), "Share link created", "id", link.ID, "media_id", link.MediaID, "target", label, "expires", link.ExpiresAt)
+
+	token := linkToken(cfg.Security.LinkSecret, link)
+	writeJSON(w, http.StatusOK, types.LinkCreatedResponse{Link: link, Token: token, URL: linkURL(link, token)})
+}
+
+// HandleLinkOpen describes a share link and lists the media it grants
+// access to. Files are streamed via /api/stream?id=...&link=...
+func (h *Handler) HandleLinkOpen(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	p := principalOf(r)
+	if p.Link == nil {
+		writeJSON(w, http.StatusBadRequest, types.LinkOpenResponse{Error: &types.ApiError{Message: "缺少 link"}})
+		return
+	}
+
+	cfg := h.s.Config()
+	resp, _ := h.s.GetOrBuildMediaCache(r.Context(), cfg.Shares, cfg.Blacklist, false)
+	filterMediaByShares(&resp, sharesFor(r, cfg.Shares))
+
+	items := make([]types.MediaItem, 0, len(resp.Videos)+len(resp.Audios)+len(resp.Images)+len(resp.Others))
+	for _, list := range [][]types.MediaItem{resp.Videos, resp.Audios, resp.Images, resp.Others} {
+		items = append(items, list...)
+	}
+	writeJSON(w, http.StatusOK, types.LinkOpenResponse{Link: *p.Link, Items: items})
+}
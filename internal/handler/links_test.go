@@ -0,0 +1,198 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"msp/internal/config"
+	"msp/internal/types"
+	"msp/internal/util"
+)
+
+func TestShareLinks(t *testing.T) {
+	s := newAuthTestServer(t)
+	h := New(s)
+
+	root := t.TempDir()
+	file := filepath.Join(root, "a.mp3")
+	if err := os.WriteFile(file, []byte("0123456789"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_ = s.UpdateConfig(func(cfg *config.Config) {
+		cfg.Shares = []config.Share{{Label: "Music", Path: root}}
+	})
+
+	admin := principal{Role: RoleAdmin}
+	create := func(body string) types.LinkCreatedResponse {
+		w := httptest.NewRecorder()
+		h.HandleLinks(w, withPrincipal(httptest.NewRequest(http.MethodPost, "/api/admin/links", bytes.NewBufferString(body)), admin))
+		if w.Code != http.StatusOK {
+			t.Fatalf("create link: %d %s", w.Code, w.Body.String())
+		}
+		var resp types.LinkCreatedResponse
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp
+	}
+	link := create(`{"op":"create","id":"` + util.EncodeID(file) + `","maxUses":2}`)
+	if link.Link.IsDir || !strings.HasPrefix(link.URL, "/api/stream?") {
+		t.Fatalf("Unexpected link: %+v", link)
+	}
+
+	app := WithSecurity(s, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/stream":
+			h.HandleStream(w, r)
+		case "/api/links/open":
+			h.HandleLinkOpen(w, r)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	do := func(target, rng string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if rng != "" {
+			req.Header.Set("Range", rng)
+		}
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, req)
+		return w
+	}
+	get := func(target, rng string) int {
+		return do(target, rng, nil).Code
+	}
+
+	// The link grants access to its file only
+	first := do(link.URL, "", nil)
+	if first.Code != http.StatusOK {
+		t.Fatalf("Expected link stream to succeed, got %d", first.Code)
+	}
+	playback := first.Result().Cookies()
+	if len(playback) != 1 {
+		t.Fatalf("Expected a playback cookie, got %v", playback)
+	}
+	if code := get("/api/media?link="+link.Token, ""); code != http.StatusUnauthorized {
+		t.Errorf("Expected link to be rejected outside link routes, got %d", code)
+	}
+	if code := do(link.URL+"&download=1", "", playback).Code; code != http.StatusForbidden {
+		t.Errorf("Expected download to be denied, got %d", code)
+	}
+
+	// Tampered signature
+	if code := get(link.URL+"x", ""); code != http.StatusUnauthorized {
+		t.Errorf("Expected tampered link to be rejected, got %d", code)
+	}
+
+	// Seeking within a playback does not count as a use; the third playback
+	// exceeds maxUses, whatever Range it asks for
+	if code := do(link.URL, "bytes=5-", playback).Code; code != http.StatusPartialContent {
+		t.Errorf("Expected range request to succeed, got %d", code)
+	}
+	if code := get(link.URL, "bytes=5-"); code != http.StatusPartialContent {
+		t.Errorf("Expected second playback to succeed, got %d", code)
+	}
+	for _, rng := range []string{"", "bytes=0-", "bytes=1-"} {
+		if code := get(link.URL, rng); code != http.StatusUnauthorized {
+			t.Errorf("Expected exhausted link to be rejected for Range %q, got %d", rng, code)
+		}
+	}
+	if code := do(link.URL, "bytes=1-", playback).Code; code != http.StatusPartialContent {
+		t.Errorf("Expected running playback to continue, got %d", code)
+	}
+	forged := []*http.Cookie{{Name: playback[0].Name, Value: playback[0].Value + "x"}}
+	if code := do(link.URL, "bytes=1-", forged).Code; code != http.StatusUnauthorized {
+		t.Errorf("Expected forged playback cookie to be rejected, got %d", code)
+	}
+
+	// Folder links list their items; revoking disables them
+	dir := create(`{"op":"create","id":"` + util.EncodeID(root) + `"}`)
+	if !dir.Link.IsDir {
+		t.Fatalf("Expected folder link, got %+v", dir.Link)
+	}
+	req := httptest.NewRequest(http.MethodGet, dir.URL, nil)
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), util.EncodeID(file)) {
+		t.Errorf("Expected folder listing, got %d %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	h.HandleLinks(w, withPrincipal(httptest.NewRequest(http.MethodPost, "/api/admin/links", bytes.NewBufferString(`{"op":"revoke","id":"`+dir.Link.ID+`"}`)), admin))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("revoke: %d", w.Code)
+	}
+	if code := get(dir.URL, ""); code != http.StatusUnauthorized {
+		t.Errorf("Expected revoked link to be rejected, got %d", code)
+	}
+
+	// Links to paths outside the shares cannot be created
+	w = httptest.NewRecorder()
+	h.HandleLinks(w, withPrincipal(httptest.NewRequest(http.MethodPost, "/api/admin/links", bytes.NewBufferString(`{"op":"create","id":"`+util.EncodeID(t.TempDir())+`"}`)), admin))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected out-of-share link to be rejected, got %d", w.Code)
+	}
+}
+
+func TestShareLinkSubtitles(t *testing.T) {
+	s := newAuthTestServer(t)
+	h := New(s)
+
+	root := t.TempDir()
+	for name, body := range map[string]string{
+		"movie.mp4":    "0123456789",
+		"movie.en.srt": "1\n00:00:01,000 --> 00:00:02,000\nHello\n",
+		"movie.vtt":    "WEBVTT\n",
+		"other.srt":    "1\n00:00:01,000 --> 00:00:02,000\nOther\n",
+	} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(body), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = s.UpdateConfig(func(cfg *config.Config) {
+		cfg.Shares = []config.Share{{Label: "Movies", Path: root}}
+	})
+
+	w := httptest.NewRecorder()
+	body := `{"op":"create","id":"` + util.EncodeID(filepath.Join(root, "movie.mp4")) + `","maxUses":1}`
+	h.HandleLinks(w, withPrincipal(httptest.NewRequest(http.MethodPost, "/api/admin/links", bytes.NewBufferString(body)), principal{Role: RoleAdmin}))
+	var link types.LinkCreatedResponse
+	_ = json.Unmarshal(w.Body.Bytes(), &link)
+
+	app := WithSecurity(s, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/stream":
+			h.HandleStream(w, r)
+		case "/api/subtitle":
+			h.HandleSubtitle(w, r)
+		}
+	}))
+	get := func(path, name string) int {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path+"?id="+util.EncodeID(filepath.Join(root, name))+"&link="+link.Token, nil))
+		return w.Code
+	}
+
+	// Sidecar subtitles of the linked video are shared and do not use it up
+	for i := 0; i < 2; i++ {
+		if code := get("/api/subtitle", "movie.en.srt"); code != http.StatusOK {
+			t.Errorf("Expected sidecar .srt to be served, got %d", code)
+		}
+		if code := get("/api/stream", "movie.vtt"); code != http.StatusOK {
+			t.Errorf("Expected sidecar .vtt to be served, got %d", code)
+		}
+	}
+	if code := get("/api/subtitle", "other.srt"); code != http.StatusForbidden {
+		t.Errorf("Expected unrelated subtitle to be denied, got %d", code)
+	}
+	if code := get("/api/stream", "movie.mp4"); code != http.StatusOK {
+		t.Errorf("Expected the only playback to succeed, got %d", code)
+	}
+}
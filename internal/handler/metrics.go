@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"msp/internal/ipmatch"
+	"msp/internal/metrics"
+	"msp/internal/server"
+)
+
+type routeKey struct{}
+
+// WithRoute records the ServeMux pattern that handles each request, so
+// that WithLog can label metrics by route instead of by raw path.
+func WithRoute(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.ServeHTTP(w, r)
+		if route, ok := r.Context().Value(routeKey{}).(*string); ok {
+			*route = r.Pattern // set by mux.ServeHTTP
+		}
+	})
+}
+
+// withRouteSlot returns r with room for the route set by WithRoute.
+func withRouteSlot(r *http.Request) (*http.Request, *string) {
+	route := new(string)
+	return r.WithContext(context.WithValue(r.Context(), routeKey{}, route)), route
+}
+
+func observeRequest(r *http.Request, route string, status int, start time.Time) {
+	if route == "" {
+		route = "other" // rejected before routing, or no route matched
+	}
+	method := metrics.Method(r.Method)
+	metrics.HTTPRequests.WithLabelValues(route, method, strconv.Itoa(status)).Inc()
+	metrics.HTTPDuration.WithLabelValues(route, method).Observe(time.Since(start).Seconds())
+}
+
+// countingWriter counts the body bytes written through it.
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.n += int64(n)
+	return n, err
+}
+
+func (w *countingWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// loopbackOnly is the metrics.allowIPs rule used when the list is empty.
+var loopbackOnly, _ = ipmatch.ParseList([]string{"loopback"})
+
+// HandleMetrics serves Prometheus metrics. It answers 404 when disabled and
+// applies metrics.allowIPs (loopback only when empty) and
+// metrics.requireToken on top of the global IP filter.
+func (h *Handler) HandleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	cfg := h.s.Config().Metrics
+	if cfg.Enabled != nil && !*cfg.Enabled {
+		http.NotFound(w, r)
+		return
+	}
+	allow := h.s.IPRules().MetricsAllow
+	if len(allow) == 0 {
+		allow = loopbackOnly
+	}
+	if !isIPAllowed(h.s.ClientIP(r), allow, nil) {
+		http.Error(w, "Access Denied", http.StatusForbidden)
+		return
+	}
+	if cfg.RequireToken && !hasValidToken(h.s, r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="msp"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	metrics.Handler().ServeHTTP(w, r)
+}
+
+// hasValidToken reports whether r carries an API token of any scope.
+func hasValidToken(s *server.Server, r *http.Request) bool {
+	secret, ok := bearerToken(r)
+	if !ok {
+		return false
+	}
+	_, ok = authenticateToken(s, r, secret)
+	return ok
+}
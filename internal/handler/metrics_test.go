@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"msp/internal/config"
+	"msp/internal/types"
+)
+
+func TestMetrics(t *testing.T) {
+	s := newAuthTestServer(t)
+	h := New(s)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/ip", h.HandleIP)
+	mux.HandleFunc("/metrics", h.HandleMetrics)
+	app := WithLog(s, WithSecurity(s, WithRoute(mux)))
+	get := func(target, remote, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.RemoteAddr = remote
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, req)
+		return w
+	}
+
+	get("/api/ip", "127.0.0.1:1234", "")
+	if w := get("/metrics", "192.168.1.20:1234", ""); w.Code != http.StatusForbidden {
+		t.Errorf("Expected LAN client to be rejected by default, got %d", w.Code)
+	}
+	_ = s.UpdateConfig(func(cfg *config.Config) { cfg.Metrics.AllowIPs = []string{} })
+	if w := get("/metrics", "192.168.1.20:1234", ""); w.Code != http.StatusForbidden {
+		t.Errorf("Expected an empty allowIPs to allow loopback only, got %d", w.Code)
+	}
+	w := get("/metrics", "127.0.0.1:1234", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("metrics: %d", w.Code)
+	}
+	for _, want := range []string{
+		`msp_http_requests_total{code="200",method="GET",route="/api/ip"}`,
+		"msp_db_query_duration_seconds_bucket",
+		"go_goroutines",
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("Expected %s in metrics output", want)
+		}
+	}
+
+	_ = s.UpdateConfig(func(cfg *config.Config) {
+		cfg.Metrics.AllowIPs = []string{"10.0.0.0/8"}
+		cfg.Metrics.RequireToken = true
+	})
+	if w := get("/metrics", "192.168.1.20:1234", ""); w.Code != http.StatusForbidden {
+		t.Errorf("Expected IP outside allowIPs to be rejected, got %d", w.Code)
+	}
+	if w := get("/metrics", "10.1.2.3:1234", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected missing token to be rejected, got %d", w.Code)
+	}
+
+	tw := httptest.NewRecorder()
+	h.HandleTokens(tw, withPrincipal(httptest.NewRequest(http.MethodPost, "/api/admin/tokens", bytes.NewBufferString(`{"op":"create","name":"prometheus","scope":"stream"}`)), principal{Role: RoleAdmin}))
+	var tok types.TokenCreatedResponse
+	_ = json.Unmarshal(tw.Body.Bytes(), &tok)
+	if w := get("/metrics", "10.1.2.3:1234", tok.Secret); w.Code != http.StatusOK {
+		t.Errorf("Expected token to be accepted, got %d", w.Code)
+	}
+
+	_ = s.UpdateConfig(func(cfg *config.Config) { cfg.Metrics.Enabled = new(bool) })
+	if w := get("/metrics", "10.1.2.3:1234", tok.Secret); w.Code != http.StatusNotFound {
+		t.Errorf("Expected disabled metrics to answer 404, got %d", w.Code)
+	}
+}
@@ -2,12 +2,17 @@ package handler
 
 import (
 	"compress/gzip"
-	"fmt"
+	"log/slog"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
+	"msp/internal/ipmatch"
+	"msp/internal/logging"
 	"msp/internal/server"
+	"msp/internal/types"
 )
 
 type gzipResponseWriter struct {
@@ -39,12 +44,27 @@ func WithGzip(next http.Handler) http.Handler {
 	})
 }
 
+// WithLog logs every request and records its metrics. Each request gets an
+// ID, taken from a valid X-Request-ID header or generated, which is echoed
+// in the response and added to every record logged with its context.
 func WithLog(s *server.Server, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
+		id := r.Header.Get("X-Request-ID")
+		if !logging.ValidRequestID(id) {
+			id = logging.NewRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		r = r.WithContext(logging.WithRequestID(r.Context(), id))
 		sw := &statusWriter{ResponseWriter: w}
+		r, route := withRouteSlot(r)
 		next.ServeHTTP(sw, r)
 		s.LogRequest(r, sw.status, start)
+		status := sw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		observeRequest(r, *route, status, start)
 	})
 }
 
@@ -71,87 +91,83 @@ func (w *statusWriter) Flush() {
 	}
 }
 
-// WithSecurity applies IP filtering and PIN authentication
+// WithSecurity applies IP filtering, Origin/CSRF checks and PIN authentication
 func WithSecurity(s *server.Server, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		cfg := s.Config()
 
-		// Get client IP
-		clientIP := getClientIP(r)
+		// Get client IP (forwarded headers only count from trusted proxies)
+		clientIP := s.ClientIP(r)
 
 		// Check IP whitelist/blacklist
-		if !isIPAllowed(clientIP, cfg.Security.IPWhitelist, cfg.Security.IPBlacklist) {
-			s.Log("info", fmt.Sprintf("Access denied for IP: %s", clientIP))
+		rules := s.IPRules()
+		if !isIPAllowed(clientIP, rules.Whitelist, rules.Blacklist) {
+			slog.InfoContext(r.Context(), "Access denied", "ip", clientIP, "path", r.URL.Path)
+			s.AuditIPDenied(clientIP, r.URL.Path)
 			http.Error(w, "Access Denied", http.StatusForbidden)
 			return
 		}
 
-		// Check PIN authentication
-		if cfg.Security.PINEnabled {
-			// Skip PIN check for certain endpoints
-			if !requiresPIN(r.URL.Path) {
-				next.ServeHTTP(w, r)
+		// Reject cross-site state changes and answer CORS preflights
+		if strings.HasPrefix(r.URL.Path, "/api/") && !checkOrigin(s, w, r) {
+			return
+		}
+
+		// Throttle PIN attempts (login endpoint or X-PIN header)
+		if isPINAttempt(r) {
+			if wait, ok := s.CheckLogin(clientIP); !ok {
+				writeLockedOut(w, wait)
 				return
 			}
+		}
 
-			// Check PIN from header or cookie
-			pin := r.Header.Get("X-PIN")
-			if pin == "" {
-				cookie, err := r.Cookie("msp_pin")
-				if err == nil {
-					pin = cookie.Value
-				}
-			}
+		// Skip role checks for static resources and the login endpoint
+		if !requiresPIN(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
 
-			if pin != cfg.Security.PIN {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		// Resolve the principal from API token, session cookie, localhost mode or X-PIN header
+		p, ok := authenticate(s, w, r, cfg.Security)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if p.Token != nil && !tokenAllows(p.Token.Scope, r) {
+			writeJSON(w, http.StatusForbidden, map[string]any{"error": types.ApiError{Message: "令牌权限不足"}})
+			return
+		}
+		if p.Session != nil {
+			ensureCSRFToken(r, p.Session)
+			if isMutating(r) && !validCSRF(r, p.Session) {
+				writeJSON(w, http.StatusForbidden, map[string]any{"error": types.ApiError{Message: "CSRF 校验失败"}})
 				return
 			}
+			setCSRFCookie(w, r, p.Session)
 		}
-
-		next.ServeHTTP(w, r)
-	})
-}
-
-// getClientIP extracts the real client IP from the request
-func getClientIP(r *http.Request) string {
-	// Check X-Forwarded-For header
-	xff := r.Header.Get("X-Forwarded-For")
-	if xff != "" {
-		ips := strings.Split(xff, ",")
-		if len(ips) > 0 {
-			return strings.TrimSpace(ips[0])
+		if requiresAdmin(r) && p.Role != RoleAdmin {
+			writeJSON(w, http.StatusForbidden, map[string]any{"error": types.ApiError{Message: "需要管理员权限"}})
+			return
 		}
-	}
-
-	// Check X-Real-IP header
-	xri := r.Header.Get("X-Real-IP")
-	if xri != "" {
-		return strings.TrimSpace(xri)
-	}
 
-	// Use RemoteAddr
-	ip := r.RemoteAddr
-	if idx := strings.LastIndex(ip, ":"); idx != -1 {
-		ip = ip[:idx]
-	}
-	// Remove brackets for IPv6
-	ip = strings.Trim(ip, "[]")
-	return ip
+		next.ServeHTTP(w, withPrincipal(r, p))
+	})
 }
 
 // isIPAllowed checks if an IP is allowed based on whitelist and blacklist
-func isIPAllowed(clientIP string, whitelist, blacklist []string) bool {
+func isIPAllowed(clientIP string, whitelist, blacklist ipmatch.List) bool {
+	addr, ok := ipmatch.ParseAddr(clientIP)
+
 	// If whitelist is not empty, IP must be in whitelist
 	if len(whitelist) > 0 {
-		if !matchesIPList(clientIP, whitelist) {
+		if !ok || !whitelist.Contains(addr) {
 			return false
 		}
 	}
 
-	// If IP is in blacklist, deny access
+	// If IP is in blacklist (or cannot be parsed while a blacklist is set), deny access
 	if len(blacklist) > 0 {
-		if matchesIPList(clientIP, blacklist) {
+		if !ok || blacklist.Contains(addr) {
 			return false
 		}
 	}
@@ -159,74 +175,42 @@ func isIPAllowed(clientIP string, whitelist, blacklist []string) bool {
 	return true
 }
 
-// matchesIPList checks if an IP matches any entry in the list
-// Supports both exact IP match and CIDR notation
-func matchesIPList(clientIP string, ipList []string) bool {
-	for _, entry := range ipList {
-		entry = strings.TrimSpace(entry)
-		if entry == "" {
-			continue
-		}
-
-		// Check for CIDR notation
-		if strings.Contains(entry, "/") {
-			if matchesCIDR(clientIP, entry) {
-				return true
-			}
-		} else {
-			// Exact IP match
-			if clientIP == entry {
-				return true
-			}
-		}
+// isPINAttempt reports whether the request tries a PIN or API token and is
+// subject to brute-force limits.
+func isPINAttempt(r *http.Request) bool {
+	if r.Header.Get("X-PIN") != "" {
+		return true
 	}
-	return false
-}
-
-// matchesCIDR checks if an IP matches a CIDR range
-func matchesCIDR(clientIP, cidr string) bool {
-	// Simple CIDR matching - parse IP and network
-	// For production use, consider using net.ParseCIDR
-	parts := strings.Split(cidr, "/")
-	if len(parts) != 2 {
-		return false
+	if _, ok := bearerToken(r); ok {
+		return true
 	}
-
-	networkIP := parts[0]
-	// For simplicity, we'll do prefix matching for common cases
-	// A full implementation would use proper CIDR calculation
-
-	// Handle /24 (most common case)
-	if parts[1] == "24" {
-		clientParts := strings.Split(clientIP, ".")
-		networkParts := strings.Split(networkIP, ".")
-		if len(clientParts) == 4 && len(networkParts) == 4 {
-			return clientParts[0] == networkParts[0] &&
-				clientParts[1] == networkParts[1] &&
-				clientParts[2] == networkParts[2]
-		}
+	switch r.URL.Path {
+	case "/api/pin", "/api/pin/change", "/api/login":
+		return r.Method == http.MethodPost
 	}
+	return false
+}
 
-	// Handle /16
-	if parts[1] == "16" {
-		clientParts := strings.Split(clientIP, ".")
-		networkParts := strings.Split(networkIP, ".")
-		if len(clientParts) == 4 && len(networkParts) == 4 {
-			return clientParts[0] == networkParts[0] &&
-				clientParts[1] == networkParts[1]
-		}
-	}
+func writeLockedOut(w http.ResponseWriter, wait time.Duration) {
+	secs := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	writeJSON(w, http.StatusTooManyRequests, map[string]any{
+		"valid":      false,
+		"error":      "尝试次数过多，请稍后再试",
+		"retryAfter": secs,
+	})
+}
 
-	// Handle /8
-	if parts[1] == "8" {
-		clientParts := strings.Split(clientIP, ".")
-		networkParts := strings.Split(networkIP, ".")
-		if len(clientParts) == 4 && len(networkParts) == 4 {
-			return clientParts[0] == networkParts[0]
-		}
+// requiresAdmin reports whether the route needs the admin role: changing
+// the config, managing shares, sessions, PINs and lockouts, and the log.
+func requiresAdmin(r *http.Request) bool {
+	switch r.URL.Path {
+	case "/api/config":
+		return r.Method != http.MethodGet
+	case "/api/shares", "/api/sessions", "/api/pin/change", "/api/log", "/api/status":
+		return true
 	}
-
-	return false
+	return strings.HasPrefix(r.URL.Path, "/api/admin/")
 }
 
 // requiresPIN determines if a path requires PIN authentication
@@ -237,7 +221,8 @@ func requiresPIN(path string) bool {
 
 	// Exempt paths that never require PIN
 	exemptPaths := []string{
-		"/api/pin", // PIN verification endpoint itself
+		"/api/pin",   // PIN verification endpoint itself
+		"/api/login", // user login
 	}
 
 	for _, exempt := range exemptPaths {
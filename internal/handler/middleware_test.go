@@ -7,6 +7,8 @@ import (
 	"testing"
 
 	"msp/internal/config"
+	"msp/internal/ipmatch"
+	"msp/internal/logging"
 	"msp/internal/server"
 )
 
@@ -78,7 +80,9 @@ func TestIPFiltering(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := isIPAllowed(tt.clientIP, tt.whitelist, tt.blacklist)
+			whitelist, _ := ipmatch.ParseList(tt.whitelist)
+			blacklist, _ := ipmatch.ParseList(tt.blacklist)
+			result := isIPAllowed(tt.clientIP, whitelist, blacklist)
 			if result != tt.shouldAllow {
 				t.Errorf("isIPAllowed() = %v, want %v", result, tt.shouldAllow)
 			}
@@ -129,68 +133,89 @@ func TestMatchesCIDR(t *testing.T) {
 			cidr:     "10.0.0.0/8",
 			want:     false,
 		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			result := matchesCIDR(tt.clientIP, tt.cidr)
-			if result != tt.want {
-				t.Errorf("matchesCIDR(%s, %s) = %v, want %v", tt.clientIP, tt.cidr, result, tt.want)
-			}
-		})
-	}
-}
-
-func TestGetClientIP(t *testing.T) {
-	tests := []struct {
-		name       string
-		remoteAddr string
-		headers    map[string]string
-		want       string
-	}{
 		{
-			name:       "X-Forwarded-For single IP",
-			remoteAddr: "10.0.0.1:1234",
-			headers:    map[string]string{"X-Forwarded-For": "192.168.1.100"},
-			want:       "192.168.1.100",
+			name:     "/25 match",
+			clientIP: "192.168.1.127",
+			cidr:     "192.168.1.0/25",
+			want:     true,
 		},
 		{
-			name:       "X-Forwarded-For multiple IPs",
-			remoteAddr: "10.0.0.1:1234",
-			headers:    map[string]string{"X-Forwarded-For": "192.168.1.100, 10.0.0.1"},
-			want:       "192.168.1.100",
+			name:     "/25 no match",
+			clientIP: "192.168.1.128",
+			cidr:     "192.168.1.0/25",
+			want:     false,
+		},
+		{
+			name:     "/12 match",
+			clientIP: "10.15.255.1",
+			cidr:     "10.0.0.0/12",
+			want:     true,
+		},
+		{
+			name:     "/12 no match",
+			clientIP: "10.16.0.1",
+			cidr:     "10.0.0.0/12",
+			want:     false,
 		},
 		{
-			name:       "X-Real-IP",
-			remoteAddr: "10.0.0.1:1234",
-			headers:    map[string]string{"X-Real-IP": "192.168.1.100"},
-			want:       "192.168.1.100",
+			name:     "IPv6 match",
+			clientIP: "2001:db8::42",
+			cidr:     "2001:db8::/32",
+			want:     true,
 		},
 		{
-			name:       "RemoteAddr only",
-			remoteAddr: "192.168.1.100:5678",
-			headers:    map[string]string{},
-			want:       "192.168.1.100",
+			name:     "IPv6 no match",
+			clientIP: "2001:db9::42",
+			cidr:     "2001:db8::/32",
+			want:     false,
 		},
 		{
-			name:       "IPv6 RemoteAddr",
-			remoteAddr: "[::1]:5678",
-			headers:    map[string]string{},
-			want:       "::1",
+			name:     "IPv4-mapped client",
+			clientIP: "::ffff:192.168.1.5",
+			cidr:     "192.168.1.0/24",
+			want:     true,
+		},
+		{
+			name:     "IPv4-mapped range",
+			clientIP: "192.168.1.5",
+			cidr:     "::ffff:192.168.1.0/120",
+			want:     true,
+		},
+		{
+			name:     "named private",
+			clientIP: "172.20.1.1",
+			cidr:     "private",
+			want:     true,
+		},
+		{
+			name:     "named private IPv6",
+			clientIP: "fd12::1",
+			cidr:     "private",
+			want:     true,
+		},
+		{
+			name:     "named private public IP",
+			clientIP: "8.8.8.8",
+			cidr:     "private",
+			want:     false,
+		},
+		{
+			name:     "named loopback",
+			clientIP: "::1",
+			cidr:     "loopback",
+			want:     true,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			req := httptest.NewRequest(http.MethodGet, "/", nil)
-			req.RemoteAddr = tt.remoteAddr
-			for k, v := range tt.headers {
-				req.Header.Set(k, v)
+			addr, ok := ipmatch.ParseAddr(tt.clientIP)
+			if !ok {
+				t.Fatalf("ParseAddr(%s) failed", tt.clientIP)
 			}
-
-			result := getClientIP(req)
-			if result != tt.want {
-				t.Errorf("getClientIP() = %v, want %v", result, tt.want)
+			rules, _ := ipmatch.ParseList([]string{tt.cidr})
+			if result := rules.Contains(addr); result != tt.want {
+				t.Errorf("Contains(%s, %s) = %v, want %v", tt.clientIP, tt.cidr, result, tt.want)
 			}
 		})
 	}
@@ -296,3 +321,25 @@ func TestWithSecurityMiddleware(t *testing.T) {
 		})
 	}
 }
+
+func TestWithLogRequestID(t *testing.T) {
+	s := server.New(filepath.Join(t.TempDir(), "config.json"))
+	var seen string
+	app := WithLog(s, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = logging.RequestID(r.Context())
+	}))
+
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/ip", nil))
+	if id := w.Header().Get("X-Request-ID"); id == "" || id != seen {
+		t.Errorf("Expected generated request ID in header and context, got %q and %q", id, seen)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/ip", nil)
+	req.Header.Set("X-Request-ID", "proxy-42")
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, req)
+	if w.Header().Get("X-Request-ID") != "proxy-42" || seen != "proxy-42" {
+		t.Errorf("Expected proxy request ID to be kept, got %q", seen)
+	}
+}
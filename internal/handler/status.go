@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"msp/internal/db"
+	"msp/internal/media"
+	"msp/internal/types"
+	"msp/internal/util"
+)
+
+// HandleHealthz reports that the process is up (liveness). It needs no PIN.
+func (h *Handler) HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// HandleReadyz reports whether the server can serve media (readiness): the
+// database answers and a media index is loaded. Otherwise it responds 503
+// and names the failing checks. It needs no PIN.
+func (h *Handler) HandleReadyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	checks := map[string]string{"database": "ok", "mediaIndex": "ok"}
+	ready := true
+	if err := db.Ping(ctx); err != nil {
+		checks["database"] = err.Error()
+		ready = false
+	}
+	if !h.s.ScanStatus().Ready {
+		checks["mediaIndex"] = "first scan not finished"
+		ready = false
+	}
+	status := http.StatusOK
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, status, map[string]any{"ready": ready, "checks": checks})
+}
+
+// HandleStatus reports version, uptime, index and transcoder state, media
+// tools and free disk space per share.
+func (h *Handler) HandleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	scan := h.s.ScanStatus()
+	resp := types.StatusResponse{
+		Version:          h.s.Version(),
+		UptimeSeconds:    int64(h.s.Uptime().Seconds()),
+		DBSize:           db.Size(),
+		Items:            scan.Items,
+		Scanning:         scan.Scanning,
+		ActiveTranscodes: media.ActiveTranscodes(),
+		FFmpeg:           toolStatus(r.Context(), "ffmpeg"),
+		FFprobe:          toolStatus(r.Context(), "ffprobe"),
+		Shares:           []types.ShareStatus{},
+	}
+	if !scan.LastScan.IsZero() {
+		resp.LastScanAt = &scan.LastScan
+		resp.LastScanSeconds = scan.LastDuration.Seconds()
+	}
+	for _, sh := range h.s.Config().Shares {
+		st := types.ShareStatus{Label: sh.Label, Path: sh.Path}
+		if free, err := util.DiskFree(sh.Path); err == nil {
+			st.FreeBytes = &free
+		}
+		resp.Shares = append(resp.Shares, st)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func toolStatus(ctx context.Context, name string) types.ToolStatus {
+	v, ok := media.ToolVersion(ctx, name)
+	return types.ToolStatus{Available: ok, Version: v}
+}
@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"msp/internal/config"
+	"msp/internal/types"
+)
+
+func TestHealthAndStatus(t *testing.T) {
+	s := newAuthTestServer(t)
+	mediaDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(mediaDir, "clip.mp4"), []byte("x"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_ = s.UpdateConfig(func(cfg *config.Config) {
+		cfg.Shares = []config.Share{{Label: "Media", Path: mediaDir}}
+	})
+	mux := http.NewServeMux()
+	h := New(s)
+	mux.HandleFunc("/healthz", h.HandleHealthz)
+	mux.HandleFunc("/readyz", h.HandleReadyz)
+	mux.HandleFunc("/api/status", h.HandleStatus)
+	srv := WithSecurity(s, mux)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	if w := get("/healthz"); w.Code != http.StatusOK {
+		t.Errorf("healthz without PIN: %d", w.Code)
+	}
+	if w := get("/readyz"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("readyz before first scan: %d %s", w.Code, w.Body.String())
+	}
+	if w := get("/api/status"); w.Code != http.StatusUnauthorized {
+		t.Errorf("status without PIN: %d", w.Code)
+	}
+
+	s.RebuildMediaCache(context.Background())
+	if w := get("/readyz"); w.Code != http.StatusOK {
+		t.Errorf("readyz after scan: %d %s", w.Code, w.Body.String())
+	}
+
+	w := httptest.NewRecorder()
+	h.HandleStatus(w, withPrincipal(httptest.NewRequest(http.MethodGet, "/api/status", nil), principal{Role: RoleAdmin}))
+	var resp types.StatusResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("status: %d %s", w.Code, w.Body.String())
+	}
+	if resp.Items.Videos != 1 || resp.LastScanAt == nil || resp.Scanning || resp.DBSize == 0 {
+		t.Errorf("Unexpected status %+v", resp)
+	}
+	if len(resp.Shares) != 1 || resp.Shares[0].FreeBytes == nil {
+		t.Errorf("Expected free space of the share, got %+v", resp.Shares)
+	}
+}
@@ -0,0 +1,156 @@
+package handler
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"msp/internal/db"
+	"msp/internal/server"
+	"msp/internal/types"
+	"msp/internal/util"
+)
+
+// apiTokenPrefix marks MSP API token secrets so they are easy to recognise
+// (and to find with secret scanners).
+const apiTokenPrefix = "msp_"
+
+// bearerToken returns the token of an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// authenticateToken resolves an API token. Unknown tokens count as failed
+// admin login attempts; a valid token does not reset the counter.
+func authenticateToken(s *server.Server, r *http.Request, secret string) (principal, bool) {
+	ip := s.ClientIP(r)
+	tok, ok, err := db.GetAPITokenByHash(r.Context(), util.HashToken(secret))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "GetAPITokenByHash failed", "err", err)
+		return principal{}, false
+	}
+	if !ok {
+		s.RecordLoginFailure(ip, RoleAdmin, "")
+		return principal{}, false
+	}
+
+	if now := time.Now(); tok.LastUsedAt == nil || now.Sub(*tok.LastUsedAt) >= sessionTouchInterval {
+		_ = db.TouchAPIToken(r.Context(), tok.ID, now)
+		tok.LastUsedAt = &now
+	}
+	role := RoleViewer
+	if tok.Scope == types.TokenScopeAdmin {
+		role = RoleAdmin
+	}
+	return principal{Role: role, Token: &tok}, true
+}
+
+// tokenAllows reports whether a token with scope may make request r.
+func tokenAllows(scope string, r *http.Request) bool {
+	switch scope {
+	case types.TokenScopeAdmin:
+		return true
+	case types.TokenScopeRead:
+		return !isMutating(r)
+	case types.TokenScopeStream:
+		return !isMutating(r) && (r.URL.Path == "/api/stream" || r.URL.Path == "/api/subtitle")
+	}
+	return false
+}
+
+// HandleTokens lists (GET), creates and revokes (POST) API tokens.
+func (h *Handler) HandleTokens(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.writeTokens(w, r)
+	case http.MethodPost:
+		var req types.TokensOpRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, types.TokensResponse{Error: &types.ApiError{Message: "JSON 解析失败"}})
+			return
+		}
+		switch strings.ToLower(strings.TrimSpace(req.Op)) {
+		case "create":
+			h.createToken(w, r, req)
+		case "revoke":
+			ok, err := db.DeleteAPIToken(r.Context(), req.ID)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "DeleteAPIToken failed", "err", err)
+				writeJSON(w, http.StatusInternalServerError, types.TokensResponse{Error: &types.ApiError{Message: "撤销令牌失败"}})
+				return
+			}
+			if !ok {
+				writeJSON(w, http.StatusNotFound, types.TokensResponse{Error: &types.ApiError{Message: "令牌不存在"}})
+				return
+			}
+			h.audit(r, types.AuditEvent{Type: types.AuditTokenRevoke, Detail: "id=" + req.ID})
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			writeJSON(w, http.StatusBadRequest, types.TokensResponse{Error: &types.ApiError{Message: "不支持的 op（create/revoke）"}})
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) writeTokens(w http.ResponseWriter, r *http.Request) {
+	tokens, err := db.ListAPITokens(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "ListAPITokens failed", "err", err)
+		writeJSON(w, http.StatusInternalServerError, types.TokensResponse{Error: &types.ApiError{Message: "读取令牌失败"}})
+		return
+	}
+	writeJSON(w, http.StatusOK, types.TokensResponse{Tokens: tokens})
+}
+
+func (h *Handler) createToken(w http.ResponseWriter, r *http.Request, req types.TokensOpRequest) {
+	name := strings.TrimSpace(req.Name)
+	scope := strings.ToLower(strings.TrimSpace(req.Scope))
+	if name == "" {
+		writeJSON(w, http.StatusBadRequest, types.TokensResponse{Error: &types.ApiError{Message: "缺少 name"}})
+		return
+	}
+	if scope != types.TokenScopeRead && scope != types.TokenScopeStream && scope != types.TokenScopeAdmin {
+		writeJSON(w, http.StatusBadRequest, types.TokensResponse{Error: &types.ApiError{Message: "不支持的 scope（read/stream/admin）"}})
+		return
+	}
+
+	secret, err := util.NewToken(32)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.TokensResponse{Error: &types.ApiError{Message: "创建令牌失败"}})
+		return
+	}
+	secret = apiTokenPrefix + secret
+	id, err := util.NewToken(9)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.TokensResponse{Error: &types.ApiError{Message: "创建令牌失败"}})
+		return
+	}
+	now := time.Now()
+	tok := types.APIToken{
+		ID:        id,
+		Name:      name,
+		Scope:     scope,
+		TokenHash: util.HashToken(secret),
+		CreatedBy: principalOf(r).UserID(),
+		CreatedAt: now,
+	}
+	if req.ExpiresDays > 0 {
+		exp := now.AddDate(0, 0, req.ExpiresDays)
+		tok.ExpiresAt = &exp
+	}
+	if err := db.CreateAPIToken(r.Context(), &tok); err != nil {
+		slog.ErrorContext(r.Context(), "CreateAPIToken failed", "err", err)
+		writeJSON(w, http.StatusInternalServerError, types.TokensResponse{Error: &types.ApiError{Message: "创建令牌失败"}})
+		return
+	}
+	h.audit(r, types.AuditEvent{Type: types.AuditTokenCreate, Detail: "id=" + id + " name=" + name + " scope=" + scope})
+	writeJSON(w, http.StatusOK, types.TokenCreatedResponse{Token: tok, Secret: secret})
+}
@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"msp/internal/db"
+	"msp/internal/types"
+)
+
+func TestAPITokens(t *testing.T) {
+	s := newAuthTestServer(t)
+	h := New(s)
+	admin := principal{Role: RoleAdmin}
+
+	create := func(body string) types.TokenCreatedResponse {
+		w := httptest.NewRecorder()
+		h.HandleTokens(w, withPrincipal(httptest.NewRequest(http.MethodPost, "/api/admin/tokens", bytes.NewBufferString(body)), admin))
+		if w.Code != http.StatusOK {
+			t.Fatalf("create token: %d %s", w.Code, w.Body.String())
+		}
+		var resp types.TokenCreatedResponse
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp
+	}
+	read := create(`{"op":"create","name":"home assistant","scope":"read"}`)
+	stream := create(`{"op":"create","name":"speaker","scope":"stream"}`)
+	full := create(`{"op":"create","name":"backup","scope":"admin"}`)
+	if !strings.HasPrefix(read.Secret, apiTokenPrefix) || strings.Contains(mustJSON(t, read.Token), read.Secret) {
+		t.Fatalf("Unexpected token response: %+v", read)
+	}
+
+	app := WithSecurity(s, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	do := func(secret, method, target string) int {
+		req := httptest.NewRequest(method, target, nil)
+		if secret != "" {
+			req.Header.Set("Authorization", "Bearer "+secret)
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, req)
+		return w.Code
+	}
+	for _, c := range []struct {
+		secret, method, target string
+		want                   int
+	}{
+		{read.Secret, http.MethodGet, "/api/media", http.StatusOK},
+		{read.Secret, http.MethodPost, "/api/prefs", http.StatusForbidden},
+		{read.Secret, http.MethodGet, "/api/admin/users", http.StatusForbidden},
+		{stream.Secret, http.MethodGet, "/api/stream?id=x", http.StatusOK},
+		{stream.Secret, http.MethodGet, "/api/media", http.StatusForbidden},
+		{full.Secret, http.MethodPost, "/api/config", http.StatusOK},
+	} {
+		if got := do(c.secret, c.method, c.target); got != c.want {
+			t.Errorf("%s %s with %q: got %d, want %d", c.method, c.target, c.secret[:8], got, c.want)
+		}
+	}
+
+	// Last use is recorded; revoked tokens stop working
+	tokens, _ := db.ListAPITokens(t.Context())
+	for _, tok := range tokens {
+		if tok.ID == read.Token.ID && tok.LastUsedAt == nil {
+			t.Error("Expected last-used timestamp to be set")
+		}
+	}
+	w := httptest.NewRecorder()
+	h.HandleTokens(w, withPrincipal(httptest.NewRequest(http.MethodPost, "/api/admin/tokens", bytes.NewBufferString(`{"op":"revoke","id":"`+read.Token.ID+`"}`)), admin))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("revoke: %d", w.Code)
+	}
+	if got := do(read.Secret, http.MethodGet, "/api/media"); got != http.StatusUnauthorized {
+		t.Errorf("Expected revoked token to be rejected, got %d", got)
+	}
+}
+
+func mustJSON(t *testing.T, v any) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
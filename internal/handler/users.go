@@ -0,0 +1,310 @@
+package handler
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+
+	"msp/internal/db"
+	"msp/internal/pinhash"
+	"msp/internal/types"
+)
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+// verifyUnknownUser spends the same time as a real password check so login
+// responses do not reveal which user names exist.
+func verifyUnknownUser(password string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = pinhash.Hash("msp-dummy-password")
+	})
+	_ = pinhash.Verify(password, dummyHash)
+}
+
+// HandleLogin signs a user in with name and password and issues a session.
+func (h *Handler) HandleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req types.LoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"valid": false, "error": "JSON 解析失败"})
+		return
+	}
+
+	clientIP := h.s.ClientIP(r)
+	u, ok, err := db.GetUserByName(r.Context(), strings.TrimSpace(req.Name))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "GetUserByName failed", "err", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"valid": false, "error": "读取用户失败"})
+		return
+	}
+	if !ok {
+		verifyUnknownUser(req.Password)
+	}
+	role := RoleViewer
+	if ok && u.Role == RoleAdmin {
+		role = RoleAdmin
+	}
+	if !ok || !pinhash.Verify(req.Password, u.PasswordHash) {
+		h.s.RecordLoginFailure(clientIP, role, strings.TrimSpace(req.Name))
+		writeJSON(w, http.StatusOK, map[string]any{"valid": false})
+		return
+	}
+	h.s.RecordLoginSuccess(clientIP, role)
+	if err := issueSession(w, r, h.s, strings.TrimSpace(req.Label), role, u.ID); err != nil {
+		slog.ErrorContext(r.Context(), "issueSession failed", "err", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"valid": false, "error": "创建会话失败"})
+		return
+	}
+	h.audit(r, types.AuditEvent{Type: types.AuditLogin, Actor: u.Name, Detail: "role=" + role})
+	writeJSON(w, http.StatusOK, map[string]any{"valid": true, "role": role, "user": u})
+}
+
+// HandleLogout revokes the current session.
+func (h *Handler) HandleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if sess, ok := currentSession(r); ok {
+		if _, err := db.DeleteSession(r.Context(), sess.ID); err != nil {
+			slog.ErrorContext(r.Context(), "DeleteSession failed", "err", err)
+		}
+		h.audit(r, types.AuditEvent{Type: types.AuditLogout, Detail: "session=" + sess.ID})
+	}
+	http.SetCookie(w, &http.Cookie{Name: SessionCookie, Value: "", Path: "/", MaxAge: -1})
+	http.SetCookie(w, &http.Cookie{Name: CSRFCookie, Value: "", Path: "/", MaxAge: -1})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleMe returns the role and user of the current request.
+func (h *Handler) HandleMe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	p := principalOf(r)
+	writeJSON(w, http.StatusOK, types.MeResponse{Role: p.Role, User: p.User})
+}
+
+// HandleUsers lists (GET) and manages (POST) user accounts.
+func (h *Handler) HandleUsers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.writeUsers(w, r)
+	case http.MethodPost:
+		var req types.UsersOpRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, types.UsersResponse{Error: &types.ApiError{Message: "JSON 解析失败"}})
+			return
+		}
+		status, msg := h.applyUsersOp(r, req)
+		if msg != "" {
+			writeJSON(w, status, types.UsersResponse{Error: &types.ApiError{Message: msg}})
+			return
+		}
+		h.writeUsers(w, r)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) writeUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := db.ListUsers(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "ListUsers failed", "err", err)
+		writeJSON(w, http.StatusInternalServerError, types.UsersResponse{Error: &types.ApiError{Message: "读取用户失败"}})
+		return
+	}
+	writeJSON(w, http.StatusOK, types.UsersResponse{Users: users})
+}
+
+// applyUsersOp runs a user management op and returns an HTTP status and
+// error message; msg is empty on success.
+func (h *Handler) applyUsersOp(r *http.Request, req types.UsersOpRequest) (status int, msg string) {
+	ctx := r.Context()
+	name := strings.TrimSpace(req.Name)
+	role := strings.ToLower(strings.TrimSpace(req.Role))
+	if role != "" && role != RoleViewer && role != RoleAdmin {
+		return http.StatusBadRequest, "不支持的 role（viewer/admin）"
+	}
+
+	switch strings.ToLower(strings.TrimSpace(req.Op)) {
+	case "create":
+		if name == "" || req.Password == "" {
+			return http.StatusBadRequest, "缺少 name 或 password"
+		}
+		if _, exists, err := db.GetUserByName(ctx, name); err != nil {
+			return http.StatusInternalServerError, "读取用户失败"
+		} else if exists {
+			return http.StatusBadRequest, "用户名已存在"
+		}
+		hash, err := pinhash.Hash(req.Password)
+		if err != nil {
+			return http.StatusInternalServerError, "创建用户失败"
+		}
+		if role == "" {
+			role = RoleViewer
+		}
+		u := types.User{Name: name, PasswordHash: hash, Role: role, Groups: normalizeGroups(req.Groups)}
+		if err := db.CreateUser(ctx, &u); err != nil {
+			slog.ErrorContext(r.Context(), "CreateUser failed", "err", err)
+			return http.StatusInternalServerError, "创建用户失败"
+		}
+		slog.InfoContext(r.Context(), "User created", "name", name)
+
+	case "update":
+		u, ok, err := db.GetUser(ctx, req.ID)
+		if err != nil {
+			return http.StatusInternalServerError, "读取用户失败"
+		}
+		if !ok {
+			return http.StatusNotFound, "用户不存在"
+		}
+		if name != "" && !strings.EqualFold(name, u.Name) {
+			if _, exists, err := db.GetUserByName(ctx, name); err != nil {
+				return http.StatusInternalServerError, "读取用户失败"
+			} else if exists {
+				return http.StatusBadRequest, "用户名已存在"
+			}
+			u.Name = name
+		}
+		if role != "" {
+			u.Role = role
+		}
+		if req.Groups != nil {
+			u.Groups = normalizeGroups(req.Groups)
+		}
+		if req.Password != "" {
+			hash, err := pinhash.Hash(req.Password)
+			if err != nil {
+				return http.StatusInternalServerError, "更新用户失败"
+			}
+			u.PasswordHash = hash
+		}
+		if err := db.UpdateUser(ctx, &u); err != nil {
+			slog.ErrorContext(r.Context(), "UpdateUser failed", "err", err)
+			return http.StatusInternalServerError, "更新用户失败"
+		}
+		// A new password signs the user out everywhere
+		if req.Password != "" {
+			if err := db.DeleteUserSessions(ctx, u.ID); err != nil {
+				slog.ErrorContext(r.Context(), "DeleteUserSessions failed", "err", err)
+			}
+		}
+		slog.InfoContext(r.Context(), "User updated", "id", u.ID)
+
+	case "delete":
+		ok, err := db.DeleteUser(ctx, req.ID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "DeleteUser failed", "err", err)
+			return http.StatusInternalServerError, "删除用户失败"
+		}
+		if !ok {
+			return http.StatusNotFound, "用户不存在"
+		}
+		slog.InfoContext(r.Context(), "User deleted", "id", req.ID)
+
+	default:
+		return http.StatusBadRequest, "不支持的 op（create/update/delete）"
+	}
+	return http.StatusOK, ""
+}
+
+func normalizeGroups(in []string) []string {
+	out := make([]string, 0, len(in))
+	seen := map[string]bool{}
+	for _, g := range in {
+		g = strings.TrimSpace(g)
+		if g == "" || seen[strings.ToLower(g)] {
+			continue
+		}
+		seen[strings.ToLower(g)] = true
+		out = append(out, g)
+	}
+	return out
+}
+
+// HandleFavorites lists (GET) and edits (POST) the current user's favorites.
+func (h *Handler) HandleFavorites(w http.ResponseWriter, r *http.Request) {
+	uid := principalOf(r).UserID()
+	switch r.Method {
+	case http.MethodGet:
+		favs, err := db.ListFavorites(r.Context(), uid)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "ListFavorites failed", "err", err)
+			writeJSON(w, http.StatusInternalServerError, types.FavoritesResponse{Error: &types.ApiError{Message: "读取收藏失败"}})
+			return
+		}
+		writeJSON(w, http.StatusOK, types.FavoritesResponse{Favorites: favs})
+	case http.MethodPost:
+		var req types.FavoritesOpRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, types.FavoritesResponse{Error: &types.ApiError{Message: "JSON 解析失败"}})
+			return
+		}
+		if req.ID == "" {
+			writeJSON(w, http.StatusBadRequest, types.FavoritesResponse{Error: &types.ApiError{Message: "缺少 id"}})
+			return
+		}
+		var err error
+		switch strings.ToLower(strings.TrimSpace(req.Op)) {
+		case "add":
+			err = db.AddFavorite(r.Context(), uid, req.ID)
+		case "remove":
+			err = db.RemoveFavorite(r.Context(), uid, req.ID)
+		default:
+			writeJSON(w, http.StatusBadRequest, types.FavoritesResponse{Error: &types.ApiError{Message: "不支持的 op（add/remove）"}})
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Favorites update failed", "err", err)
+			writeJSON(w, http.StatusInternalServerError, types.FavoritesResponse{Error: &types.ApiError{Message: "写入收藏失败"}})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleHistory lists (GET) and clears (POST) the current user's play history.
+func (h *Handler) HandleHistory(w http.ResponseWriter, r *http.Request) {
+	uid := principalOf(r).UserID()
+	switch r.Method {
+	case http.MethodGet:
+		list, err := db.ListHistory(r.Context(), uid, parseLimitParam(r))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "ListHistory failed", "err", err)
+			writeJSON(w, http.StatusInternalServerError, types.HistoryResponse{Error: &types.ApiError{Message: "读取历史失败"}})
+			return
+		}
+		writeJSON(w, http.StatusOK, types.HistoryResponse{History: list})
+	case http.MethodPost:
+		var req types.HistoryOpRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, types.HistoryResponse{Error: &types.ApiError{Message: "JSON 解析失败"}})
+			return
+		}
+		if strings.ToLower(strings.TrimSpace(req.Op)) != "clear" {
+			writeJSON(w, http.StatusBadRequest, types.HistoryResponse{Error: &types.ApiError{Message: "不支持的 op（clear）"}})
+			return
+		}
+		if err := db.ClearHistory(r.Context(), uid); err != nil {
+			slog.ErrorContext(r.Context(), "ClearHistory failed", "err", err)
+			writeJSON(w, http.StatusInternalServerError, types.HistoryResponse{Error: &types.ApiError{Message: "清除历史失败"}})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"msp/internal/config"
+	"msp/internal/types"
+	"msp/internal/util"
+)
+
+func TestUserAccounts(t *testing.T) {
+	s := newAuthTestServer(t)
+	h := New(s)
+
+	// Two shares: one public, one restricted to the "kids" group
+	root := t.TempDir()
+	pub := filepath.Join(root, "public")
+	kids := filepath.Join(root, "kids")
+	for _, d := range []string{pub, kids} {
+		if err := os.MkdirAll(d, 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(d, "a.mp4"), []byte("x"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = s.UpdateConfig(func(cfg *config.Config) {
+		cfg.Shares = []config.Share{
+			{Label: "Public", Path: pub},
+			{Label: "Kids", Path: kids, AllowGroups: []string{"kids"}},
+		}
+	})
+
+	// Admin creates two users via the API
+	admin := principal{Role: RoleAdmin}
+	for _, body := range []string{
+		`{"op":"create","name":"alice","password":"pw-a","groups":["kids"]}`,
+		`{"op":"create","name":"bob","password":"pw-b"}`,
+	} {
+		w := httptest.NewRecorder()
+		h.HandleUsers(w, withPrincipal(httptest.NewRequest(http.MethodPost, "/api/admin/users", bytes.NewBufferString(body)), admin))
+		if w.Code != http.StatusOK {
+			t.Fatalf("create user: %d %s", w.Code, w.Body.String())
+		}
+	}
+	w := httptest.NewRecorder()
+	h.HandleUsers(w, withPrincipal(httptest.NewRequest(http.MethodPost, "/api/admin/users", bytes.NewBufferString(`{"op":"create","name":"Alice","password":"x"}`)), admin))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected duplicate user to be rejected, got %d", w.Code)
+	}
+
+	login := func(name, password string) []*http.Cookie {
+		w := httptest.NewRecorder()
+		body, _ := json.Marshal(types.LoginRequest{Name: name, Password: password})
+		h.HandleLogin(w, httptest.NewRequest(http.MethodPost, "/api/login", bytes.NewReader(body)))
+		for _, c := range w.Result().Cookies() {
+			if c.Name == SessionCookie && c.Value != "" {
+				return w.Result().Cookies()
+			}
+		}
+		return nil
+	}
+	if login("alice", "wrong") != nil {
+		t.Fatal("Expected wrong password to be rejected")
+	}
+	alice, bob := login("alice", "pw-a"), login("bob", "pw-b")
+	if alice == nil || bob == nil {
+		t.Fatal("Expected login to issue session cookies")
+	}
+
+	app := WithSecurity(s, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/progress":
+			h.HandleProgress(w, r)
+		case "/api/media":
+			h.HandleMedia(w, r)
+		case "/api/probe":
+			h.HandleProbe(w, r)
+		}
+	}))
+	do := func(cookies []*http.Cookie, method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+		for _, c := range cookies {
+			req.AddCookie(c)
+			if c.Name == CSRFCookie {
+				req.Header.Set(CSRFHeader, c.Value)
+			}
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, req)
+		return w
+	}
+
+	// Progress is per user
+	do(alice, http.MethodPost, "/api/progress", `{"id":"m1","time":12}`)
+	do(bob, http.MethodPost, "/api/progress", `{"id":"m1","time":99}`)
+	if got := do(alice, http.MethodGet, "/api/progress?id=m1", "").Body.String(); !bytes.Contains([]byte(got), []byte(`"time":12`)) {
+		t.Errorf("Expected alice's own progress, got %s", got)
+	}
+
+	// Share ACLs: bob cannot see or probe the kids share
+	kidsID := util.EncodeID(filepath.Join(kids, "a.mp4"))
+	if code := do(bob, http.MethodGet, "/api/probe?id="+kidsID, "").Code; code != http.StatusForbidden {
+		t.Errorf("Expected bob to be denied the kids share, got %d", code)
+	}
+	if code := do(alice, http.MethodGet, "/api/probe?id="+kidsID, "").Code; code != http.StatusOK {
+		t.Errorf("Expected alice to access the kids share, got %d", code)
+	}
+
+	var media types.MediaResponse
+	resp := do(bob, http.MethodGet, "/api/media", "")
+	_ = json.Unmarshal(resp.Body.Bytes(), &media)
+	etag := resp.Header().Get("ETag")
+	if !regexp.MustCompile(`^W/"[0-9a-z]+-[0-9a-f]{8}"$`).MatchString(etag) {
+		t.Errorf("Expected a well-formed share-scoped weak ETag, got %q", etag)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/media", nil)
+	for _, c := range bob {
+		req.AddCookie(c)
+	}
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, req)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("Expected 304 for a matching ETag, got %d", w.Code)
+	}
+	if len(media.Shares) != 1 || media.Shares[0].Label != "Public" {
+		t.Errorf("Expected bob to see only the public share, got %+v", media.Shares)
+	}
+	for _, it := range media.Videos {
+		if it.ID == kidsID {
+			t.Error("Expected kids video to be hidden from bob")
+		}
+	}
+}
+
+func TestShareScopedETag(t *testing.T) {
+	visible := []config.Share{{Label: "Public", Path: "/srv/public"}}
+	if got := weakETag(shareScopedTag("abc", visible)); got != `W/"abc-ca0822e5"` {
+		t.Errorf("ETag = %s, want W/\"abc-ca0822e5\"", got)
+	}
+	if got := weakETag(shareScopedTag("", visible)); got != "" {
+		t.Errorf("Expected no ETag without a tag, got %s", got)
+	}
+}
@@ -0,0 +1,151 @@
+// Package ipmatch parses and matches IP whitelist/blacklist entries.
+//
+// An entry can be a single address ("192.168.1.10", "fe80::1"), a CIDR
+// prefix of any length ("10.0.0.0/12", "2001:db8::/32") or a named range
+// such as "private", "loopback", "linklocal" or "any".
+// IPv4-mapped IPv6 addresses (::ffff:a.b.c.d) match their IPv4 form.
+package ipmatch
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+var namedRanges = map[string][]string{
+	"private":   {"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7"},
+	"loopback":  {"127.0.0.0/8", "::1/128"},
+	"linklocal": {"169.254.0.0/16", "fe80::/10"},
+	"any":       {"0.0.0.0/0", "::/0"},
+}
+
+var namedAliases = map[string]string{
+	"link-local": "linklocal",
+	"localhost":  "loopback",
+	"all":        "any",
+	"*":          "any",
+}
+
+// Rule is a parsed whitelist/blacklist entry.
+type Rule struct {
+	Raw      string
+	prefixes []netip.Prefix
+}
+
+// ParseRule parses a single entry.
+func ParseRule(entry string) (Rule, error) {
+	raw := strings.TrimSpace(entry)
+	if raw == "" {
+		return Rule{}, fmt.Errorf("empty entry")
+	}
+
+	name := strings.ToLower(raw)
+	if alias, ok := namedAliases[name]; ok {
+		name = alias
+	}
+	if cidrs, ok := namedRanges[name]; ok {
+		r := Rule{Raw: raw}
+		for _, c := range cidrs {
+			r.prefixes = append(r.prefixes, netip.MustParsePrefix(c))
+		}
+		return r, nil
+	}
+
+	if strings.Contains(raw, "/") {
+		p, err := netip.ParsePrefix(raw)
+		if err != nil {
+			return Rule{}, fmt.Errorf("invalid CIDR %q: %w", raw, err)
+		}
+		return Rule{Raw: raw, prefixes: []netip.Prefix{normalizePrefix(p)}}, nil
+	}
+
+	addr, err := netip.ParseAddr(raw)
+	if err != nil {
+		return Rule{}, fmt.Errorf("invalid IP %q: %w", raw, err)
+	}
+	addr = normalizeAddr(addr)
+	return Rule{Raw: raw, prefixes: []netip.Prefix{netip.PrefixFrom(addr, addr.BitLen())}}, nil
+}
+
+// Contains reports whether addr falls within the rule.
+func (r Rule) Contains(addr netip.Addr) bool {
+	addr = normalizeAddr(addr)
+	for _, p := range r.prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// List is an ordered set of rules.
+type List []Rule
+
+// ParseList parses all non-empty entries. Invalid entries are skipped and
+// reported through the returned error slice.
+func ParseList(entries []string) (List, []error) {
+	out := make(List, 0, len(entries))
+	var errs []error
+	for _, e := range entries {
+		if strings.TrimSpace(e) == "" {
+			continue
+		}
+		r, err := ParseRule(e)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		out = append(out, r)
+	}
+	return out, errs
+}
+
+// Validate returns the first invalid entry error, if any.
+func Validate(entries []string) error {
+	if _, errs := ParseList(entries); len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// Contains reports whether addr matches any rule in the list.
+func (l List) Contains(addr netip.Addr) bool {
+	if !addr.IsValid() {
+		return false
+	}
+	for _, r := range l {
+		if r.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseAddr parses a client address string, accepting bracketed IPv6 and
+// zones, and unmaps IPv4-mapped IPv6 addresses.
+func ParseAddr(s string) (netip.Addr, bool) {
+	s = strings.Trim(strings.TrimSpace(s), "[]")
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return normalizeAddr(addr), true
+}
+
+func normalizeAddr(addr netip.Addr) netip.Addr {
+	return addr.WithZone("").Unmap()
+}
+
+// normalizePrefix converts ::ffff:a.b.c.d/n prefixes to their IPv4 form so
+// they match unmapped client addresses.
+func normalizePrefix(p netip.Prefix) netip.Prefix {
+	addr := p.Addr().WithZone("")
+	if addr.Is4In6() {
+		bits := p.Bits() - 96
+		if bits < 0 {
+			bits = 0
+		}
+		return netip.PrefixFrom(addr.Unmap(), bits).Masked()
+	}
+	return netip.PrefixFrom(addr, p.Bits()).Masked()
+}
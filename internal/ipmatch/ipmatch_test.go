@@ -0,0 +1,46 @@
+package ipmatch
+
+import "testing"
+
+func TestParseRule(t *testing.T) {
+	valid := []string{"192.168.1.1", "10.0.0.0/12", "2001:db8::/32", "::ffff:10.0.0.0/104", "private", "Loopback", "any"}
+	for _, e := range valid {
+		if _, err := ParseRule(e); err != nil {
+			t.Errorf("ParseRule(%q) unexpected error: %v", e, err)
+		}
+	}
+
+	invalid := []string{"192.168.1", "10.0.0.0/33", "abc", "2001:db8::/129", "192.168.1.1/"}
+	for _, e := range invalid {
+		if _, err := ParseRule(e); err == nil {
+			t.Errorf("ParseRule(%q) expected error", e)
+		}
+	}
+}
+
+func TestListContains(t *testing.T) {
+	l, errs := ParseList([]string{"192.168.1.0/25", "", "fe80::/10", "bogus"})
+	if len(errs) != 1 || len(l) != 2 {
+		t.Fatalf("Expected 2 rules and 1 error, got %d rules, %v", len(l), errs)
+	}
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"192.168.1.1", true},
+		{"192.168.1.200", false},
+		{"::ffff:192.168.1.1", true},
+		{"[fe80::1%eth0]", true},
+		{"2001:db8::1", false},
+	}
+	for _, tt := range tests {
+		addr, ok := ParseAddr(tt.ip)
+		if !ok {
+			t.Fatalf("ParseAddr(%q) failed", tt.ip)
+		}
+		if got := l.Contains(addr); got != tt.want {
+			t.Errorf("Contains(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package logging
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupStamp is the time format of rotated file names: msp.log.20261018-233232
+// (msp.log.20261018-233232.gz when compressed).
+const backupStamp = "20060102-150405"
+
+// Rotation configures when a File is rotated and which rotated files are
+// kept.
+type Rotation struct {
+	MaxSize    int64         // rotate before the file would exceed this many bytes; 0 disables
+	Daily      bool          // rotate at the first write of a new local day
+	MaxBackups int           // rotated files to keep; 0 keeps all
+	MaxAge     time.Duration // delete rotated files older than this; 0 keeps them
+	Compress   bool          // gzip rotated files
+}
+
+// File is a log file that rotates itself. It is safe for concurrent use:
+// writes, rotation and switching to another file are serialized, so no
+// record is lost or written to a closed file. While no file is open, writes
+// go to stderr. Compression and removal of old files run in the background.
+type File struct {
+	mu   sync.Mutex
+	f    *os.File
+	path string
+	size int64
+	day  string // local date the current file was started, "20060102"
+	rot  Rotation
+	now  func() time.Time
+
+	cleanMu sync.Mutex // serializes background compression and pruning
+	bg      sync.WaitGroup
+}
+
+// Open switches to the log file at path, creating it and its directory if
+// needed, and applies rot.
+func (l *File) Open(path string, rot Rotation) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return err
+	}
+	f, size, modTime, err := openLog(path)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f != nil {
+		_ = l.f.Close()
+	}
+	l.f, l.path, l.size, l.rot = f, path, size, rot
+	l.day = l.clock().Format("20060102")
+	if size > 0 {
+		l.day = modTime.Format("20060102")
+	}
+	l.pruneLater()
+	return nil
+}
+
+// SetRotation changes the rotation settings of the open file.
+func (l *File) SetRotation(rot Rotation) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rot = rot
+	if l.f != nil {
+		l.pruneLater()
+	}
+}
+
+// Close closes the file and waits for background compression; later
+// writes go to stderr.
+func (l *File) Close() error {
+	l.mu.Lock()
+	var err error
+	if l.f != nil {
+		_ = l.f.Sync()
+		err = l.f.Close()
+		l.f = nil
+	}
+	l.mu.Unlock()
+	l.bg.Wait()
+	return err
+}
+
+func (l *File) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return os.Stderr.Write(p)
+	}
+	if l.size == 0 {
+		l.day = l.clock().Format("20060102") // nothing to rotate yet
+	} else if l.dueLocked(len(p)) {
+		if err := l.rotateLocked(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to rotate log file: %v\n", err)
+		}
+		if l.f == nil {
+			return os.Stderr.Write(p)
+		}
+	}
+	n, err := l.f.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+func (l *File) clock() time.Time {
+	if l.now != nil {
+		return l.now()
+	}
+	return time.Now()
+}
+
+// dueLocked reports whether the file must be rotated before writing n bytes.
+func (l *File) dueLocked(n int) bool {
+	if l.rot.MaxSize > 0 && l.size+int64(n) > l.rot.MaxSize {
+		return true
+	}
+	return l.rot.Daily && l.clock().Format("20060102") != l.day
+}
+
+// rotateLocked renames the current file to a timestamped backup and starts
+// a new one. If the rename fails, writing continues in the current file.
+func (l *File) rotateLocked() error {
+	now := l.clock()
+	backup := l.backupName(now)
+	_ = l.f.Close()
+	l.f = nil
+	renameErr := os.Rename(l.path, backup)
+
+	f, size, _, err := openLog(l.path)
+	if err != nil {
+		return err
+	}
+	l.f, l.size, l.day = f, size, now.Format("20060102")
+	if renameErr != nil {
+		return renameErr
+	}
+	path, rot := l.path, l.rot
+	l.bg.Add(1)
+	go func() {
+		defer l.bg.Done()
+		l.cleanMu.Lock()
+		defer l.cleanMu.Unlock()
+		if rot.Compress {
+			// A later rotation may already have pruned the backup.
+			if err := compress(backup); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				fmt.Fprintf(os.Stderr, "Failed to compress log file: %v\n", err)
+			}
+		}
+		prune(path, rot, now)
+	}()
+	return nil
+}
+
+// backupName returns an unused name for a file rotated at t.
+func (l *File) backupName(t time.Time) string {
+	base := l.path + "." + t.Format(backupStamp)
+	name := base
+	for i := 1; exists(name) || exists(name+".gz"); i++ {
+		name = fmt.Sprintf("%s-%d", base, i)
+	}
+	return name
+}
+
+// pruneLater applies the retention settings to existing backups in the
+// background.
+func (l *File) pruneLater() {
+	path, rot, now := l.path, l.rot, l.clock()
+	l.bg.Add(1)
+	go func() {
+		defer l.bg.Done()
+		l.cleanMu.Lock()
+		defer l.cleanMu.Unlock()
+		prune(path, rot, now)
+	}()
+}
+
+// prune removes the backups of the log file at path beyond MaxBackups
+// (oldest first) and those older than MaxAge at now. Callers hold cleanMu.
+func prune(path string, rot Rotation, now time.Time) {
+	if rot.MaxBackups <= 0 && rot.MaxAge <= 0 {
+		return
+	}
+	cutoff := now.Add(-rot.MaxAge)
+	for i, b := range backups(path) {
+		tooMany := rot.MaxBackups > 0 && i >= rot.MaxBackups
+		tooOld := rot.MaxAge > 0 && b.at.Before(cutoff)
+		if tooMany || tooOld {
+			_ = os.Remove(b.path)
+		}
+	}
+}
+
+type backup struct {
+	path string
+	at   time.Time
+}
+
+// backups lists the rotated files of the log file at path, newest first.
+func backups(path string) []backup {
+	dir, prefix := filepath.Dir(path), filepath.Base(path)+"."
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var out []backup
+	for _, e := range entries {
+		rest, ok := strings.CutPrefix(e.Name(), prefix)
+		if !ok || e.IsDir() {
+			continue
+		}
+		rest = strings.TrimSuffix(rest, ".gz")
+		if len(rest) < len(backupStamp) {
+			continue
+		}
+		at, err := time.ParseInLocation(backupStamp, rest[:len(backupStamp)], time.Local)
+		if err != nil {
+			continue
+		}
+		out = append(out, backup{path: filepath.Join(dir, e.Name()), at: at})
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if !out[i].at.Equal(out[j].at) {
+			return out[i].at.After(out[j].at)
+		}
+		return out[i].path > out[j].path
+	})
+	return out
+}
+
+// compress gzips path into path.gz and removes path.
+func compress(path string) error {
+	//nolint:gosec // Path of a rotated log file
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = src.Close() }()
+	//nolint:gosec // Path of a rotated log file
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(path + ".gz")
+		return err
+	}
+	_ = src.Close()
+	return os.Remove(path)
+}
+
+func openLog(path string) (*os.File, int64, time.Time, error) {
+	//nolint:gosec // Log file path is controlled by config/CLI
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, 0, time.Time{}, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, 0, time.Time{}, err
+	}
+	return f, st.Size(), st.ModTime(), nil
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,142 @@
+package logging
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock returns a settable time for File.now.
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) add(d time.Duration) {
+	c.mu.Lock()
+	c.t = c.t.Add(d)
+	c.mu.Unlock()
+}
+
+// countLines counts the lines of path, decompressing .gz files.
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+	//nolint:gosec // Test file
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		r = zr
+	}
+	n := 0
+	for sc := bufio.NewScanner(r); sc.Scan(); {
+		n++
+	}
+	return n
+}
+
+func TestFileRotatesBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "msp.log")
+	clock := &fakeClock{t: time.Date(2026, 10, 18, 12, 0, 0, 0, time.Local)}
+	l := &File{now: clock.now}
+	if err := l.Open(path, Rotation{MaxSize: 100, MaxBackups: 2, Compress: true}); err != nil {
+		t.Fatal(err)
+	}
+	line := strings.Repeat("x", 39) + "\n" // 40 bytes: two lines per file
+	for i := 0; i < 8; i++ {
+		_, _ = l.Write([]byte(line))
+		clock.add(time.Second)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	backups := backups(path)
+	if len(backups) != 2 {
+		t.Fatalf("Expected 2 backups to be kept, got %v", backups)
+	}
+	for _, b := range backups {
+		if !strings.HasSuffix(b.path, ".gz") {
+			t.Errorf("Expected %s to be compressed", b.path)
+		}
+		if n := countLines(t, b.path); n != 2 {
+			t.Errorf("%s: %d lines, want 2", b.path, n)
+		}
+	}
+	if n := countLines(t, path); n != 2 {
+		t.Errorf("current file: %d lines, want 2", n)
+	}
+}
+
+func TestFileRotatesDaily(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "msp.log")
+	clock := &fakeClock{t: time.Date(2026, 10, 18, 23, 59, 0, 0, time.Local)}
+	l := &File{now: clock.now}
+	if err := l.Open(path, Rotation{Daily: true, MaxAge: 48 * time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	_, _ = l.Write([]byte("day 1\n"))
+	clock.add(time.Minute)
+	_, _ = l.Write([]byte("day 2\n"))
+	clock.add(72 * time.Hour)
+	_, _ = l.Write([]byte("day 5\n"))
+	_ = l.Close()
+
+	b := backups(path)
+	if len(b) != 1 || !strings.HasSuffix(b[0].path, ".20261022-000000") {
+		t.Fatalf("Expected only the day 2 backup within max age, got %v", b)
+	}
+	if n := countLines(t, path); n != 1 {
+		t.Errorf("current file: %d lines, want 1", n)
+	}
+}
+
+func TestFileConcurrentWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "msp.log")
+	l := &File{}
+	if err := l.Open(path, Rotation{MaxSize: 2048}); err != nil {
+		t.Fatal(err)
+	}
+	const writers, lines = 8, 200
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < lines; i++ {
+				_, _ = fmt.Fprintf(l, "writer %d line %d\n", w, i)
+			}
+		}()
+	}
+	wg.Wait()
+	_ = l.Close()
+
+	total := countLines(t, path)
+	for _, b := range backups(path) {
+		total += countLines(t, b.path)
+	}
+	if total != writers*lines {
+		t.Errorf("Expected %d lines across all files, got %d", writers*lines, total)
+	}
+}
@@ -0,0 +1,72 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	for in, want := range map[string]slog.Level{
+		"debug": slog.LevelDebug,
+		"INFO":  slog.LevelInfo,
+		"warn":  slog.LevelWarn,
+		"error": slog.LevelError,
+		"":      slog.LevelInfo,
+	} {
+		if got := ParseLevel(in); got != want {
+			t.Errorf("ParseLevel(%q) = %v, want %v", in, got, want)
+		}
+	}
+	if ParseLevel("none") <= slog.LevelError {
+		t.Error("Expected none to be above error")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	var buf bytes.Buffer
+	var level slog.LevelVar
+	log := slog.New(NewHandler(&buf, FormatJSON, &level))
+	ctx := WithRequestID(context.Background(), "abc123")
+
+	log.DebugContext(ctx, "hidden")
+	log.InfoContext(ctx, "Request", "status", 200)
+	var rec map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatalf("Expected one JSON record, got %q", buf.String())
+	}
+	if rec["msg"] != "Request" || rec["request_id"] != "abc123" || rec["status"] != float64(200) {
+		t.Errorf("Unexpected record %v", rec)
+	}
+
+	buf.Reset()
+	level.Set(ParseLevel(LevelNone))
+	log.ErrorContext(ctx, "dropped")
+	if buf.Len() != 0 {
+		t.Errorf("Expected nothing at level none, got %q", buf.String())
+	}
+
+	buf.Reset()
+	slog.New(NewHandler(&buf, FormatText, nil)).With("ip", "10.0.0.1").InfoContext(ctx, "Access denied")
+	if out := buf.String(); !strings.Contains(out, "ip=10.0.0.1") || !strings.Contains(out, "request_id=abc123") {
+		t.Errorf("Unexpected text record %q", out)
+	}
+}
+
+func TestValidRequestID(t *testing.T) {
+	for id, want := range map[string]bool{
+		NewRequestID():          true,
+		"req-1.a_B":             true,
+		"":                      false,
+		"a b":                   false,
+		"x\ninjected":           false,
+		strings.Repeat("a", 65): false,
+	} {
+		if got := ValidRequestID(id); got != want {
+			t.Errorf("ValidRequestID(%q) = %v, want %v", id, got, want)
+		}
+	}
+}
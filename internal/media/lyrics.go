@@ -0,0 +1,210 @@
+package media
+
+import (
+	"bytes"
+	"io/fs"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"msp/internal/types"
+)
+
+var (
+	lrcTimeTag = regexp.MustCompile(`\[(\d{1,3}):(\d{1,2})(?:[.:,](\d{1,3}))?\]`)
+	lrcMetaTag = regexp.MustCompile(`^\[([a-zA-Z#]+):([^\]]*)\]$`)
+	lrcWordTag = regexp.MustCompile(`<(\d{1,3}):(\d{1,2})(?:[.:,](\d{1,3}))?>`)
+)
+
+// LoadLyrics returns parsed lyrics for an audio file. A sidecar .lrc found by
+// FindAudioSidecarsCached wins; otherwise embedded ID3/Vorbis tags are tried.
+func LoadLyrics(audioAbs string) (types.LyricsResponse, bool) {
+	_, lrcAbs := FindAudioSidecarsCached(audioAbs, make(map[string][]fs.DirEntry))
+	if lrcAbs != "" {
+		//nolint:gosec // Sidecar path is derived from an allowed media file
+		if b, err := os.ReadFile(lrcAbs); err == nil {
+			resp := ParseLRC(b)
+			resp.Source = "lrc"
+			if len(resp.Lines) > 0 {
+				return resp, true
+			}
+		}
+	}
+
+	text, synced, source := ReadEmbeddedLyrics(audioAbs)
+	if source == "" {
+		return types.LyricsResponse{Lines: []types.LyricLine{}}, false
+	}
+	if len(synced) > 0 {
+		return types.LyricsResponse{Source: source, Synced: true, Lines: mergeDualLanguage(synced)}, true
+	}
+	resp := ParseLRC([]byte(text))
+	resp.Source = source
+	return resp, len(resp.Lines) > 0
+}
+
+// ParseLRC parses LRC text into time-sorted lines. It honours [offset:],
+// enhanced <mm:ss.xx> word timestamps and merges dual-language pairs
+// (two lines sharing a timestamp) into text + translation.
+// Text without any timestamps is returned as unsynced lines.
+func ParseLRC(b []byte) types.LyricsResponse {
+	s := decodeLyricsText(b)
+	s = strings.ReplaceAll(strings.ReplaceAll(s, "\r\n", "\n"), "\r", "\n")
+
+	resp := types.LyricsResponse{Lines: []types.LyricLine{}}
+	var offsetMs float64
+	var plain []types.LyricLine
+	var lines []types.LyricLine
+
+	for _, raw := range strings.Split(s, "\n") {
+		line := strings.TrimSpace(raw)
+		if line == "" {
+			continue
+		}
+		if m := lrcMetaTag.FindStringSubmatch(line); m != nil && !lrcTimeTag.MatchString(line) {
+			applyLrcMeta(&resp, &offsetMs, strings.ToLower(m[1]), strings.TrimSpace(m[2]))
+			continue
+		}
+
+		stamps := lrcTimeTag.FindAllStringSubmatchIndex(line, -1)
+		if len(stamps) == 0 {
+			plain = append(plain, types.LyricLine{Text: line})
+			continue
+		}
+
+		// Timestamps are leading tags; content follows the last one
+		content := line[stamps[len(stamps)-1][1]:]
+		text, words := parseLrcWords(content)
+		for _, st := range stamps {
+			t := lrcSeconds(line[st[2]:st[3]], line[st[4]:st[5]], lrcSubmatch(line, st[6], st[7]))
+			lines = append(lines, types.LyricLine{Time: t, Text: text, Words: words})
+		}
+	}
+
+	if len(lines) == 0 {
+		if plain == nil {
+			plain = []types.LyricLine{}
+		}
+		resp.Lines = plain
+		return resp
+	}
+
+	shift := offsetMs / 1000
+	for i := range lines {
+		lines[i].Time = shiftLyricTime(lines[i].Time, shift)
+		if len(lines[i].Words) > 0 {
+			words := make([]types.LyricWord, len(lines[i].Words))
+			for j, w := range lines[i].Words {
+				words[j] = types.LyricWord{Time: shiftLyricTime(w.Time, shift), Text: w.Text}
+			}
+			lines[i].Words = words
+		}
+	}
+
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].Time < lines[j].Time })
+	resp.Synced = true
+	resp.Lines = mergeDualLanguage(lines)
+	return resp
+}
+
+func applyLrcMeta(resp *types.LyricsResponse, offsetMs *float64, key, val string) {
+	switch key {
+	case "ti":
+		resp.Title = val
+	case "ar":
+		resp.Artist = val
+	case "al":
+		resp.Album = val
+	case "offset":
+		if v, err := strconv.ParseFloat(strings.TrimPrefix(val, "+"), 64); err == nil {
+			*offsetMs = v
+		}
+	}
+}
+
+// parseLrcWords strips enhanced word tags from content and returns the plain
+// text plus the per-word timings (nil when the line has no word tags).
+func parseLrcWords(content string) (string, []types.LyricWord) {
+	idx := lrcWordTag.FindAllStringSubmatchIndex(content, -1)
+	if len(idx) == 0 {
+		return strings.TrimSpace(content), nil
+	}
+
+	var words []types.LyricWord
+	var text strings.Builder
+	text.WriteString(content[:idx[0][0]])
+	for i, m := range idx {
+		end := len(content)
+		if i+1 < len(idx) {
+			end = idx[i+1][0]
+		}
+		seg := content[m[1]:end]
+		text.WriteString(seg)
+		if strings.TrimSpace(seg) == "" {
+			continue
+		}
+		t := lrcSeconds(content[m[2]:m[3]], content[m[4]:m[5]], lrcSubmatch(content, m[6], m[7]))
+		words = append(words, types.LyricWord{Time: t, Text: seg})
+	}
+	return strings.TrimSpace(text.String()), words
+}
+
+// mergeDualLanguage folds consecutive lines with the same timestamp into a
+// single line, treating the second one as the translation.
+func mergeDualLanguage(lines []types.LyricLine) []types.LyricLine {
+	out := make([]types.LyricLine, 0, len(lines))
+	for _, ln := range lines {
+		if n := len(out); n > 0 {
+			prev := &out[n-1]
+			if math.Abs(prev.Time-ln.Time) < 0.001 && prev.Translation == "" && prev.Text != "" && ln.Text != "" && prev.Text != ln.Text {
+				prev.Translation = ln.Text
+				continue
+			}
+		}
+		out = append(out, ln)
+	}
+	return out
+}
+
+func lrcSubmatch(s string, start, end int) string {
+	if start < 0 {
+		return ""
+	}
+	return s[start:end]
+}
+
+func lrcSeconds(mm, ss, frac string) float64 {
+	m, _ := strconv.Atoi(mm)
+	sec, _ := strconv.Atoi(ss)
+	t := float64(m*60 + sec)
+	if frac != "" {
+		f, _ := strconv.Atoi(frac)
+		t += float64(f) / math.Pow(10, float64(len(frac)))
+	}
+	return t
+}
+
+func shiftLyricTime(t, shift float64) float64 {
+	// Positive offset means lyrics appear earlier
+	t -= shift
+	if t < 0 {
+		return 0
+	}
+	return math.Round(t*1000) / 1000
+}
+
+// decodeLyricsText strips BOMs and converts UTF-16 lyrics to UTF-8.
+func decodeLyricsText(b []byte) string {
+	switch {
+	case bytes.HasPrefix(b, []byte{0xEF, 0xBB, 0xBF}):
+		return string(b[3:])
+	case bytes.HasPrefix(b, []byte{0xFF, 0xFE}):
+		return decodeUTF16(b[2:], false)
+	case bytes.HasPrefix(b, []byte{0xFE, 0xFF}):
+		return decodeUTF16(b[2:], true)
+	}
+	return string(b)
+}
@@ -0,0 +1,86 @@
+package media
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseLRC(t *testing.T) {
+	src := "\ufeff[ti:Song]\n[ar:Singer]\n[offset:+500]\n[00:10.00]Hello\n[00:10.00]你好\n[00:05.50][00:20.00]Chorus\n"
+	resp := ParseLRC([]byte(src))
+
+	if !resp.Synced {
+		t.Fatal("Expected synced lyrics")
+	}
+	if resp.Title != "Song" || resp.Artist != "Singer" {
+		t.Errorf("Unexpected meta: %q / %q", resp.Title, resp.Artist)
+	}
+	if len(resp.Lines) != 3 {
+		t.Fatalf("Expected 3 lines, got %d: %+v", len(resp.Lines), resp.Lines)
+	}
+	// offset +500ms shifts lines earlier
+	if resp.Lines[0].Time != 5 || resp.Lines[0].Text != "Chorus" {
+		t.Errorf("Unexpected first line: %+v", resp.Lines[0])
+	}
+	if resp.Lines[1].Time != 9.5 || resp.Lines[1].Text != "Hello" || resp.Lines[1].Translation != "你好" {
+		t.Errorf("Dual-language pair not merged: %+v", resp.Lines[1])
+	}
+}
+
+func TestParseLRCWords(t *testing.T) {
+	resp := ParseLRC([]byte("[00:01.00]<00:01.00>Hel<00:01.50>lo <00:02.00>world<00:02.50>\n"))
+	if len(resp.Lines) != 1 {
+		t.Fatalf("Expected 1 line, got %d", len(resp.Lines))
+	}
+	ln := resp.Lines[0]
+	if ln.Text != "Hello world" {
+		t.Errorf("Expected stripped text, got %q", ln.Text)
+	}
+	if len(ln.Words) != 3 || ln.Words[1].Time != 1.5 || ln.Words[2].Text != "world" {
+		t.Errorf("Unexpected words: %+v", ln.Words)
+	}
+}
+
+func TestParseLRCUnsynced(t *testing.T) {
+	resp := ParseLRC([]byte("line one\nline two\n"))
+	if resp.Synced || len(resp.Lines) != 2 {
+		t.Errorf("Expected 2 unsynced lines, got synced=%v lines=%d", resp.Synced, len(resp.Lines))
+	}
+}
+
+func TestVorbisLyrics(t *testing.T) {
+	le := func(n int) []byte { return []byte{byte(n), byte(n >> 8), byte(n >> 16), byte(n >> 24)} }
+	var b []byte
+	b = append(b, le(3)...)
+	b = append(b, "msp"...)
+	b = append(b, le(2)...)
+	for _, kv := range []string{"TITLE=x", "LYRICS=[00:01.00]hi"} {
+		b = append(b, le(len(kv))...)
+		b = append(b, kv...)
+	}
+	if got := vorbisLyrics(b); got != "[00:01.00]hi" {
+		t.Errorf("vorbisLyrics() = %q", got)
+	}
+}
+
+func TestReadEmbeddedLyricsID3(t *testing.T) {
+	frame := append([]byte{3, 'e', 'n', 'g', 0}, "[00:02.00]tagged"...)
+	body := append([]byte("USLT"), 0, 0, 0, byte(len(frame)), 0, 0)
+	body = append(body, frame...)
+	tag := append([]byte("ID3"), 3, 0, 0, 0, 0, 0, byte(len(body)))
+	tag = append(tag, body...)
+
+	p := filepath.Join(t.TempDir(), "song.mp3")
+	if err := os.WriteFile(p, append(tag, make([]byte, 32)...), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, ok := LoadLyrics(p)
+	if !ok || resp.Source != "id3" {
+		t.Fatalf("Expected id3 lyrics, got ok=%v source=%q", ok, resp.Source)
+	}
+	if len(resp.Lines) != 1 || resp.Lines[0].Text != "tagged" || resp.Lines[0].Time != 2 {
+		t.Errorf("Unexpected lines: %+v", resp.Lines)
+	}
+}
@@ -0,0 +1,370 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+
+	"msp/internal/types"
+)
+
+// maxTagBytes caps how much of a file is read while looking for lyrics tags.
+const maxTagBytes = 16 << 20
+
+// ReadEmbeddedLyrics extracts lyrics stored inside an audio file.
+// It supports ID3v2 USLT/SYLT frames and Vorbis LYRICS comments (FLAC/OGG/Opus).
+// synced is non-empty only for SYLT frames with millisecond timestamps;
+// otherwise text holds the raw (possibly LRC-formatted) lyrics.
+// source is "id3" or "vorbis", or empty when nothing was found.
+func ReadEmbeddedLyrics(fileAbs string) (text string, synced []types.LyricLine, source string) {
+	//nolint:gosec // Caller validates the path against shares
+	f, err := os.Open(fileAbs)
+	if err != nil {
+		return "", nil, ""
+	}
+	defer func() { _ = f.Close() }()
+
+	head := make([]byte, 10)
+	if _, err := io.ReadFull(f, head); err != nil {
+		return "", nil, ""
+	}
+
+	switch {
+	case bytes.HasPrefix(head, []byte("ID3")):
+		text, synced = readID3Lyrics(f, head)
+		if text != "" || len(synced) > 0 {
+			return text, synced, "id3"
+		}
+	case bytes.HasPrefix(head, []byte("fLaC")):
+		if _, err := f.Seek(4, io.SeekStart); err != nil {
+			return "", nil, ""
+		}
+		if text = readFlacLyrics(f); text != "" {
+			return text, nil, "vorbis"
+		}
+	case bytes.HasPrefix(head, []byte("OggS")):
+		if text = readOggLyrics(f); text != "" {
+			return text, nil, "vorbis"
+		}
+	}
+
+	// Some FLAC files are prefixed by an ID3 tag; fall back to the Vorbis path.
+	if strings.EqualFold(filepath.Ext(fileAbs), ".flac") && bytes.HasPrefix(head, []byte("ID3")) {
+		size := int64(synchsafe(head[6:10])) + 10
+		if _, err := f.Seek(size, io.SeekStart); err == nil {
+			magic := make([]byte, 4)
+			if _, err := io.ReadFull(f, magic); err == nil && string(magic) == "fLaC" {
+				if text = readFlacLyrics(f); text != "" {
+					return text, nil, "vorbis"
+				}
+			}
+		}
+	}
+	return "", nil, ""
+}
+
+func readID3Lyrics(r io.Reader, head []byte) (string, []types.LyricLine) {
+	major := head[3]
+	flags := head[5]
+	size := synchsafe(head[6:10])
+	if size <= 0 || size > maxTagBytes || major < 2 || major > 4 {
+		return "", nil
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return "", nil
+	}
+	if flags&0x80 != 0 && major < 4 {
+		body = removeUnsync(body)
+	}
+	if flags&0x40 != 0 && major >= 3 {
+		body = skipID3ExtHeader(body, major)
+	}
+
+	idLen, hdrLen := 4, 10
+	if major == 2 {
+		idLen, hdrLen = 3, 6
+	}
+
+	var text string
+	var synced []types.LyricLine
+	for len(body) >= hdrLen {
+		id := string(body[:idLen])
+		if body[0] == 0 {
+			break
+		}
+		var fsize int
+		switch major {
+		case 2:
+			fsize = int(body[3])<<16 | int(body[4])<<8 | int(body[5])
+		case 3:
+			fsize = int(binary.BigEndian.Uint32(body[4:8]))
+		default:
+			fsize = synchsafe(body[4:8])
+		}
+		if fsize <= 0 || hdrLen+fsize > len(body) {
+			break
+		}
+		frame := body[hdrLen : hdrLen+fsize]
+		if major == 4 && body[9]&0x02 != 0 {
+			frame = removeUnsync(frame)
+		}
+		body = body[hdrLen+fsize:]
+
+		switch id {
+		case "USLT", "ULT":
+			if text == "" {
+				text = parseUSLT(frame)
+			}
+		case "SYLT", "SLT":
+			if len(synced) == 0 {
+				synced = parseSYLT(frame)
+			}
+		}
+	}
+	return text, synced
+}
+
+func skipID3ExtHeader(body []byte, major byte) []byte {
+	if len(body) < 4 {
+		return body
+	}
+	n := int(binary.BigEndian.Uint32(body[:4]))
+	if major == 3 {
+		n += 4 // v2.3 size excludes the size field itself
+	} else {
+		n = synchsafe(body[:4])
+	}
+	if n <= 0 || n > len(body) {
+		return body
+	}
+	return body[n:]
+}
+
+// parseUSLT decodes: encoding(1) lang(3) descriptor(\0) text.
+func parseUSLT(frame []byte) string {
+	if len(frame) < 5 {
+		return ""
+	}
+	enc := frame[0]
+	rest := frame[4:]
+	_, rest = splitID3String(rest, enc)
+	return strings.TrimSpace(decodeID3Text(rest, enc))
+}
+
+// parseSYLT decodes: encoding(1) lang(3) format(1) type(1) descriptor(\0)
+// followed by repeated text(\0) + uint32 timestamp pairs.
+func parseSYLT(frame []byte) []types.LyricLine {
+	if len(frame) < 7 {
+		return nil
+	}
+	enc := frame[0]
+	// Only millisecond timestamps can be mapped without decoding the audio
+	if frame[4] != 2 {
+		return nil
+	}
+	_, rest := splitID3String(frame[6:], enc)
+
+	var out []types.LyricLine
+	for len(rest) > 0 {
+		var raw []byte
+		raw, rest = splitID3String(rest, enc)
+		if len(rest) < 4 {
+			break
+		}
+		ms := binary.BigEndian.Uint32(rest[:4])
+		rest = rest[4:]
+		txt := strings.TrimSpace(decodeID3Text(raw, enc))
+		if txt == "" {
+			continue
+		}
+		out = append(out, types.LyricLine{Time: float64(ms) / 1000, Text: txt})
+	}
+	return out
+}
+
+// splitID3String returns the bytes before the encoding-specific terminator
+// and the remainder after it.
+func splitID3String(b []byte, enc byte) ([]byte, []byte) {
+	if enc == 1 || enc == 2 {
+		for i := 0; i+1 < len(b); i += 2 {
+			if b[i] == 0 && b[i+1] == 0 {
+				return b[:i], b[i+2:]
+			}
+		}
+		return b, nil
+	}
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		return b[:i], b[i+1:]
+	}
+	return b, nil
+}
+
+func decodeID3Text(b []byte, enc byte) string {
+	switch enc {
+	case 0:
+		// ISO-8859-1 maps 1:1 onto the first 256 code points
+		r := make([]rune, len(b))
+		for i, c := range b {
+			r[i] = rune(c)
+		}
+		return string(r)
+	case 1:
+		if bytes.HasPrefix(b, []byte{0xFE, 0xFF}) {
+			return decodeUTF16(b[2:], true)
+		}
+		if bytes.HasPrefix(b, []byte{0xFF, 0xFE}) {
+			return decodeUTF16(b[2:], false)
+		}
+		return decodeUTF16(b, false)
+	case 2:
+		return decodeUTF16(b, true)
+	default:
+		return string(bytes.TrimPrefix(b, []byte{0xEF, 0xBB, 0xBF}))
+	}
+}
+
+func decodeUTF16(b []byte, bigEndian bool) string {
+	u := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		if bigEndian {
+			u = append(u, binary.BigEndian.Uint16(b[i:]))
+		} else {
+			u = append(u, binary.LittleEndian.Uint16(b[i:]))
+		}
+	}
+	return string(utf16.Decode(u))
+}
+
+func removeUnsync(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		out = append(out, b[i])
+		if b[i] == 0xFF && i+1 < len(b) && b[i+1] == 0x00 {
+			i++
+		}
+	}
+	return out
+}
+
+func synchsafe(b []byte) int {
+	return int(b[0]&0x7F)<<21 | int(b[1]&0x7F)<<14 | int(b[2]&0x7F)<<7 | int(b[3]&0x7F)
+}
+
+// readFlacLyrics walks FLAC metadata blocks looking for VORBIS_COMMENT.
+// r must be positioned right after the "fLaC" marker.
+func readFlacLyrics(r io.ReadSeeker) string {
+	hdr := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(r, hdr); err != nil {
+			return ""
+		}
+		last := hdr[0]&0x80 != 0
+		typ := hdr[0] & 0x7F
+		n := int64(hdr[1])<<16 | int64(hdr[2])<<8 | int64(hdr[3])
+		if typ == 4 {
+			if n > maxTagBytes {
+				return ""
+			}
+			block := make([]byte, n)
+			if _, err := io.ReadFull(r, block); err != nil {
+				return ""
+			}
+			return vorbisLyrics(block)
+		}
+		if last {
+			return ""
+		}
+		if _, err := r.Seek(n, io.SeekCurrent); err != nil {
+			return ""
+		}
+	}
+}
+
+// readOggLyrics reassembles the second logical packet of an Ogg stream
+// (the Vorbis/Opus comment header) and extracts its LYRICS field.
+func readOggLyrics(r io.ReadSeeker) string {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return ""
+	}
+	var packet []byte
+	packets := 0
+	hdr := make([]byte, 27)
+	for packets < 2 {
+		if _, err := io.ReadFull(r, hdr); err != nil || string(hdr[:4]) != "OggS" {
+			return ""
+		}
+		segs := make([]byte, hdr[26])
+		if _, err := io.ReadFull(r, segs); err != nil {
+			return ""
+		}
+		for _, l := range segs {
+			seg := make([]byte, l)
+			if _, err := io.ReadFull(r, seg); err != nil {
+				return ""
+			}
+			if packets == 1 {
+				packet = append(packet, seg...)
+				if len(packet) > maxTagBytes {
+					return ""
+				}
+			}
+			if l < 255 {
+				packets++
+				if packets == 2 {
+					break
+				}
+			}
+		}
+	}
+
+	switch {
+	case bytes.HasPrefix(packet, []byte("\x03vorbis")):
+		return vorbisLyrics(packet[7:])
+	case bytes.HasPrefix(packet, []byte("OpusTags")):
+		return vorbisLyrics(packet[8:])
+	}
+	return ""
+}
+
+// vorbisLyrics parses a Vorbis comment list and returns the first
+// LYRICS / UNSYNCEDLYRICS value.
+func vorbisLyrics(b []byte) string {
+	if len(b) < 8 {
+		return ""
+	}
+	vendor := int(binary.LittleEndian.Uint32(b))
+	if 4+vendor+4 > len(b) {
+		return ""
+	}
+	b = b[4+vendor:]
+	count := int(binary.LittleEndian.Uint32(b))
+	b = b[4:]
+
+	fallback := ""
+	for i := 0; i < count && len(b) >= 4; i++ {
+		n := int(binary.LittleEndian.Uint32(b))
+		if 4+n > len(b) {
+			break
+		}
+		kv := string(b[4 : 4+n])
+		b = b[4+n:]
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			continue
+		}
+		switch strings.ToUpper(k) {
+		case "LYRICS":
+			return strings.TrimSpace(v)
+		case "UNSYNCEDLYRICS", "UNSYNCED LYRICS":
+			if fallback == "" {
+				fallback = strings.TrimSpace(v)
+			}
+		}
+	}
+	return fallback
+}
@@ -5,10 +5,12 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"os/exec"
 	"strings"
 	"sync"
+
+	"msp/internal/metrics"
 )
 
 // Global semaphore for limiting concurrent transcode sessions
@@ -31,6 +33,7 @@ type limitReleaser struct {
 func (l *limitReleaser) Close() error {
 	l.once.Do(func() {
 		<-transcodeLimit
+		metrics.TranscodesActive.Dec()
 	})
 	return l.ReadCloser.Close()
 }
@@ -45,7 +48,7 @@ type CodecInfo struct {
 func CheckFFmpeg() bool {
 	_, err := exec.LookPath("ffmpeg")
 	if err != nil {
-		log.Printf("[WARN] FFmpeg not found in PATH")
+		slog.Warn("FFmpeg not found in PATH")
 	}
 	return err == nil
 }
@@ -56,6 +59,35 @@ func CheckFFprobe() bool {
 	return err == nil
 }
 
+// ActiveTranscodes 返回正在进行的转码数
+func ActiveTranscodes() int {
+	return len(transcodeLimit)
+}
+
+// toolVersions caches version lines by executable path
+var toolVersions sync.Map
+
+// ToolVersion 返回 ffmpeg/ffprobe 等工具 "-version" 输出的第一行；
+// 未安装时 ok 为 false。结果按可执行文件路径缓存
+func ToolVersion(ctx context.Context, name string) (version string, ok bool) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return "", false
+	}
+	if v, ok := toolVersions.Load(path); ok {
+		return v.(string), true
+	}
+	//nolint:gosec // Fixed tool name
+	out, err := exec.CommandContext(ctx, path, "-version").Output()
+	if err != nil {
+		return "", true
+	}
+	line, _, _ := strings.Cut(string(out), "\n")
+	version = strings.TrimSpace(line)
+	toolVersions.Store(path, version)
+	return version, true
+}
+
 // GetCodecInfo 使用 ffprobe 获取文件的编码信息
 func GetCodecInfo(ctx context.Context, inputPath string) (CodecInfo, error) {
 	args := []string{
@@ -94,7 +126,9 @@ func TranscodeStream(ctx context.Context, inputPath string, opts TranscodeOption
 	select {
 	case transcodeLimit <- struct{}{}:
 		// Acquired
+		metrics.TranscodesActive.Inc()
 	default:
+		metrics.TranscodesRejected.Inc()
 		return nil, fmt.Errorf("server busy: max transcode limit reached")
 	}
 
@@ -107,6 +141,7 @@ func TranscodeStream(ctx context.Context, inputPath string, opts TranscodeOption
 	defer func() {
 		if !success {
 			<-transcodeLimit
+			metrics.TranscodesActive.Dec()
 		}
 	}()
 
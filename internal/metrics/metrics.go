@@ -0,0 +1,116 @@
+// Package metrics holds the Prometheus collectors served at /metrics.
+// Other packages update them directly; the package itself depends on no
+// other part of msp.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "msp"
+
+// Registry holds every msp collector plus the Go runtime and process
+// collectors.
+var Registry = prometheus.NewRegistry()
+
+var (
+	// HTTPRequests counts finished requests by mux route, method and status code.
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	// HTTPDuration observes request latencies by mux route and method.
+	// Streams are observed when they end.
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latencies by route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	// StreamedBytes counts media bytes sent, by mode "direct" or "transcode".
+	StreamedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "stream_bytes_total",
+		Help:      "Media bytes streamed, by mode (direct or transcode).",
+	}, []string{"mode"})
+
+	// TranscodesActive is the number of running ffmpeg transcodes.
+	TranscodesActive = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "transcodes_active",
+		Help:      "Transcodes currently running.",
+	})
+
+	// TranscodesRejected counts transcodes refused because the limit was reached.
+	TranscodesRejected = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "transcodes_rejected_total",
+		Help:      "Transcodes rejected because the concurrency limit was reached.",
+	})
+
+	// ScanDuration observes completed media scans.
+	ScanDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "scan_duration_seconds",
+		Help:      "Duration of completed media scans.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 4, 8), // 100ms to ~27min
+	})
+
+	// MediaItems is the number of indexed items by kind.
+	MediaItems = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "media_items",
+		Help:      "Items in the media index, by kind.",
+	}, []string{"kind"})
+
+	// MediaCacheRequests counts media index lookups by result: "hit" (served
+	// from memory), "db" (loaded from the database), "miss" (scanned while
+	// the request waited) or "scanning" (served stale data during a scan).
+	MediaCacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "media_cache_requests_total",
+		Help:      "Media index lookups by result (hit, db, miss, scanning).",
+	}, []string{"result"})
+
+	// DBQueryDuration observes database statements by operation.
+	DBQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Database statement latencies by operation.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 4, 8), // 0.5ms to ~8s
+	}, []string{"op"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests, HTTPDuration, StreamedBytes,
+		TranscodesActive, TranscodesRejected,
+		ScanDuration, MediaItems, MediaCacheRequests,
+		DBQueryDuration,
+	)
+}
+
+// Handler serves Registry in the Prometheus text exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// Method returns m if it is a standard HTTP method and "other" otherwise,
+// so that arbitrary methods cannot create new label values.
+func Method(m string) string {
+	switch m {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return m
+	}
+	return "other"
+}
@@ -0,0 +1,247 @@
+// Package paths locates the directories MSP reads and writes.
+//
+// By default MSP follows the platform conventions: the XDG base directories
+// on Linux and other Unix systems, ~/Library on macOS and %AppData% /
+// %LocalAppData% on Windows. MSP_HOME or --data-dir put everything into one
+// directory, and portable mode keeps it beside the executable.
+package paths
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+const (
+	appName = "msp"
+
+	// PortableMarker is a file that, placed beside the executable, enables
+	// portable mode.
+	PortableMarker = "portable"
+
+	ConfigFile     = "config.json"
+	DBFile         = "msp.db"
+	MediaCacheFile = "media_cache.json"
+)
+
+// Dirs are the directories MSP uses.
+type Dirs struct {
+	Config   string // config.json
+	Data     string // database, certificates and logs
+	Cache    string // rebuildable caches
+	Portable bool   // everything lives beside the executable
+	Explicit bool   // chosen by --data-dir or $MSP_HOME
+}
+
+// ConfigFiles are the config file names looked for, in order of preference.
+var ConfigFiles = []string{ConfigFile, "config.yaml", "config.yml", "config.toml"}
+
+// ConfigPath returns the default config file path: the first of
+// ConfigFiles that exists, or config.json for a new installation.
+func (d Dirs) ConfigPath() string {
+	for _, name := range ConfigFiles {
+		if p := filepath.Join(d.Config, name); fileExists(p) {
+			return p
+		}
+	}
+	return filepath.Join(d.Config, ConfigFile)
+}
+
+// DBPath returns the database path.
+func (d Dirs) DBPath() string { return filepath.Join(d.Data, DBFile) }
+
+// MediaCachePath returns the media index cache used when there is no database.
+func (d Dirs) MediaCachePath() string { return filepath.Join(d.Cache, MediaCacheFile) }
+
+// single puts config and data into dir and caches into dir/cache.
+func single(dir string) Dirs {
+	return Dirs{Config: dir, Data: dir, Cache: filepath.Join(dir, "cache")}
+}
+
+// Resolve picks the directories in this order: dataDir (the --data-dir
+// flag), $MSP_HOME, portable mode (the portable flag or a PortableMarker
+// file beside exeDir), and finally the per-user platform directories.
+func Resolve(dataDir string, portable bool, exeDir string) (Dirs, error) {
+	if dataDir != "" {
+		d := single(dataDir)
+		d.Explicit = true
+		return d, nil
+	}
+	if home := os.Getenv("MSP_HOME"); home != "" {
+		d := single(home)
+		d.Explicit = true
+		return d, nil
+	}
+	if portable || fileExists(filepath.Join(exeDir, PortableMarker)) {
+		d := single(exeDir)
+		d.Portable = true
+		return d, nil
+	}
+	return userDirs()
+}
+
+func userDirs() (Dirs, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return Dirs{}, fmt.Errorf("cannot determine home directory (set MSP_HOME or --data-dir): %w", err)
+	}
+	if runtime.GOOS == "windows" {
+		roaming := envDir("APPDATA", filepath.Join(home, "AppData", "Roaming"))
+		local := filepath.Join(envDir("LOCALAPPDATA", filepath.Join(home, "AppData", "Local")), appName)
+		return Dirs{Config: filepath.Join(roaming, appName), Data: local, Cache: filepath.Join(local, "cache")}, nil
+	}
+
+	var d Dirs
+	switch runtime.GOOS {
+	case "darwin":
+		support := filepath.Join(home, "Library", "Application Support")
+		d = Dirs{Config: support, Data: support, Cache: filepath.Join(home, "Library", "Caches")}
+	default:
+		d = Dirs{
+			Config: filepath.Join(home, ".config"),
+			Data:   filepath.Join(home, ".local", "share"),
+			Cache:  filepath.Join(home, ".cache"),
+		}
+	}
+	// Explicit XDG variables win on every Unix-like system.
+	d.Config = filepath.Join(envDir("XDG_CONFIG_HOME", d.Config), appName)
+	d.Data = filepath.Join(envDir("XDG_DATA_HOME", d.Data), appName)
+	d.Cache = filepath.Join(envDir("XDG_CACHE_HOME", d.Cache), appName)
+	return d, nil
+}
+
+// envDir returns $name if it is an absolute path (relative XDG paths are
+// invalid per the spec), else def.
+func envDir(name, def string) string {
+	if v := os.Getenv(name); v != "" && filepath.IsAbs(v) {
+		return v
+	}
+	return def
+}
+
+func fileExists(p string) bool {
+	_, err := os.Stat(p)
+	return err == nil
+}
+
+// MigrateLegacy moves files that older versions kept beside the executable
+// (in from) into d. It only runs for the default per-user directories when d
+// has no config file yet, so it happens once and never overwrites anything.
+// Nothing is moved while active, the config file in use, is the legacy one.
+// It returns the destination paths of the moved files.
+func MigrateLegacy(from string, d Dirs, active string) ([]string, error) {
+	legacy := filepath.Join(from, ConfigFile)
+	if d.Portable || d.Explicit || samePath(from, d.Config) || !fileExists(legacy) || fileExists(d.ConfigPath()) {
+		return nil, nil
+	}
+	if active != "" && samePath(active, legacy) {
+		return nil, nil
+	}
+	moves := []struct{ src, dst string }{
+		{ConfigFile, d.ConfigPath()},
+		{ConfigFile + ".media_cache.json", d.MediaCachePath()},
+		{DBFile, d.DBPath()},
+		{DBFile + "-wal", d.DBPath() + "-wal"},
+		{DBFile + "-shm", d.DBPath() + "-shm"},
+		{"certs", filepath.Join(d.Data, "certs")},
+		{"logs", filepath.Join(d.Data, "logs")},
+	}
+	var moved []string
+	var errs []error
+	for _, m := range moves {
+		src := filepath.Join(from, m.src)
+		if !fileExists(src) || fileExists(m.dst) {
+			continue
+		}
+		if err := move(src, m.dst); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", src, err))
+			continue
+		}
+		moved = append(moved, m.dst)
+	}
+	return moved, errors.Join(errs...)
+}
+
+func samePath(a, b string) bool {
+	fa, errA := os.Stat(a)
+	fb, errB := os.Stat(b)
+	if errA != nil || errB != nil {
+		return filepath.Clean(a) == filepath.Clean(b)
+	}
+	return os.SameFile(fa, fb)
+}
+
+// move renames src to dst, copying when they are on different file systems.
+func move(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0750); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	st, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !st.IsDir() {
+		if err := copyFile(src, dst, st.Mode().Perm()); err != nil {
+			return err
+		}
+		return os.Remove(src)
+	}
+
+	if err := copyTree(src, dst); err != nil {
+		return err
+	}
+	return os.RemoveAll(src)
+}
+
+// copyTree copies the directory src and everything below it to dst. Only
+// directories and regular files are copied; anything else is an error, so
+// src is never removed with content left behind.
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(p string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		info, err := e.Info()
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case e.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case info.Mode().IsRegular():
+			return copyFile(p, target, info.Mode().Perm())
+		default:
+			return fmt.Errorf("%s: not a regular file", p)
+		}
+	})
+}
+
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src) //nolint:gosec // paths come from the fixed migration list
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm) //nolint:gosec // see above
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		_ = os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,142 @@
+package paths
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	exe := t.TempDir()
+	home := t.TempDir()
+	t.Setenv("MSP_HOME", "")
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, "cfg"))
+	t.Setenv("XDG_DATA_HOME", "relative/ignored")
+	t.Setenv("XDG_CACHE_HOME", "")
+
+	d, err := Resolve("", false, exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS == "linux" {
+		want := Dirs{
+			Config: filepath.Join(home, "cfg", "msp"),
+			Data:   filepath.Join(home, ".local", "share", "msp"),
+			Cache:  filepath.Join(home, ".cache", "msp"),
+		}
+		if d != want {
+			t.Errorf("xdg: got %+v, want %+v", d, want)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(exe, PortableMarker), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if d, _ := Resolve("", false, exe); !d.Portable || d.Config != exe || d.DBPath() != filepath.Join(exe, DBFile) {
+		t.Errorf("portable marker: %+v", d)
+	}
+
+	t.Setenv("MSP_HOME", filepath.Join(home, "msp-home"))
+	if d, _ := Resolve("", true, exe); d.Portable || d.Data != filepath.Join(home, "msp-home") {
+		t.Errorf("MSP_HOME should win over portable: %+v", d)
+	}
+	if d, _ := Resolve("/srv/msp", false, exe); d.Config != "/srv/msp" || d.Cache != filepath.Join("/srv/msp", "cache") {
+		t.Errorf("--data-dir: %+v", d)
+	}
+}
+
+func TestMigrateLegacy(t *testing.T) {
+	exe := t.TempDir()
+	for name, body := range map[string]string{
+		ConfigFile:           `{"port":8099}`,
+		DBFile:               "db",
+		"certs/ca.pem":       "ca",
+		"logs/msp.log":       "log",
+		"unrelated-file.txt": "keep",
+	} {
+		p := filepath.Join(exe, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(body), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	root := t.TempDir()
+	d := Dirs{Config: filepath.Join(root, "config"), Data: filepath.Join(root, "data"), Cache: filepath.Join(root, "cache")}
+	moved, err := MigrateLegacy(exe, d, d.ConfigPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(moved) != 4 {
+		t.Errorf("moved = %v", moved)
+	}
+	for _, p := range []string{d.ConfigPath(), d.DBPath(), filepath.Join(d.Data, "certs", "ca.pem"), filepath.Join(d.Data, "logs", "msp.log")} {
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("missing %s", p)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(exe, ConfigFile)); !os.IsNotExist(err) {
+		t.Error("legacy config was not moved")
+	}
+	if _, err := os.Stat(filepath.Join(exe, "unrelated-file.txt")); err != nil {
+		t.Error("unrelated file was touched")
+	}
+
+	// A second run finds the new config and does nothing.
+	if err := os.WriteFile(filepath.Join(exe, ConfigFile), []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if moved, err := MigrateLegacy(exe, d, d.ConfigPath()); err != nil || len(moved) != 0 {
+		t.Errorf("second run: %v %v", moved, err)
+	}
+}
+
+func TestMigrateLegacySkipsExplicitLocations(t *testing.T) {
+	exe := t.TempDir()
+	legacy := filepath.Join(exe, ConfigFile)
+	if err := os.WriteFile(legacy, []byte(`{"port":8099}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+	d := Dirs{Config: filepath.Join(root, "config"), Data: filepath.Join(root, "data"), Cache: filepath.Join(root, "cache")}
+
+	// --config naming the legacy file keeps it in place
+	if moved, err := MigrateLegacy(exe, d, legacy); err != nil || len(moved) != 0 {
+		t.Errorf("active legacy config: %v %v", moved, err)
+	}
+	// --data-dir or MSP_HOME
+	explicit := d
+	explicit.Explicit = true
+	if moved, err := MigrateLegacy(exe, explicit, explicit.ConfigPath()); err != nil || len(moved) != 0 {
+		t.Errorf("explicit dirs: %v %v", moved, err)
+	}
+	if _, err := os.Stat(legacy); err != nil {
+		t.Error("legacy config was moved")
+	}
+}
+
+func TestCopyTree(t *testing.T) {
+	src := t.TempDir()
+	for _, name := range []string{"msp.log", "2026/01/msp.log.gz"} {
+		p := filepath.Join(src, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(name), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dst := filepath.Join(t.TempDir(), "logs")
+	if err := copyTree(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"msp.log", "2026/01/msp.log.gz"} {
+		if b, err := os.ReadFile(filepath.Join(dst, name)); err != nil || string(b) != name {
+			t.Errorf("%s: %q %v", name, b, err)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+// Package pinhash hashes and verifies PINs/passwords with argon2id.
+//
+// Hashes are stored in the PHC string format:
+//
+//	$argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>
+//
+// Parameters are read back from the encoded string, so hashes created with
+// older parameters keep verifying after the defaults change.
+package pinhash
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	prefix = "$argon2id$"
+
+	// Defaults follow the OWASP minimum recommendation for argon2id.
+	defaultMemory  = 19 * 1024 // KiB
+	defaultTime    = 2
+	defaultThreads = 1
+	saltLen        = 16
+	keyLen         = 32
+)
+
+// ErrInvalidHash is returned when an encoded hash cannot be parsed.
+var ErrInvalidHash = errors.New("invalid argon2id hash")
+
+var b64 = base64.RawStdEncoding
+
+type params struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+}
+
+// Hash returns the encoded argon2id hash of secret.
+func Hash(secret string) (string, error) {
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	p := params{memory: defaultMemory, time: defaultTime, threads: defaultThreads}
+	key := argon2.IDKey([]byte(secret), salt, p.time, p.memory, p.threads, keyLen)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		prefix, argon2.Version, p.memory, p.time, p.threads,
+		b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+}
+
+// Verify reports whether secret matches the encoded hash. Empty secrets and
+// malformed hashes never match.
+func Verify(secret, encoded string) bool {
+	if secret == "" {
+		return false
+	}
+	p, salt, key, err := decode(encoded)
+	if err != nil {
+		return false
+	}
+	got := argon2.IDKey([]byte(secret), salt, p.time, p.memory, p.threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(got, key) == 1
+}
+
+// IsHash reports whether s looks like a hash produced by Hash.
+func IsHash(s string) bool {
+	_, _, _, err := decode(s)
+	return err == nil
+}
+
+func decode(encoded string) (params, []byte, []byte, error) {
+	var p params
+	if !strings.HasPrefix(encoded, prefix) {
+		return p, nil, nil, ErrInvalidHash
+	}
+	// "", "argon2id", "v=19", "m=..,t=..,p=..", salt, key
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return p, nil, nil, ErrInvalidHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, ErrInvalidHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil {
+		return p, nil, nil, ErrInvalidHash
+	}
+	if p.memory == 0 || p.time == 0 || p.threads == 0 {
+		return p, nil, nil, ErrInvalidHash
+	}
+
+	salt, err := b64.DecodeString(parts[4])
+	if err != nil || len(salt) == 0 {
+		return p, nil, nil, ErrInvalidHash
+	}
+	key, err := b64.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return p, nil, nil, ErrInvalidHash
+	}
+	return p, salt, key, nil
+}
@@ -0,0 +1,45 @@
+package pinhash
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHashVerify(t *testing.T) {
+	h, err := Hash("1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(h, "$argon2id$v=19$") || strings.Contains(h, "1234") {
+		t.Fatalf("Unexpected hash format: %s", h)
+	}
+	if !IsHash(h) {
+		t.Error("Expected IsHash to accept generated hash")
+	}
+	if !Verify("1234", h) {
+		t.Error("Expected correct PIN to verify")
+	}
+	if Verify("12345", h) || Verify("", h) {
+		t.Error("Expected wrong or empty PIN to fail")
+	}
+
+	h2, _ := Hash("1234")
+	if h == h2 {
+		t.Error("Expected random salt to produce different hashes")
+	}
+}
+
+func TestVerifyMalformed(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"1234",
+		"$argon2id$v=19$m=19456,t=2,p=1$",
+		"$argon2id$v=18$m=19456,t=2,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=0,t=2,p=1$c2FsdA$a2V5",
+		"$argon2i$v=19$m=19456,t=2,p=1$c2FsdA$a2V5",
+	} {
+		if IsHash(s) || Verify("1234", s) {
+			t.Errorf("Expected %q to be rejected", s)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"msp/internal/config"
+	"msp/internal/db"
+	"msp/internal/types"
+)
+
+const (
+	// auditPruneInterval limits how often old audit events are deleted.
+	auditPruneInterval = time.Hour
+	// deniedAuditInterval limits ip_denied events to one per IP per interval,
+	// so a scanner cannot flood the table.
+	deniedAuditInterval = 10 * time.Minute
+)
+
+type auditState struct {
+	mu         sync.Mutex
+	lastPrune  time.Time
+	lastDenied map[string]time.Time
+}
+
+// Audit stores a security event. Time defaults to now. Errors are logged;
+// auditing never fails the request that triggered it.
+func (s *Server) Audit(ev types.AuditEvent) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := db.AddAuditEvent(ctx, &ev); err != nil {
+		if err != db.ErrNoDB {
+			slog.Error("Failed to record audit event", "type", ev.Type, "err", err)
+		}
+		return
+	}
+	s.pruneAuditIfDue(ctx)
+}
+
+// AuditIPDenied records a request rejected by the IP filter, at most once
+// per IP every few minutes.
+func (s *Server) AuditIPDenied(ip, path string) {
+	now := time.Now()
+	s.audit.mu.Lock()
+	if s.audit.lastDenied == nil {
+		s.audit.lastDenied = map[string]time.Time{}
+	}
+	if last, ok := s.audit.lastDenied[ip]; ok && now.Sub(last) < deniedAuditInterval {
+		s.audit.mu.Unlock()
+		return
+	}
+	for k, t := range s.audit.lastDenied {
+		if now.Sub(t) >= deniedAuditInterval {
+			delete(s.audit.lastDenied, k)
+		}
+	}
+	s.audit.lastDenied[ip] = now
+	s.audit.mu.Unlock()
+
+	s.Audit(types.AuditEvent{Time: now, Type: types.AuditIPDenied, IP: ip, Detail: path})
+}
+
+// auditNewDevice records the first request ever seen from ip.
+func (s *Server) auditNewDevice(ip, detail string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if seen, err := db.HasAuditEvent(ctx, types.AuditNewDevice, ip); err != nil || seen {
+		return
+	}
+	s.Audit(types.AuditEvent{Type: types.AuditNewDevice, IP: ip, Detail: detail})
+}
+
+func (s *Server) pruneAuditIfDue(ctx context.Context) {
+	s.audit.mu.Lock()
+	if time.Since(s.audit.lastPrune) < auditPruneInterval {
+		s.audit.mu.Unlock()
+		return
+	}
+	s.audit.lastPrune = time.Now()
+	s.audit.mu.Unlock()
+
+	// Age-based retention and the event cap apply independently
+	sec := s.Config().Security
+	if sec.AuditRetentionDays <= 0 && sec.AuditMaxEvents <= 0 {
+		return
+	}
+	var cutoff time.Time
+	if sec.AuditRetentionDays > 0 {
+		cutoff = time.Now().AddDate(0, 0, -sec.AuditRetentionDays)
+	}
+	if n, err := db.PruneAuditEvents(ctx, cutoff, sec.AuditMaxEvents); err != nil {
+		slog.Error("Failed to prune audit events", "err", err)
+	} else if n > 0 {
+		slog.Debug("Pruned audit events", "count", n)
+	}
+}
+
+// secretFields are reported as changed without their values.
+var secretFields = map[string]func(config.Config) string{
+	"security.pinHash":      func(c config.Config) string { return c.Security.PINHash },
+	"security.adminPinHash": func(c config.Config) string { return c.Security.AdminPINHash },
+	"security.linkSecret":   func(c config.Config) string { return c.Security.LinkSecret },
+}
+
+// ConfigDiff returns the changed config fields as dotted JSON paths
+// ("security.pinEnabled"); lists are compared as a whole. Secret values are
+// replaced by "***".
+func ConfigDiff(old, cur config.Config) []types.FieldChange {
+	a, b := map[string]any{}, map[string]any{}
+	flattenJSON("", toJSONMap(old.Redacted()), a)
+	flattenJSON("", toJSONMap(cur.Redacted()), b)
+
+	keys := map[string]bool{}
+	for k := range a {
+		keys[k] = true
+	}
+	for k := range b {
+		keys[k] = true
+	}
+	var out []types.FieldChange
+	for k := range keys {
+		if reflect.DeepEqual(a[k], b[k]) {
+			continue
+		}
+		out = append(out, types.FieldChange{Field: k, Old: encodeValue(a[k]), New: encodeValue(b[k])})
+	}
+	for field, get := range secretFields {
+		if get(old) != get(cur) {
+			out = append(out, types.FieldChange{Field: field, Old: "***", New: "***"})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Field < out[j].Field })
+	return out
+}
+
+func toJSONMap(v any) map[string]any {
+	b, _ := json.Marshal(v)
+	m := map[string]any{}
+	_ = json.Unmarshal(b, &m)
+	return m
+}
+
+func flattenJSON(prefix string, m map[string]any, out map[string]any) {
+	for k, v := range m {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		if sub, ok := v.(map[string]any); ok {
+			flattenJSON(key, sub, out)
+			continue
+		}
+		out[key] = v
+	}
+}
+
+func encodeValue(v any) string {
+	if v == nil {
+		return ""
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
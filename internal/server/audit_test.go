@@ -0,0 +1,41 @@
+package server
+
+import (
+	"testing"
+
+	"msp/internal/config"
+)
+
+func TestConfigDiff(t *testing.T) {
+	old := config.Default()
+	config.ApplyDefaults(&old)
+	old.Security.PINHash = "$argon2id$old"
+
+	cur := old
+	cur.Port = 9000
+	cur.Security.PINEnabled = true
+	cur.Security.PINHash = "$argon2id$new"
+	cur.Shares = []config.Share{{Label: "Movies", Path: "/srv/movies"}}
+
+	got := map[string][2]string{}
+	for _, c := range ConfigDiff(old, cur) {
+		got[c.Field] = [2]string{c.Old, c.New}
+	}
+	want := map[string][2]string{
+		"port":                {"8099", "9000"},
+		"security.pinEnabled": {"false", "true"},
+		"security.pinHash":    {"***", "***"},
+		"shares":              {"[]", `[{"label":"Movies","path":"/srv/movies"}]`},
+	}
+	if len(got) != len(want) {
+		t.Errorf("Expected %d changes, got %v", len(want), got)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s: got %v, want %v", k, got[k], v)
+		}
+	}
+	if len(ConfigDiff(old, old)) != 0 {
+		t.Error("Expected no changes for identical configs")
+	}
+}
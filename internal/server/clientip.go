@@ -0,0 +1,140 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"msp/internal/ipmatch"
+)
+
+// ClientIP returns the resolved client IP for r, honouring forwarded headers
+// only when the direct peer is listed in security.trustedProxies.
+func (s *Server) ClientIP(r *http.Request) string {
+	return ResolveClientIP(r, s.IPRules().TrustedProxies)
+}
+
+// ResolveClientIP extracts the client IP from r.
+//
+// Forwarded headers are ignored unless the direct peer (RemoteAddr) matches
+// trusted. When it does, the standard Forwarded header is preferred over
+// X-Forwarded-For, and the chain is walked right-to-left, skipping trusted
+// hops, so a client cannot spoof its address by prepending entries.
+// X-Real-IP is used as a last resort from a trusted peer.
+func ResolveClientIP(r *http.Request, trusted ipmatch.List) string {
+	peer := remoteHost(r.RemoteAddr)
+	peerAddr, ok := ipmatch.ParseAddr(peer)
+	if !ok {
+		return peer
+	}
+	if len(trusted) == 0 || !trusted.Contains(peerAddr) {
+		return peerAddr.String()
+	}
+
+	chain := forwardedChain(r.Header)
+	if len(chain) == 0 {
+		chain = xffChain(r.Header)
+	}
+	if len(chain) > 0 {
+		return walkChain(chain, peerAddr, trusted).String()
+	}
+
+	if xri, ok := ipmatch.ParseAddr(r.Header.Get("X-Real-IP")); ok {
+		return xri.String()
+	}
+	return peerAddr.String()
+}
+
+// walkChain walks hops right-to-left and returns the first untrusted one.
+// An unparseable hop ends the walk at the last trusted address.
+func walkChain(chain []string, peer netip.Addr, trusted ipmatch.List) netip.Addr {
+	last := peer
+	for i := len(chain) - 1; i >= 0; i-- {
+		addr, ok := ipmatch.ParseAddr(chain[i])
+		if !ok {
+			return last
+		}
+		if !trusted.Contains(addr) {
+			return addr
+		}
+		last = addr
+	}
+	return last
+}
+
+// forwardedChain parses RFC 7239 Forwarded headers into the list of for= hops.
+func forwardedChain(h http.Header) []string {
+	var out []string
+	for _, v := range h.Values("Forwarded") {
+		for _, elem := range strings.Split(v, ",") {
+			hop := ""
+			for _, pair := range strings.Split(elem, ";") {
+				k, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok || !strings.EqualFold(k, "for") {
+					continue
+				}
+				hop = stripForwardedPort(strings.Trim(strings.TrimSpace(val), `"`))
+			}
+			if strings.TrimSpace(elem) != "" {
+				out = append(out, hop)
+			}
+		}
+	}
+	return out
+}
+
+func xffChain(h http.Header) []string {
+	var out []string
+	for _, v := range h.Values("X-Forwarded-For") {
+		for _, part := range strings.Split(v, ",") {
+			if p := strings.TrimSpace(part); p != "" {
+				out = append(out, p)
+			}
+		}
+	}
+	return out
+}
+
+// stripForwardedPort removes an optional port from a Forwarded for= node,
+// e.g. "[2001:db8::1]:4711" or "192.0.2.1:80".
+func stripForwardedPort(node string) string {
+	if strings.HasPrefix(node, "[") {
+		if end := strings.Index(node, "]"); end > 0 {
+			return node[1:end]
+		}
+		return node
+	}
+	if strings.Count(node, ":") == 1 {
+		host, _, _ := strings.Cut(node, ":")
+		return host
+	}
+	return node
+}
+
+func remoteHost(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return strings.Trim(remoteAddr, "[]")
+	}
+	return host
+}
+
+// IsLocalRequest reports whether r comes from the local machine. A loopback
+// peer carrying forwarded headers that were not honoured (i.e. an untrusted
+// local reverse proxy) does not count, so remote clients behind it are not
+// mistaken for local ones.
+func (s *Server) IsLocalRequest(r *http.Request) bool {
+	addr, ok := ipmatch.ParseAddr(s.ClientIP(r))
+	if !ok || !addr.IsLoopback() {
+		return false
+	}
+	peer, ok := ipmatch.ParseAddr(remoteHost(r.RemoteAddr))
+	if ok && peer == addr {
+		h := r.Header
+		if h.Get("Forwarded") != "" || h.Get("X-Forwarded-For") != "" || h.Get("X-Real-IP") != "" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"msp/internal/ipmatch"
+)
+
+func TestResolveClientIP(t *testing.T) {
+	trusted, _ := ipmatch.ParseList([]string{"10.0.0.1", "172.16.0.0/12"})
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		trusted    ipmatch.List
+		headers    map[string]string
+		want       string
+	}{
+		{
+			name:       "X-Forwarded-For ignored without trusted proxies",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"X-Forwarded-For": "192.168.1.100"},
+			want:       "10.0.0.1",
+		},
+		{
+			name:       "X-Forwarded-For ignored from untrusted peer",
+			remoteAddr: "192.168.1.50:1234",
+			trusted:    trusted,
+			headers:    map[string]string{"X-Forwarded-For": "192.168.1.100"},
+			want:       "192.168.1.50",
+		},
+		{
+			name:       "X-Forwarded-For single IP",
+			remoteAddr: "10.0.0.1:1234",
+			trusted:    trusted,
+			headers:    map[string]string{"X-Forwarded-For": "192.168.1.100"},
+			want:       "192.168.1.100",
+		},
+		{
+			name:       "X-Forwarded-For spoofed prefix is skipped",
+			remoteAddr: "10.0.0.1:1234",
+			trusted:    trusted,
+			headers:    map[string]string{"X-Forwarded-For": "1.2.3.4, 192.168.1.100, 172.16.0.9"},
+			want:       "192.168.1.100",
+		},
+		{
+			name:       "Forwarded header preferred",
+			remoteAddr: "10.0.0.1:1234",
+			trusted:    trusted,
+			headers: map[string]string{
+				"Forwarded":       `for="[2001:db8:cafe::17]:4711";proto=http, for=172.16.0.9`,
+				"X-Forwarded-For": "192.168.1.100",
+			},
+			want: "2001:db8:cafe::17",
+		},
+		{
+			name:       "X-Real-IP from trusted peer",
+			remoteAddr: "10.0.0.1:1234",
+			trusted:    trusted,
+			headers:    map[string]string{"X-Real-IP": "192.168.1.100"},
+			want:       "192.168.1.100",
+		},
+		{
+			name:       "RemoteAddr only",
+			remoteAddr: "192.168.1.100:5678",
+			want:       "192.168.1.100",
+		},
+		{
+			name:       "IPv6 RemoteAddr",
+			remoteAddr: "[::1]:5678",
+			want:       "::1",
+		},
+		{
+			name:       "IPv4-mapped RemoteAddr",
+			remoteAddr: "[::ffff:192.168.1.7]:5678",
+			want:       "192.168.1.7",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+
+			if got := ResolveClientIP(req, tt.trusted); got != tt.want {
+				t.Errorf("ResolveClientIP() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"msp/internal/config"
+	"msp/internal/ipmatch"
+)
+
+// IPRules are the IP lists of the config, parsed once per config load so
+// requests do not parse them again.
+type IPRules struct {
+	Whitelist      ipmatch.List // security.ipWhitelist
+	Blacklist      ipmatch.List // security.ipBlacklist
+	TrustedProxies ipmatch.List // security.trustedProxies
+	MetricsAllow   ipmatch.List // metrics.allowIPs
+}
+
+// parseIPRules parses the IP lists of cfg. Invalid entries are rejected
+// when the config is loaded and skipped here.
+func parseIPRules(cfg config.Config) IPRules {
+	var r IPRules
+	r.Whitelist, _ = ipmatch.ParseList(cfg.Security.IPWhitelist)
+	r.Blacklist, _ = ipmatch.ParseList(cfg.Security.IPBlacklist)
+	r.TrustedProxies, _ = ipmatch.ParseList(cfg.Security.TrustedProxies)
+	r.MetricsAllow, _ = ipmatch.ParseList(cfg.Metrics.AllowIPs)
+	return r
+}
+
+// IPRules returns the parsed IP lists of the current config.
+func (s *Server) IPRules() IPRules {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ipRules
+}
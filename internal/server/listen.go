@@ -0,0 +1,71 @@
+package server
+
+import (
+	"net"
+
+	"msp/internal/util"
+)
+
+// listenInfo describes where the app is served, see SetListener.
+type listenInfo struct {
+	host string     // --listen address, "" for all interfaces
+	tls  bool       // HTTPS instead of HTTP
+	port func() int // port currently served; nil before SetListener
+}
+
+// SetListener records the listen address, whether it serves HTTPS and the
+// port it currently serves, for the URLs shown to users and same-origin
+// checks. Until it is called the server assumes HTTP on all interfaces on
+// the configured port.
+func (s *Server) SetListener(host string, tls bool, port func() int) {
+	s.mu.Lock()
+	s.listen = listenInfo{host: host, tls: tls, port: port}
+	s.mu.Unlock()
+}
+
+// Scheme returns "https" when the app is served over TLS, "http" otherwise.
+func (s *Server) Scheme() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.listen.tls {
+		return "https"
+	}
+	return "http"
+}
+
+// ListenPort returns the port the app is served on.
+func (s *Server) ListenPort() int {
+	s.mu.RLock()
+	port := s.listen.port
+	s.mu.RUnlock()
+	if port != nil {
+		if p := port(); p > 0 {
+			return p
+		}
+	}
+	return s.GetPort()
+}
+
+// URLs returns the addresses users can open the app at: the listen address,
+// or for all interfaces 127.0.0.1 and every LAN address.
+func (s *Server) URLs() []string {
+	s.mu.RLock()
+	host := s.listen.host
+	s.mu.RUnlock()
+	scheme, port := s.Scheme(), util.Itoa(s.ListenPort())
+
+	hosts := []string{host}
+	if IsWildcardHost(host) {
+		hosts = append([]string{"127.0.0.1"}, util.GetLanIPv4s()...)
+	}
+	urls := make([]string, 0, len(hosts))
+	for _, h := range hosts {
+		urls = append(urls, scheme+"://"+net.JoinHostPort(h, port)+"/")
+	}
+	return urls
+}
+
+// IsWildcardHost reports whether a listen address means all interfaces.
+func IsWildcardHost(host string) bool {
+	return host == "" || host == "0.0.0.0" || host == "::"
+}
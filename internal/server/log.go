@@ -0,0 +1,99 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"msp/internal/config"
+	"msp/internal/ipmatch"
+	"msp/internal/logging"
+	"msp/internal/util"
+)
+
+// logRotation converts the logRotate config section.
+func logRotation(c config.LogRotateConfig) logging.Rotation {
+	return logging.Rotation{
+		MaxSize:    int64(c.MaxSizeMB) * 1024 * 1024,
+		Daily:      c.Daily,
+		MaxBackups: c.MaxBackups,
+		MaxAge:     time.Duration(c.MaxAgeDays) * 24 * time.Hour,
+		Compress:   c.Compress,
+	}
+}
+
+// logPath returns the configured log file, or logs/msp.log in the data directory.
+func (s *Server) logPath() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	exeDir := util.MustExeDir()
+	dir := s.dataDir
+	if dir == "" {
+		dir = exeDir
+	}
+	def := filepath.Join(dir, "logs", "msp.log")
+	// Older versions saved the default log path beside the executable into
+	// the config file; it follows the data directory like an unset path.
+	if s.cfg.LogFile != "" && s.cfg.LogFile != filepath.Join(exeDir, "logs", "msp.log") {
+		return s.cfg.LogFile
+	}
+	return def
+}
+
+// SetupLogger opens the log file and makes it the destination of the
+// default slog logger, and thereby of the log package.
+func (s *Server) SetupLogger() {
+	if err := s.logs.Open(s.logPath(), logRotation(s.Config().LogRotate)); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open log file: %v\n", err)
+	}
+	s.applyLogSettings()
+}
+
+// applyLogSettings installs a default slog logger with the configured level
+// and format.
+func (s *Server) applyLogSettings() {
+	cfg := s.Config()
+	s.logLevel.Set(logging.ParseLevel(cfg.LogLevel))
+	slog.SetDefault(slog.New(logging.NewHandler(&s.logs, cfg.LogFormat, &s.logLevel)))
+}
+
+// CloseLog flushes and closes the log file; later output goes to stderr.
+func (s *Server) CloseLog() {
+	_ = s.logs.Close()
+}
+
+// LogRequest logs a finished request, and the first request of each new
+// non-loopback client IP.
+func (s *Server) LogRequest(r *http.Request, status int, start time.Time) {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	ua := strings.TrimSpace(r.UserAgent())
+	ip := s.ClientIP(r)
+	attrs := []slog.Attr{
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+		slog.Int("status", status),
+		slog.String("ip", ip),
+		slog.String("ua", ua),
+		slog.Duration("duration", time.Since(start)),
+	}
+
+	level := slog.LevelInfo
+	if status >= 500 {
+		level = slog.LevelError
+	}
+	slog.LogAttrs(r.Context(), level, "Request", attrs...)
+
+	if addr, ok := ipmatch.ParseAddr(ip); ok && !addr.IsLoopback() {
+		if _, seen := s.seenIPs.Load(ip); !seen {
+			s.seenIPs.Store(ip, true)
+			slog.LogAttrs(r.Context(), slog.LevelInfo, "New device", attrs...)
+			s.auditNewDevice(ip, ua)
+		}
+	}
+}
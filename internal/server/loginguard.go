@@ -0,0 +1,298 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"msp/internal/types"
+)
+
+const (
+	// attemptWindow is how long failed attempts from one IP are remembered.
+	attemptWindow = time.Hour
+	// globalWindow is the sliding window for the server-wide failure counter.
+	globalWindow = 10 * time.Minute
+	// maxBackoff caps the per-attempt delay before the lockout threshold.
+	maxBackoff = 30 * time.Second
+	// maxLockout caps repeated lockouts of the same IP.
+	maxLockout = 24 * time.Hour
+	// maxTrackedIPs bounds memory used by the per-IP table.
+	maxTrackedIPs = 10000
+)
+
+// loginGuard tracks failed PIN attempts per IP and target role and
+// globally, applying exponential backoff and temporary lockouts.
+type loginGuard struct {
+	mu                sync.Mutex
+	ips               map[attemptKey]*attemptState
+	global            []time.Time
+	globalLockedUntil time.Time
+	now               func() time.Time
+}
+
+// attemptKey separates the failures of one IP by the role the attempts
+// could have granted, so a success at one role cannot reset another.
+type attemptKey struct {
+	ip   string
+	role string
+}
+
+type attemptState struct {
+	fails       int
+	lockouts    int
+	lastFail    time.Time
+	nextAllowed time.Time
+	lockedUntil time.Time
+}
+
+// LoginLimits are the thresholds applied by the login guard.
+type LoginLimits struct {
+	MaxFailures       int
+	GlobalMaxFailures int
+	Lockout           time.Duration
+}
+
+// Lockout describes an IP (or the global counter, IP "*") that is currently blocked.
+type Lockout struct {
+	IP          string    `json:"ip"`
+	Role        string    `json:"role,omitempty"`
+	Failures    int       `json:"failures"`
+	LockedUntil time.Time `json:"lockedUntil"`
+}
+
+func newLoginGuard() *loginGuard {
+	return &loginGuard{ips: make(map[attemptKey]*attemptState), now: time.Now}
+}
+
+// check reports whether ip may attempt a login now, and if not, how long to
+// wait. The attempt's target is not known yet, so every role of ip counts.
+func (g *loginGuard) check(ip string) (time.Duration, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+
+	wait := time.Duration(0)
+	if g.globalLockedUntil.After(now) {
+		wait = g.globalLockedUntil.Sub(now)
+	}
+	for key := range g.ips {
+		if key.ip != ip {
+			continue
+		}
+		if st := g.state(key, now, false); st != nil {
+			for _, t := range []time.Time{st.lockedUntil, st.nextAllowed} {
+				if d := t.Sub(now); d > wait {
+					wait = d
+				}
+			}
+		}
+	}
+	return wait, wait <= 0
+}
+
+// fail records a failed attempt at role. It returns the resulting failure
+// count and the lockout end time when this failure triggered a lockout.
+func (g *loginGuard) fail(ip, role string, lim LoginLimits) (fails int, lockedUntil time.Time, global bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+
+	st := g.state(attemptKey{ip, role}, now, true)
+	st.fails++
+	st.lastFail = now
+
+	if st.fails >= lim.MaxFailures {
+		st.lockouts++
+		d := maxLockout
+		if st.lockouts <= 16 {
+			d = lim.Lockout << (st.lockouts - 1)
+		}
+		if d > maxLockout || d <= 0 {
+			d = maxLockout
+		}
+		st.lockedUntil = now.Add(d)
+		st.fails = 0
+		lockedUntil = st.lockedUntil
+	} else {
+		d := time.Second << (st.fails - 1)
+		if d > maxBackoff {
+			d = maxBackoff
+		}
+		st.nextAllowed = now.Add(d)
+	}
+	fails = st.fails
+
+	// Global sliding window
+	cut := 0
+	for cut < len(g.global) && now.Sub(g.global[cut]) > globalWindow {
+		cut++
+	}
+	g.global = append(g.global[cut:], now)
+	if len(g.global) >= lim.GlobalMaxFailures && !g.globalLockedUntil.After(now) {
+		g.globalLockedUntil = now.Add(lim.Lockout)
+		g.global = g.global[:0]
+		global = true
+	}
+	return fails, lockedUntil, global
+}
+
+// success resets the failure count of ip at role. The lockout count is kept
+// so repeated lockouts still escalate until the state ages out.
+func (g *loginGuard) success(ip, role string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if st := g.state(attemptKey{ip, role}, g.now(), false); st != nil {
+		st.fails = 0
+		st.nextAllowed = time.Time{}
+	}
+}
+
+// clear removes lockouts for ip, or every lockout (including global) when ip is empty.
+func (g *loginGuard) clear(ip string) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if ip != "" {
+		n := 0
+		for key := range g.ips {
+			if key.ip == ip {
+				delete(g.ips, key)
+				n++
+			}
+		}
+		return n
+	}
+	n := len(g.ips)
+	g.ips = make(map[attemptKey]*attemptState)
+	g.global = nil
+	if !g.globalLockedUntil.IsZero() {
+		g.globalLockedUntil = time.Time{}
+		n++
+	}
+	return n
+}
+
+func (g *loginGuard) lockouts() []Lockout {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+
+	out := []Lockout{}
+	if g.globalLockedUntil.After(now) {
+		out = append(out, Lockout{IP: "*", LockedUntil: g.globalLockedUntil})
+	}
+	for key, st := range g.ips {
+		if st.lockedUntil.After(now) || st.nextAllowed.After(now) {
+			until := st.lockedUntil
+			if st.nextAllowed.After(until) {
+				until = st.nextAllowed
+			}
+			out = append(out, Lockout{IP: key.ip, Role: key.role, Failures: st.fails, LockedUntil: until})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].IP != out[j].IP {
+			return out[i].IP < out[j].IP
+		}
+		return out[i].Role < out[j].Role
+	})
+	return out
+}
+
+// state returns the tracked state for key, dropping it once it has aged out.
+func (g *loginGuard) state(key attemptKey, now time.Time, create bool) *attemptState {
+	st, ok := g.ips[key]
+	if ok && now.Sub(st.lastFail) > attemptWindow && !st.lockedUntil.After(now) {
+		delete(g.ips, key)
+		st, ok = nil, false
+	}
+	if !ok && create {
+		if len(g.ips) >= maxTrackedIPs {
+			g.prune(now)
+		}
+		st = &attemptState{}
+		g.ips[key] = st
+	}
+	return st
+}
+
+func (g *loginGuard) prune(now time.Time) {
+	for key, st := range g.ips {
+		if now.Sub(st.lastFail) > attemptWindow && !st.lockedUntil.After(now) {
+			delete(g.ips, key)
+		}
+	}
+}
+
+func (s *Server) loginLimits() LoginLimits {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sec := s.cfg.Security
+	lim := LoginLimits{
+		MaxFailures:       sec.MaxFailedAttempts,
+		GlobalMaxFailures: sec.GlobalMaxFailedAttempts,
+		Lockout:           time.Duration(sec.LockoutMinutes) * time.Minute,
+	}
+	if lim.MaxFailures <= 0 {
+		lim.MaxFailures = 5
+	}
+	if lim.GlobalMaxFailures <= 0 {
+		lim.GlobalMaxFailures = 50
+	}
+	if lim.Lockout <= 0 {
+		lim.Lockout = 15 * time.Minute
+	}
+	return lim
+}
+
+// CheckLogin reports whether ip may try a PIN now; otherwise it returns the
+// remaining wait time.
+func (s *Server) CheckLogin(ip string) (time.Duration, bool) {
+	return s.guard.check(ip)
+}
+
+// RecordLoginFailure counts a failed PIN attempt at role (the highest role
+// the credential could have granted) and logs and audits it. actor is the
+// user name tried, if any.
+func (s *Server) RecordLoginFailure(ip, role, actor string) {
+	fails, lockedUntil, global := s.guard.fail(ip, role, s.loginLimits())
+	detail := fmt.Sprintf("attempts=%d", fails)
+	if !lockedUntil.IsZero() {
+		detail += " locked until " + lockedUntil.Format(time.RFC3339)
+	}
+	s.Audit(types.AuditEvent{Type: types.AuditLoginFailed, IP: ip, Actor: actor, Detail: detail})
+	if !lockedUntil.IsZero() {
+		slog.Warn("PIN lockout", "ip", ip, "role", role, "until", lockedUntil)
+	} else {
+		slog.Info("PIN failed", "ip", ip, "role", role, "attempts", fails)
+	}
+	if global {
+		slog.Warn("Too many failed PIN attempts globally, PIN login temporarily locked")
+	}
+}
+
+// RecordLoginSuccess resets the failure counter of ip at role after an
+// interactive login. Per-request credentials (X-PIN, API tokens) must not
+// call it, or one valid low-privilege request would undo the throttling of
+// guesses in between.
+func (s *Server) RecordLoginSuccess(ip, role string) {
+	s.guard.success(ip, role)
+}
+
+// Lockouts returns the IPs currently blocked from PIN login.
+func (s *Server) Lockouts() []Lockout {
+	return s.guard.lockouts()
+}
+
+// ClearLockouts lifts the lockout for ip, or all lockouts when ip is empty.
+func (s *Server) ClearLockouts(ip string) int {
+	n := s.guard.clear(ip)
+	target := ip
+	if target == "" {
+		target = "all"
+	}
+	slog.Info("Lockouts cleared", "target", target, "count", n)
+	return n
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginGuard(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	g := newLoginGuard()
+	g.now = func() time.Time { return now }
+	lim := LoginLimits{MaxFailures: 3, GlobalMaxFailures: 100, Lockout: time.Minute}
+
+	if _, ok := g.check("1.1.1.1"); !ok {
+		t.Fatal("Expected fresh IP to be allowed")
+	}
+
+	// First failure: 1s backoff
+	g.fail("1.1.1.1", "admin", lim)
+	if wait, ok := g.check("1.1.1.1"); ok || wait != time.Second {
+		t.Fatalf("Expected 1s backoff, got %v ok=%v", wait, ok)
+	}
+	now = now.Add(time.Second)
+
+	// Second failure: 2s backoff
+	g.fail("1.1.1.1", "admin", lim)
+	if wait, _ := g.check("1.1.1.1"); wait != 2*time.Second {
+		t.Fatalf("Expected 2s backoff, got %v", wait)
+	}
+	now = now.Add(2 * time.Second)
+
+	// Third failure: lockout
+	_, until, _ := g.fail("1.1.1.1", "admin", lim)
+	if until.Sub(now) != time.Minute {
+		t.Fatalf("Expected 1m lockout, got %v", until.Sub(now))
+	}
+	if _, ok := g.check("2.2.2.2"); !ok {
+		t.Error("Other IPs must not be affected")
+	}
+	if n := len(g.lockouts()); n != 1 {
+		t.Errorf("Expected 1 lockout, got %d", n)
+	}
+
+	// Clearing lifts the lockout
+	g.clear("1.1.1.1")
+	if _, ok := g.check("1.1.1.1"); !ok {
+		t.Error("Expected lockout to be cleared")
+	}
+}
+
+func TestLoginGuardGlobal(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	g := newLoginGuard()
+	g.now = func() time.Time { return now }
+	lim := LoginLimits{MaxFailures: 100, GlobalMaxFailures: 3, Lockout: time.Minute}
+
+	g.fail("1.1.1.1", "admin", lim)
+	g.fail("2.2.2.2", "admin", lim)
+	_, _, global := g.fail("3.3.3.3", "admin", lim)
+	if !global {
+		t.Fatal("Expected global lockout")
+	}
+	if _, ok := g.check("4.4.4.4"); ok {
+		t.Error("Expected all IPs to be blocked during global lockout")
+	}
+	g.clear("")
+	if _, ok := g.check("4.4.4.4"); !ok {
+		t.Error("Expected global lockout to be cleared")
+	}
+}
+
+func TestLoginGuardRoles(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	g := newLoginGuard()
+	g.now = func() time.Time { return now }
+	lim := LoginLimits{MaxFailures: 2, GlobalMaxFailures: 100, Lockout: time.Minute}
+
+	// A viewer success must not reset failures counted against the admin role
+	g.fail("1.1.1.1", "admin", lim)
+	now = now.Add(time.Second)
+	g.success("1.1.1.1", "viewer")
+	_, until, _ := g.fail("1.1.1.1", "admin", lim)
+	if until.IsZero() {
+		t.Fatal("Expected lockout despite viewer success in between")
+	}
+	if _, ok := g.check("1.1.1.1"); ok {
+		t.Error("Expected IP to be blocked for every role")
+	}
+
+	// An admin success resets the count but not the lockout escalation
+	now = until
+	g.success("1.1.1.1", "admin")
+	g.fail("1.1.1.1", "admin", lim)
+	now = now.Add(time.Second)
+	_, until, _ = g.fail("1.1.1.1", "admin", lim)
+	if d := until.Sub(now); d != 2*time.Minute {
+		t.Errorf("Expected second lockout to double to 2m, got %v", d)
+	}
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"msp/internal/config"
+	"msp/internal/ipmatch"
+	"msp/internal/util"
+)
+
+// knownHostsTTL is how long local host names and LAN addresses are cached.
+const knownHostsTTL = time.Minute
+
+type hostCache struct {
+	mu    sync.Mutex
+	hosts map[string]bool
+	at    time.Time
+}
+
+var localHosts hostCache
+
+// knownHost reports whether host (lower-case, without port) names this
+// machine: localhost, a loopback or LAN address, or the machine's host name.
+func knownHost(host string) bool {
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	if addr, ok := ipmatch.ParseAddr(host); ok && addr.IsLoopback() {
+		return true
+	}
+
+	localHosts.mu.Lock()
+	defer localHosts.mu.Unlock()
+	if localHosts.hosts == nil || time.Since(localHosts.at) > knownHostsTTL {
+		hosts := map[string]bool{}
+		for _, ip := range util.GetLanIPv4s() {
+			hosts[ip] = true
+		}
+		if h, err := os.Hostname(); err == nil && h != "" {
+			h = strings.ToLower(h)
+			hosts[h] = true
+			hosts[h+".local"] = true
+		}
+		localHosts.hosts = hosts
+		localHosts.at = time.Now()
+	}
+	return localHosts.hosts[host]
+}
+
+// OriginAllowed reports whether a browser origin ("scheme://host[:port]",
+// from the Origin header or a Referer) belongs to this server: the scheme
+// and port it is served on with a local host name, loopback or LAN address,
+// an entry of security.allowedOrigins, or, for requests relayed by a
+// trusted proxy, the Host the proxy forwarded.
+//
+// The Host header alone is not trusted for direct requests, so a page on a
+// DNS-rebound domain cannot pass as same-origin.
+func (s *Server) OriginAllowed(r *http.Request, origin string) bool {
+	norm := config.NormalizeOrigin(origin)
+	if norm == "" {
+		return false
+	}
+	if s.CORSAllowed(norm) {
+		return true
+	}
+	u, _ := url.Parse(norm)
+	if u.Scheme == s.Scheme() && originPort(u) == s.ListenPort() && knownHost(u.Hostname()) {
+		return true
+	}
+	return s.fromTrustedProxy(r) && strings.EqualFold(u.Host, r.Host)
+}
+
+// originPort returns the port of a normalized origin, defaulting by scheme.
+func originPort(u *url.URL) int {
+	if p := u.Port(); p != "" {
+		n, _ := strconv.Atoi(p)
+		return n
+	}
+	if u.Scheme == "https" {
+		return 443
+	}
+	return 80
+}
+
+// CORSAllowed reports whether origin is listed in security.allowedOrigins and
+// may therefore make credentialed cross-origin requests.
+func (s *Server) CORSAllowed(origin string) bool {
+	norm := config.NormalizeOrigin(origin)
+	if norm == "" {
+		return false
+	}
+	s.mu.RLock()
+	allowed := s.cfg.Security.AllowedOrigins
+	s.mu.RUnlock()
+	for _, o := range allowed {
+		if config.NormalizeOrigin(o) == norm {
+			return true
+		}
+	}
+	return false
+}
+
+// fromTrustedProxy reports whether the direct peer is a trusted proxy.
+func (s *Server) fromTrustedProxy(r *http.Request) bool {
+	peer, ok := ipmatch.ParseAddr(remoteHost(r.RemoteAddr))
+	return ok && s.IPRules().TrustedProxies.Contains(peer)
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"msp/internal/config"
+	"msp/internal/db"
+	"msp/internal/types"
+)
+
+// reloadDelay coalesces the burst of events an editor produces when saving.
+const reloadDelay = 200 * time.Millisecond
+
+// configReactions apply changed config sections to the running server.
+// Settings that are read on every request (IP lists, PIN, log level, ...)
+// need no reaction.
+var configReactions = []struct {
+	section string
+	changed func(old, cfg config.Config) bool
+	apply   func(s *Server, old, cfg config.Config)
+}{
+	{
+		section: "media",
+		changed: func(old, cfg config.Config) bool {
+			return !reflect.DeepEqual(old.Shares, cfg.Shares) ||
+				!reflect.DeepEqual(old.Blacklist, cfg.Blacklist) ||
+				old.MaxItems != cfg.MaxItems
+		},
+		apply: func(s *Server, _, _ config.Config) {
+			s.InvalidateMediaCache()
+			slog.Info("Shares or blacklist changed, media index invalidated")
+		},
+	},
+	{
+		section: "log",
+		changed: func(old, cfg config.Config) bool {
+			return old.LogFile != cfg.LogFile || old.LogLevel != cfg.LogLevel ||
+				old.LogFormat != cfg.LogFormat || old.LogRotate != cfg.LogRotate
+		},
+		apply: func(s *Server, old, cfg config.Config) {
+			if old.LogFile == cfg.LogFile {
+				s.logs.SetRotation(logRotation(cfg.LogRotate))
+				s.applyLogSettings()
+				return
+			}
+			s.SetupLogger()
+			slog.Info("Log file switched", "path", s.logPath())
+		},
+	},
+	{
+		section: "tls",
+		changed: func(old, cfg config.Config) bool { return old.TLS != cfg.TLS },
+		apply: func(s *Server, _, _ config.Config) {
+			slog.Info("TLS settings changed, restart to apply them")
+		},
+	},
+}
+
+// OnConfigChange registers fn to be called with the previous and the new
+// effective config after every change, whether made through the API or by
+// editing the config file. Use it for reactions outside this package, such
+// as moving the listener to a new port.
+func (s *Server) OnConfigChange(fn func(old, cfg config.Config)) {
+	s.mu.Lock()
+	s.onChange = append(s.onChange, fn)
+	s.mu.Unlock()
+}
+
+// applyConfigChanges runs the reactions for every section that differs
+// between old and cfg. It must be called without s.mu held.
+func (s *Server) applyConfigChanges(old, cfg config.Config) {
+	for _, r := range configReactions {
+		if r.changed(old, cfg) {
+			r.apply(s, old, cfg)
+		}
+	}
+	s.mu.RLock()
+	hooks := s.onChange
+	s.mu.RUnlock()
+	for _, fn := range hooks {
+		fn(old, cfg)
+	}
+}
+
+// WatchConfig reloads the config file whenever it changes until ctx is
+// done. It watches the file's directory, so editors that save by replacing
+// the file are noticed too. If file events are unavailable it falls back to
+// checking the file every 2 seconds.
+func (s *Server) WatchConfig(ctx context.Context) {
+	w, err := fsnotify.NewWatcher()
+	if err == nil {
+		err = w.Add(filepath.Dir(s.cfgPath))
+	}
+	if err != nil {
+		slog.Warn("Config file events unavailable, polling instead", "err", err)
+		if w != nil {
+			_ = w.Close()
+		}
+		s.pollConfig(ctx, 2*time.Second)
+		return
+	}
+	defer func() { _ = w.Close() }()
+
+	name := filepath.Clean(s.cfgPath)
+	timer := time.NewTimer(reloadDelay)
+	timer.Stop()
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(ev.Name) == name && ev.Has(fsnotify.Write|fsnotify.Create|fsnotify.Rename) {
+				timer.Reset(reloadDelay)
+			}
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			slog.Error("Config watcher failed", "err", err)
+		case <-timer.C:
+			s.reloadAndLog()
+		}
+	}
+}
+
+func (s *Server) pollConfig(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.reloadAndLog()
+		}
+	}
+}
+
+func (s *Server) reloadAndLog() {
+	if err := s.ReloadConfig(); err != nil {
+		slog.Error("Invalid config, keeping previous", "err", err)
+	}
+}
+
+// ReloadConfig re-reads the config file and applies what changed. Content
+// the server wrote itself, or that already failed to load, is ignored.
+func (s *Server) ReloadConfig() error {
+	b, err := os.ReadFile(s.cfgPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil // mid-replace; the next event brings the new file
+		}
+		return err
+	}
+	s.mu.RLock()
+	seen := bytes.Equal(b, s.fileData) || bytes.Equal(b, s.rejectedData)
+	env := s.env
+	s.mu.RUnlock()
+	if seen {
+		return nil
+	}
+
+	base, cfg, j, rewrite, err := s.loadConfigBytes(b, env)
+	if err != nil {
+		s.mu.Lock()
+		s.rejectedData = b
+		s.mu.Unlock()
+		return err
+	}
+
+	s.mu.Lock()
+	old := s.cfg
+	s.cfg, s.fileCfg, s.fileData, s.fileJSON, s.rejectedData = cfg, base, b, j, nil
+	s.ipRules = parseIPRules(cfg)
+	var saveErr error
+	if rewrite {
+		// Persist hashes/keys so no plaintext PIN stays on disk, and
+		// migrated files in the current schema
+		saveErr = s.saveConfigLocked()
+	}
+	s.mu.Unlock()
+	if saveErr != nil {
+		slog.Error("Failed to save config", "err", saveErr)
+	}
+
+	changes := ConfigDiff(old, cfg)
+	if len(changes) == 0 {
+		return nil
+	}
+	slog.Info("Config file changed, reloaded")
+	s.Audit(types.AuditEvent{Type: types.AuditConfigChange, Actor: "config file", Changes: changes})
+	s.applyConfigChanges(old, cfg)
+
+	// A credential replaced in the file (e.g. a forgotten PIN) must not
+	// leave sessions signed in with the old one.
+	if old.Security.PINHash != cfg.Security.PINHash || old.Security.AdminPINHash != cfg.Security.AdminPINHash {
+		if n, err := db.DeleteSessionsExcept(context.Background(), ""); err == nil && n > 0 {
+			slog.Info("PIN changed in config file, sessions signed out", "count", n)
+		}
+	}
+	return nil
+}
+
+// loadConfigBytes decodes config file contents and returns the file layer,
+// the effective config and the file as JSON. rewrite reports whether the
+// file should be saved again (migrated, or secrets hashed or generated).
+func (s *Server) loadConfigBytes(b []byte, env config.Env) (base, cfg config.Config, j []byte, rewrite bool, err error) {
+	base, j, migrated, err := s.decodeConfig(b)
+	if err != nil {
+		return base, cfg, nil, false, err
+	}
+	changed := config.ApplyDefaults(&base) || migrated
+	prepared, err := config.PrepareSecrets(&base)
+	if err != nil {
+		return base, cfg, nil, false, err
+	}
+	cfg, err = withEnv(env, base)
+	return base, cfg, j, changed || prepared, err
+}
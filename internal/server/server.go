@@ -6,30 +6,39 @@ import (
 	"errors"
 	"fmt"
 	"hash/fnv"
-	"log"
-	"net"
-	"net/http"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"runtime/debug"
 	"sort"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"msp/internal/config"
 	"msp/internal/db"
+	"msp/internal/logging"
 	"msp/internal/media"
+	"msp/internal/metrics"
 	"msp/internal/types"
 	"msp/internal/util"
 )
 
 type Server struct {
-	mu         sync.RWMutex
-	cfg        config.Config
-	cfgPath    string
-	cfgModTime time.Time // Last modification time of config file
+	mu       sync.RWMutex
+	cfg      config.Config // effective config: file values with env overrides
+	fileCfg  config.Config // what the config file holds
+	fileData []byte        // last config file contents as read or written
+	fileJSON []byte        // fileData as JSON, for ConfigSources
+	format   config.Format // format of the config file
+	env      config.Env
+	ipRules  IPRules // parsed IP lists of cfg, see IPRules
+	cfgPath  string
+	dataDir  string     // Directory for logs and other runtime data
+	listen   listenInfo // see SetListener
+
+	rejectedData []byte                         // last config file contents that failed to load
+	onChange     []func(old, cfg config.Config) // see OnConfigChange
 
 	mediaCachePath string
 
@@ -39,72 +48,172 @@ type Server struct {
 	mediaBuiltAt  time.Time
 	mediaTTL      time.Duration
 	mediaRespJSON []byte
-	mediaETag     string
+	mediaTag      string // version of mediaRespJSON
 	mediaBuilding bool
+	mediaCounts   types.ItemCounts
+	lastScanAt    time.Time // start of the last completed scan
+	lastScanDur   time.Duration
 
-	seenIPs sync.Map
-	logMu   sync.Mutex
-	logFile *os.File
-	logCnt  int32
-}
+	startedAt time.Time
+	version   string
 
-const (
-	LogLevelDebug = "debug"
-	LogLevelInfo  = "info"
-	LogLevelError = "error"
-	LogLevelNone  = "none"
-)
+	ctx context.Context // background work, see SetContext
+	bg  sync.WaitGroup
+
+	seenIPs  sync.Map
+	guard    *loginGuard
+	audit    auditState
+	logs     logging.File
+	logLevel slog.LevelVar
+}
 
 func New(cfgPath string) *Server {
 	s := &Server{
 		cfgPath:        cfgPath,
+		format:         config.FormatOf(cfgPath),
 		mediaCachePath: cfgPath + ".media_cache.json",
+		guard:          newLoginGuard(),
+		ctx:            context.Background(),
+		startedAt:      time.Now(),
 	}
 	s.mediaTTL = 2 * time.Minute
 	s.mediaCond = sync.NewCond(&s.mediaMu)
 	return s
 }
 
+// SetDataDir sets the directory used for the default log file. It defaults
+// to the executable's directory.
+func (s *Server) SetDataDir(dir string) {
+	s.mu.Lock()
+	s.dataDir = dir
+	s.mu.Unlock()
+}
+
+// SetContext sets the context of background work such as media scans;
+// cancelling it stops them. It must be called before the server starts.
+func (s *Server) SetContext(ctx context.Context) {
+	s.ctx = ctx
+}
+
+// Go runs fn in a goroutine with the background context. Wait waits for it.
+func (s *Server) Go(fn func(ctx context.Context)) {
+	s.bg.Add(1)
+	go func() {
+		defer s.bg.Done()
+		fn(s.ctx)
+	}()
+}
+
+// Wait blocks until all background work has returned.
+func (s *Server) Wait() {
+	s.bg.Wait()
+}
+
+// SetCacheDir sets the directory of the on-disk media cache, which is only
+// used when the database is unavailable. It defaults to the config file's
+// directory and must be set before the server starts.
+func (s *Server) SetCacheDir(dir string) {
+	s.mediaCachePath = filepath.Join(dir, "media_cache.json")
+}
+
+// LoadOrInitConfig loads the config file, creating it with defaults if it
+// does not exist, and applies the MSP_* environment overrides on top.
 func (s *Server) LoadOrInitConfig() error {
+	env, err := config.ParseEnv(os.Environ())
+	if err != nil {
+		return err
+	}
+
 	b, err := os.ReadFile(s.cfgPath)
 	if err != nil {
 		if !errors.Is(err, os.ErrNotExist) {
 			return err
 		}
-		cfg := config.Default()
+		base := config.Default()
+		if _, err := config.PrepareSecrets(&base); err != nil {
+			return err
+		}
+		cfg, err := withEnv(env, base)
+		if err != nil {
+			return err
+		}
 		s.mu.Lock()
-		s.cfg = cfg
-		s.mu.Unlock()
+		defer s.mu.Unlock()
+		s.env, s.fileCfg, s.cfg = env, base, cfg
+		s.ipRules = parseIPRules(cfg)
 		return s.saveConfigLocked()
 	}
 
-	// Get file modification time
-	stat, err := os.Stat(s.cfgPath)
-	if err == nil {
-		s.mu.Lock()
-		s.cfgModTime = stat.ModTime()
-		s.mu.Unlock()
+	base, cfg, j, changed, err := s.loadConfigBytes(b, env)
+	if err != nil {
+		return fmt.Errorf("invalid config %s: %w", s.cfgPath, err)
 	}
-
-	var cfg config.Config
-	if err := json.Unmarshal(b, &cfg); err != nil {
-		return err
+	if changed {
+		slog.Info("Config updated with default values or hashed secrets and saved to disk")
 	}
-
-	changed := config.ApplyDefaults(&cfg)
-
 	s.mu.Lock()
-	s.cfg = cfg
-	s.mu.Unlock()
+	defer s.mu.Unlock()
+	s.env, s.fileCfg, s.cfg, s.fileData, s.fileJSON = env, base, cfg, b, j
+	s.ipRules = parseIPRules(cfg)
 	if changed {
-		s.Log(LogLevelInfo, "Config updated with default values and saved to disk")
 		return s.saveConfigLocked()
 	}
 	return nil
 }
 
+// decodeConfig decodes the config file content b, upgrading it to the
+// current schema. Before the first migration it copies the original file to
+// <config>.v<N>.bak (with a timestamp if that exists already) and logs each
+// applied migration. It also returns b as JSON and whether b was migrated.
+func (s *Server) decodeConfig(b []byte) (config.Config, []byte, bool, error) {
+	cfg, j, applied, err := config.Load(b, s.format)
+	if err != nil || len(applied) == 0 {
+		return cfg, j, false, err
+	}
+	backup := fmt.Sprintf("%s.v%d.bak", s.cfgPath, applied[0].From)
+	if _, err := os.Stat(backup); err == nil {
+		backup = fmt.Sprintf("%s.v%d-%s.bak", s.cfgPath, applied[0].From, time.Now().Format("20060102-150405"))
+	}
+	if err := os.WriteFile(backup, b, 0600); err != nil {
+		return config.Config{}, nil, false, fmt.Errorf("backing up config before migration: %w", err)
+	}
+	slog.Info("Config backed up before migration", "path", backup)
+	for _, m := range applied {
+		slog.Info("Config migrated", "from", m.From, "to", m.From+1, "migration", m.Description)
+	}
+	return cfg, j, true, nil
+}
+
+// withEnv returns the effective config for the file config base.
+func withEnv(env config.Env, base config.Config) (config.Config, error) {
+	cfg := base
+	if err := env.Apply(&cfg); err != nil {
+		return config.Config{}, err
+	}
+	config.ApplyDefaults(&cfg)
+	if err := config.Validate(cfg); err != nil {
+		return config.Config{}, err
+	}
+	// Clashing shares saved by older versions must not stop the server.
+	for _, f := range config.FieldErrors(config.ValidateShares(cfg.Shares, nil)) {
+		slog.Warn("Config share clash", "field", f.Field, "problem", f.Message)
+	}
+	if _, err := config.PrepareSecrets(&cfg); err != nil {
+		return config.Config{}, err
+	}
+	return cfg, nil
+}
+
+// saveConfigLocked writes s.cfg to the config file in its format, keeping
+// the file's own values for fields overridden by the environment.
 func (s *Server) saveConfigLocked() error {
-	b, err := json.MarshalIndent(s.cfg, "", "  ")
+	file := s.cfg
+	s.env.Restore(&file, s.fileCfg)
+	b, err := config.Encode(file, s.format, s.fileData)
+	if err != nil {
+		return err
+	}
+	j, err := json.Marshal(file)
 	if err != nil {
 		return err
 	}
@@ -113,7 +222,12 @@ func (s *Server) saveConfigLocked() error {
 	if err := os.WriteFile(tmp, b, 0600); err != nil {
 		return err
 	}
-	return os.Rename(tmp, s.cfgPath)
+	if err := os.Rename(tmp, s.cfgPath); err != nil {
+		return err
+	}
+	s.fileCfg = file
+	s.fileData, s.fileJSON = b, j
+	return nil
 }
 
 func (s *Server) Config() config.Config {
@@ -122,185 +236,48 @@ func (s *Server) Config() config.Config {
 	return s.cfg
 }
 
+// UpdateConfig applies fn to the config, saves it and applies the changes
+// to the running server. Fields overridden by environment variables keep
+// their values.
 func (s *Server) UpdateConfig(fn func(*config.Config)) error {
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	prev := s.cfg
 	fn(&s.cfg)
-	return s.saveConfigLocked()
-}
-
-// WatchConfig monitors the config file for changes and reloads it automatically
-func (s *Server) WatchConfig(ctx context.Context) {
-	ticker := time.NewTicker(2 * time.Second) // Check every 2 seconds
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			stat, err := os.Stat(s.cfgPath)
-			if err != nil {
-				continue
-			}
-
-			s.mu.RLock()
-			lastModTime := s.cfgModTime
-			s.mu.RUnlock()
-
-			// Check if file has been modified
-			if stat.ModTime().After(lastModTime) {
-				s.Log("info", "Config file changed, reloading...")
-
-				// Read and parse new config
-				b, err := os.ReadFile(s.cfgPath)
-				if err != nil {
-					s.Log("error", fmt.Sprintf("Failed to read config file: %v", err))
-					continue
-				}
-
-				var cfg config.Config
-				if err := json.Unmarshal(b, &cfg); err != nil {
-					s.Log("error", fmt.Sprintf("Failed to parse config file: %v", err))
-					continue
-				}
-
-				config.ApplyDefaults(&cfg)
-
-				// Update config
-				s.mu.Lock()
-				s.cfg = cfg
-				s.cfgModTime = stat.ModTime()
-				s.mu.Unlock()
-
-				s.Log("info", "Config reloaded successfully")
-			}
-		}
-	}
-}
-
-func (s *Server) SetupLogger() {
-	s.mu.Lock()
-	if s.cfg.LogFile == "" {
-		s.cfg.LogFile = filepath.Join(util.MustExeDir(), "logs", "msp.log")
-	}
-	logFile := s.cfg.LogFile
+	s.env.Restore(&s.cfg, prev)
+	s.ipRules = parseIPRules(s.cfg)
+	err := s.saveConfigLocked()
+	cfg := s.cfg
 	s.mu.Unlock()
-
-	// Ensure directory exists
-	if err := os.MkdirAll(filepath.Dir(logFile), 0750); err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to create log directory: %v\n", err)
-		return
-	}
-
-	s.logMu.Lock()
-	defer s.logMu.Unlock()
-
-	if s.logFile != nil {
-		_ = s.logFile.Close()
-	}
-
-	//nolint:gosec // Log file path is controlled by config/CLI
-	f, err := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to open log file: %v\n", err)
-		return
+		return err
 	}
-	s.logFile = f
-	log.SetOutput(f)
-	log.SetFlags(log.LstdFlags | log.Lmicroseconds)
+	s.applyConfigChanges(prev, cfg)
+	return nil
 }
 
-func (s *Server) Log(level string, msg string) {
+// Env returns the environment layer of the config.
+func (s *Server) Env() config.Env {
 	s.mu.RLock()
-	cfgLevel := strings.ToLower(s.cfg.LogLevel)
-	s.mu.RUnlock()
-
-	// Level priority: error > info > debug
-	shouldLog := false
-	switch strings.ToLower(level) {
-	case LogLevelError:
-		shouldLog = cfgLevel != LogLevelNone
-	case LogLevelInfo:
-		shouldLog = cfgLevel == LogLevelInfo || cfgLevel == LogLevelDebug
-	case LogLevelDebug:
-		shouldLog = cfgLevel == LogLevelDebug
-	}
-
-	if shouldLog {
-		ts := time.Now().Format("2006/01/02 15:04:05.000000")
-		line := fmt.Sprintf("%s [%s] %s", ts, strings.ToUpper(level), msg)
-		log.Println(line)
-
-		// Rotate only every 100 logs or so to reduce Stat overhead
-		if cnt := atomic.AddInt32(&s.logCnt, 1); cnt%100 == 0 {
-			s.RotateLogIfNeeded()
-		}
-	}
+	defer s.mu.RUnlock()
+	return s.env
 }
 
-func (s *Server) RotateLogIfNeeded() {
-	s.logMu.Lock()
-	defer s.logMu.Unlock()
-
-	if s.logFile == nil {
-		return
-	}
-
-	st, err := s.logFile.Stat()
-	if err != nil {
-		return
-	}
-
-	// 10MB limit
-	if st.Size() < 10*1024*1024 {
-		return
-	}
-
-	_ = s.logFile.Close()
-	s.logFile = nil
-
+// EnvVar returns the environment variable that overrides the config field
+// at a JSON path ("port", "security.pin"), or "" if the field is not overridden.
+func (s *Server) EnvVar(path string) string {
 	s.mu.RLock()
-	path := s.cfg.LogFile
-	s.mu.RUnlock()
-
-	oldPath := path + ".1"
-	_ = os.Remove(oldPath)
-	_ = os.Rename(path, oldPath)
-
-	//nolint:gosec // Path already verified
-	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
-	if err == nil {
-		s.logFile = f
-		log.SetOutput(f)
-	}
+	defer s.mu.RUnlock()
+	v, _ := s.env.Lookup(path)
+	return v
 }
 
-func (s *Server) LogRequest(r *http.Request, status int, start time.Time) {
-	if status == 0 {
-		status = http.StatusOK
-	}
-	ua := strings.TrimSpace(r.UserAgent())
-	duration := time.Since(start).Milliseconds()
-
-	msg := fmt.Sprintf("%s %s status=%d ua=%s ms=%d", r.Method, r.URL.Path, status, ua, duration)
-
-	level := LogLevelInfo
-	if status >= 500 {
-		level = LogLevelError
-	}
-	s.Log(level, msg)
-
-	ip, _, _ := net.SplitHostPort(r.RemoteAddr)
-	if ip == "" {
-		ip = r.RemoteAddr
-	}
-	if ip != "" && ip != "127.0.0.1" && ip != "::1" {
-		if _, seen := s.seenIPs.Load(ip); !seen {
-			s.seenIPs.Store(ip, true)
-			s.Log(LogLevelInfo, fmt.Sprintf("[NEW DEVICE] %s %s", ip, msg))
-		}
-	}
+// ConfigSources reports where each effective config value comes from
+// (JSON path -> "default", "file" or "env") and which variable overrides
+// each env-sourced field.
+func (s *Server) ConfigSources() (sources, envVars map[string]string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return config.FieldSources(s.fileJSON, s.env), s.env.Vars()
 }
 
 func (s *Server) GetPort() int {
@@ -315,13 +292,16 @@ func (s *Server) GetPort() int {
 func (s *Server) InvalidateMediaCache() {
 	s.mediaMu.Lock()
 	s.mediaKey = ""
-	s.mediaETag = ""
+	s.mediaTag = ""
 	s.mediaBuiltAt = time.Time{}
 	s.mediaRespJSON = nil
+	s.mediaCounts = types.ItemCounts{}
 	s.mediaMu.Unlock()
 	_ = os.Remove(s.mediaCachePath)
 }
 
+// GetOrBuildMediaCache returns the media index of shares and its version
+// tag, which changes whenever the index is rebuilt.
 func (s *Server) GetOrBuildMediaCache(ctx context.Context, shares []config.Share, blacklist config.BlacklistConfig, refresh bool) (types.MediaResponse, string) {
 	key := mediaCacheKey(shares, blacklist)
 
@@ -330,13 +310,15 @@ func (s *Server) GetOrBuildMediaCache(ctx context.Context, shares []config.Share
 	if s.mediaKey == key && !s.mediaBuiltAt.IsZero() && !refresh {
 		if time.Since(s.mediaBuiltAt) >= s.mediaTTL && !s.mediaBuilding {
 			s.mediaBuilding = true
-			go s.rebuildMediaCache(context.Background(), key, shares, blacklist, s.cfg.MaxItems)
+			maxItems := s.cfg.MaxItems
+			s.Go(func(ctx context.Context) { s.rebuildMediaCache(ctx, key, shares, blacklist, maxItems) })
 		}
 		var r types.MediaResponse
 		_ = json.Unmarshal(s.mediaRespJSON, &r)
-		etag := s.mediaETag
+		tag := s.mediaTag
 		s.mediaMu.Unlock()
-		return r, etag
+		metrics.MediaCacheRequests.WithLabelValues("hit").Inc()
+		return r, tag
 	}
 
 	// 2. If already building, return current (partial/old) data
@@ -344,35 +326,41 @@ func (s *Server) GetOrBuildMediaCache(ctx context.Context, shares []config.Share
 		var r types.MediaResponse
 		_ = json.Unmarshal(s.mediaRespJSON, &r)
 		r.Scanning = true
-		etag := s.mediaETag
+		tag := s.mediaTag
 		s.mediaMu.Unlock()
-		return r, etag
+		metrics.MediaCacheRequests.WithLabelValues("scanning").Inc()
+		return r, tag
 	}
 
 	// 3. If refresh requested, trigger in background and return what we have
 	if refresh {
 		s.mediaBuilding = true
-		go s.rebuildMediaCache(context.Background(), key, shares, blacklist, s.cfg.MaxItems)
+		maxItems := s.cfg.MaxItems
+		s.Go(func(ctx context.Context) { s.rebuildMediaCache(ctx, key, shares, blacklist, maxItems) })
 		var r types.MediaResponse
 		_ = json.Unmarshal(s.mediaRespJSON, &r)
 		r.Scanning = true
-		etag := s.mediaETag
+		tag := s.mediaTag
 		s.mediaMu.Unlock()
-		return r, etag
+		metrics.MediaCacheRequests.WithLabelValues("scanning").Inc()
+		return r, tag
 	}
 
 	// 4. Try DB if not building and key changed or expired
 	if s.mediaKey != key {
 		s.mediaMu.Unlock()
 		if resp, builtAt, ok, _ := media.LoadMediaFromDB(ctx, key, shares); ok && !builtAt.IsZero() {
-			etag := weakETag(key, builtAt)
+			tag := mediaTag(key, builtAt)
 			s.mediaMu.Lock()
 			s.mediaRespJSON, _ = json.Marshal(resp)
+			s.mediaCounts = countItems(resp)
 			s.mediaKey = key
 			s.mediaBuiltAt = builtAt
-			s.mediaETag = etag
+			s.mediaTag = tag
 			s.mediaMu.Unlock()
-			return resp, etag
+			setItemMetrics(resp)
+			metrics.MediaCacheRequests.WithLabelValues("db").Inc()
+			return resp, tag
 		}
 		s.mediaMu.Lock()
 	}
@@ -380,48 +368,30 @@ func (s *Server) GetOrBuildMediaCache(ctx context.Context, shares []config.Share
 	// 4. Need to build
 	s.mediaBuilding = true
 	s.mediaMu.Unlock()
+	metrics.MediaCacheRequests.WithLabelValues("miss").Inc()
+	return s.rebuildMediaCache(ctx, key, shares, blacklist, s.cfg.MaxItems)
+}
 
-	var resp types.MediaResponse
-	builtAt := time.Now()
-	if db.DB != nil {
-		r, bt, err := media.ReindexAndLoadMedia(ctx, key, shares, blacklist, s.cfg.MaxItems)
-		if err == nil && !bt.IsZero() {
-			resp = r
-			builtAt = bt
-		} else {
-			resp = media.BuildMediaResponse(ctx, shares, blacklist, s.cfg.MaxItems)
-			builtAt = time.Now()
-		}
-	} else {
-		resp = media.BuildMediaResponse(ctx, shares, blacklist, s.cfg.MaxItems)
-	}
-	etag := weakETag(key, builtAt)
-
-	// Serialize to JSON bytes to save memory and serve faster
-	b, _ := json.Marshal(resp)
-
+// RebuildMediaCache re-indexes all shares synchronously and returns the
+// result. It is used by the scan command; requests use GetOrBuildMediaCache.
+func (s *Server) RebuildMediaCache(ctx context.Context) types.MediaResponse {
+	cfg := s.Config()
 	s.mediaMu.Lock()
-	s.mediaRespJSON = b
-	s.mediaKey = key
-	s.mediaBuiltAt = builtAt
-	s.mediaETag = etag
-	s.mediaBuilding = false
-	s.mediaCond.Broadcast()
-	s.mediaMu.Unlock()
-
-	if db.DB == nil {
-		go s.saveMediaCacheToDisk(key, builtAt, etag, resp)
+	for s.mediaBuilding {
+		s.mediaCond.Wait()
 	}
-
-	// Trigger GC after heavy indexing
-	go debug.FreeOSMemory()
-
-	return resp, etag
+	s.mediaBuilding = true
+	s.mediaMu.Unlock()
+	resp, _ := s.rebuildMediaCache(ctx, mediaCacheKey(cfg.Shares, cfg.Blacklist), cfg.Shares, cfg.Blacklist, cfg.MaxItems)
+	return resp
 }
 
-func (s *Server) rebuildMediaCache(ctx context.Context, key string, shares []config.Share, blacklist config.BlacklistConfig, maxItems int) {
+// rebuildMediaCache indexes the shares and caches the result. A scan
+// cancelled through ctx leaves the previous cache in place.
+func (s *Server) rebuildMediaCache(ctx context.Context, key string, shares []config.Share, blacklist config.BlacklistConfig, maxItems int) (types.MediaResponse, string) {
 	var resp types.MediaResponse
-	builtAt := time.Now()
+	start := time.Now()
+	builtAt := start
 	if db.DB != nil {
 		r, bt, err := media.ReindexAndLoadMedia(ctx, key, shares, blacklist, maxItems)
 		if err == nil && !bt.IsZero() {
@@ -434,22 +404,37 @@ func (s *Server) rebuildMediaCache(ctx context.Context, key string, shares []con
 	} else {
 		resp = media.BuildMediaResponse(ctx, shares, blacklist, maxItems)
 	}
-	etag := weakETag(key, builtAt)
+	if ctx.Err() != nil {
+		s.mediaMu.Lock()
+		s.mediaBuilding = false
+		s.mediaCond.Broadcast()
+		tag := s.mediaTag
+		s.mediaMu.Unlock()
+		return resp, tag
+	}
+	tag := mediaTag(key, builtAt)
 	b, _ := json.Marshal(resp)
+	dur := time.Since(start)
 
 	s.mediaMu.Lock()
 	s.mediaRespJSON = b
+	s.mediaCounts = countItems(resp)
 	s.mediaKey = key
 	s.mediaBuiltAt = builtAt
-	s.mediaETag = etag
+	s.mediaTag = tag
+	s.lastScanAt = start
+	s.lastScanDur = dur
 	s.mediaBuilding = false
 	s.mediaCond.Broadcast()
 	s.mediaMu.Unlock()
+	metrics.ScanDuration.Observe(dur.Seconds())
+	setItemMetrics(resp)
 
 	if db.DB == nil {
-		go s.saveMediaCacheToDisk(key, builtAt, etag, resp)
+		s.Go(func(context.Context) { s.saveMediaCacheToDisk(key, builtAt, tag, resp) })
 	}
 	go debug.FreeOSMemory()
+	return resp, tag
 }
 
 func mediaCacheKey(shares []config.Share, blacklist config.BlacklistConfig) string {
@@ -492,7 +477,7 @@ func normRuleList(in []string) []string {
 type mediaCacheOnDisk struct {
 	Key     string              `json:"key"`
 	BuiltAt int64               `json:"builtAt"`
-	ETag    string              `json:"etag"`
+	Tag     string              `json:"tag"`
 	Resp    types.MediaResponse `json:"resp"`
 }
 
@@ -516,30 +501,35 @@ func (s *Server) LoadMediaCacheFromDisk(key string) bool {
 	if err := json.Unmarshal(b, &v); err != nil {
 		return false
 	}
-	if v.Key != key || v.BuiltAt <= 0 {
+	if v.Key != key || v.BuiltAt <= 0 || v.Tag == "" {
 		return false
 	}
 
 	s.mediaMu.Lock()
 	s.mediaKey = v.Key
 	s.mediaBuiltAt = time.Unix(0, v.BuiltAt)
-	s.mediaETag = v.ETag
+	s.mediaTag = v.Tag
 	s.mediaRespJSON, _ = json.Marshal(v.Resp)
+	s.mediaCounts = countItems(v.Resp)
 	s.mediaMu.Unlock()
+	setItemMetrics(v.Resp)
 	return true
 }
 
-func (s *Server) saveMediaCacheToDisk(key string, builtAt time.Time, etag string, resp types.MediaResponse) {
+func (s *Server) saveMediaCacheToDisk(key string, builtAt time.Time, tag string, resp types.MediaResponse) {
 	v := mediaCacheOnDisk{
 		Key:     key,
 		BuiltAt: builtAt.UnixNano(),
-		ETag:    etag,
+		Tag:     tag,
 		Resp:    resp,
 	}
 	b, err := json.Marshal(v)
 	if err != nil {
 		return
 	}
+	if err := os.MkdirAll(filepath.Dir(s.mediaCachePath), 0750); err != nil {
+		return
+	}
 	tmp := s.mediaCachePath + ".tmp"
 	if err := os.WriteFile(tmp, b, 0600); err != nil {
 		return
@@ -565,7 +555,9 @@ func sharesCacheKey(shares []config.Share) string {
 	return b.String()
 }
 
-func weakETag(key string, builtAt time.Time) string {
+// mediaTag returns the version of the media index built at builtAt for key,
+// the opaque part of the /api/media ETag.
+func mediaTag(key string, builtAt time.Time) string {
 	h := fnv.New64a()
 	_, _ = h.Write([]byte(key))
 	var t [8]byte
@@ -576,5 +568,5 @@ func weakETag(key string, builtAt time.Time) string {
 		n >>= 8
 	}
 	_, _ = h.Write(t[:])
-	return `W/` + util.U64Base36(h.Sum64()) + ``
+	return util.U64Base36(h.Sum64())
 }
@@ -1,6 +1,16 @@
 package server
 
-import "testing"
+import (
+	"context"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"msp/internal/config"
+)
 
 func TestServerNew(t *testing.T) {
 	s := New("config.json")
@@ -8,3 +18,167 @@ func TestServerNew(t *testing.T) {
 		t.Fatal("Expected server New to not be nil")
 	}
 }
+
+func TestLoadOrInitConfigMigrates(t *testing.T) {
+	cfgPath := filepath.Join(t.TempDir(), "config.json")
+	old := []byte(`{"port": 9000, "logLevel": "error"}`)
+	if err := os.WriteFile(cfgPath, old, 0600); err != nil {
+		t.Fatal(err)
+	}
+	s := New(cfgPath)
+	if err := s.LoadOrInitConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if c := s.Config(); c.ConfigVersion != config.CurrentVersion || c.Port != 9000 {
+		t.Errorf("config = version %d, port %d", c.ConfigVersion, c.Port)
+	}
+	if b, err := os.ReadFile(cfgPath + ".v0.bak"); err != nil || string(b) != string(old) {
+		t.Errorf("backup = %q, %v", b, err)
+	}
+	b, _ := os.ReadFile(cfgPath)
+	if c, err := config.Parse(b); err != nil || c.ConfigVersion != config.CurrentVersion {
+		t.Errorf("saved config version = %d, %v", c.ConfigVersion, err)
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	cfgPath := filepath.Join(t.TempDir(), "config.json")
+	s := New(cfgPath)
+	if err := s.LoadOrInitConfig(); err != nil {
+		t.Fatal(err)
+	}
+	var ports []int
+	s.OnConfigChange(func(old, cfg config.Config) {
+		if old.Port != cfg.Port {
+			ports = append(ports, cfg.Port)
+		}
+	})
+
+	// The server's own write is not a change.
+	if err := s.UpdateConfig(func(c *config.Config) { c.Port = 9001 }); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if len(ports) != 1 || ports[0] != 9001 {
+		t.Fatalf("after UpdateConfig: ports = %v", ports)
+	}
+
+	b, _ := os.ReadFile(cfgPath)
+	edited := strings.Replace(string(b), `"port": 9001`, `"port": 9002`, 1)
+	if err := os.WriteFile(cfgPath, []byte(edited), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if s.Config().Port != 9002 || len(ports) != 2 {
+		t.Fatalf("after edit: port %d, hooks %v", s.Config().Port, ports)
+	}
+
+	bad := strings.Replace(edited, `"port": 9002`, `"port": 70000`, 1)
+	if err := os.WriteFile(cfgPath, []byte(bad), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReloadConfig(); err == nil {
+		t.Error("Expected invalid port to be rejected")
+	}
+	if err := s.ReloadConfig(); err != nil {
+		t.Errorf("rejected content should be reported once, got %v", err)
+	}
+	if s.Config().Port != 9002 {
+		t.Errorf("invalid reload changed port to %d", s.Config().Port)
+	}
+}
+
+func TestWatchConfig(t *testing.T) {
+	cfgPath := filepath.Join(t.TempDir(), "config.json")
+	s := New(cfgPath)
+	if err := s.LoadOrInitConfig(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.WatchConfig(ctx)
+	time.Sleep(50 * time.Millisecond) // let the watcher start
+
+	b, _ := os.ReadFile(cfgPath)
+	edited := strings.Replace(string(b), `"port": 8099`, `"port": 9100`, 1)
+	if err := os.WriteFile(cfgPath, []byte(edited), 0600); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for s.Config().Port != 9100 {
+		if time.Now().After(deadline) {
+			t.Fatal("config change was not picked up")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestYAMLConfigFile(t *testing.T) {
+	cfgPath := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(cfgPath, []byte("configVersion: 1\n# the web UI port\nport: 9000\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s := New(cfgPath)
+	if err := s.LoadOrInitConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateConfig(func(c *config.Config) { c.Port = 9001 }); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(cfgPath)
+	if !strings.Contains(string(b), "# the web UI port\nport: 9001") {
+		t.Errorf("saved YAML:\n%s", b)
+	}
+	if sources, _ := s.ConfigSources(); sources["port"] != config.SourceFile || sources["maxItems"] != config.SourceFile {
+		t.Errorf("sources: port %q, maxItems %q", sources["port"], sources["maxItems"])
+	}
+}
+
+func TestRebuildMediaCacheCancelled(t *testing.T) {
+	s := New(filepath.Join(t.TempDir(), "config.json"))
+	if err := s.LoadOrInitConfig(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.RebuildMediaCache(ctx)
+	s.mediaMu.Lock()
+	defer s.mediaMu.Unlock()
+	if s.mediaBuilding || s.mediaRespJSON != nil {
+		t.Errorf("cancelled scan was cached (building %v)", s.mediaBuilding)
+	}
+}
+
+func TestIPRulesFollowConfig(t *testing.T) {
+	cfgPath := filepath.Join(t.TempDir(), "config.json")
+	s := New(cfgPath)
+	if err := s.LoadOrInitConfig(); err != nil {
+		t.Fatal(err)
+	}
+	addr := netip.MustParseAddr("10.0.0.1")
+	if err := s.UpdateConfig(func(c *config.Config) { c.Security.IPWhitelist = []string{"10.0.0.0/8"} }); err != nil {
+		t.Fatal(err)
+	}
+	if !s.IPRules().Whitelist.Contains(addr) {
+		t.Error("Expected whitelist to be parsed after UpdateConfig")
+	}
+
+	b, _ := os.ReadFile(cfgPath)
+	edited := strings.Replace(string(b), `"trustedProxies": []`, `"trustedProxies": ["10.0.0.1"]`, 1)
+	if edited == string(b) {
+		t.Fatal("trustedProxies not found in config file")
+	}
+	if err := os.WriteFile(cfgPath, []byte(edited), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if !s.IPRules().TrustedProxies.Contains(addr) {
+		t.Error("Expected trusted proxies to be parsed after reload")
+	}
+}
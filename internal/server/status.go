@@ -0,0 +1,66 @@
+package server
+
+import (
+	"time"
+
+	"msp/internal/metrics"
+	"msp/internal/types"
+)
+
+func countItems(resp types.MediaResponse) types.ItemCounts {
+	return types.ItemCounts{
+		Videos: len(resp.Videos),
+		Audios: len(resp.Audios),
+		Images: len(resp.Images),
+		Others: len(resp.Others),
+	}
+}
+
+func setItemMetrics(resp types.MediaResponse) {
+	c := countItems(resp)
+	metrics.MediaItems.WithLabelValues("video").Set(float64(c.Videos))
+	metrics.MediaItems.WithLabelValues("audio").Set(float64(c.Audios))
+	metrics.MediaItems.WithLabelValues("image").Set(float64(c.Images))
+	metrics.MediaItems.WithLabelValues("other").Set(float64(c.Others))
+}
+
+// ScanStatus describes the media index.
+type ScanStatus struct {
+	Ready        bool             // an index is loaded, from a scan or the database
+	Scanning     bool             // a scan is running
+	LastScan     time.Time        // start of the last completed scan; zero if none finished yet
+	LastDuration time.Duration    // duration of that scan
+	Items        types.ItemCounts // items in the current index
+}
+
+// ScanStatus reports the state of the media index.
+func (s *Server) ScanStatus() ScanStatus {
+	s.mediaMu.Lock()
+	defer s.mediaMu.Unlock()
+	return ScanStatus{
+		Ready:        !s.mediaBuiltAt.IsZero(),
+		Scanning:     s.mediaBuilding,
+		LastScan:     s.lastScanAt,
+		LastDuration: s.lastScanDur,
+		Items:        s.mediaCounts,
+	}
+}
+
+// SetVersion sets the version reported by the status endpoint.
+func (s *Server) SetVersion(v string) {
+	s.mu.Lock()
+	s.version = v
+	s.mu.Unlock()
+}
+
+// Version returns the version set with SetVersion.
+func (s *Server) Version() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.version
+}
+
+// Uptime returns how long the server has been running.
+func (s *Server) Uptime() time.Duration {
+	return time.Since(s.startedAt)
+}
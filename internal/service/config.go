@@ -1,14 +1,23 @@
 package service
 
 import (
+	"errors"
+	"fmt"
 	"msp/internal/config"
 	"msp/internal/media"
 	"msp/internal/server"
 	"msp/internal/util"
-	"path/filepath"
+	"strings"
 	"time"
 )
 
+// ErrInvalidConfig is returned (wrapped) when a submitted config fails validation.
+var ErrInvalidConfig = errors.New("invalid config")
+
+// ErrEnvOverride is returned (wrapped) when a submitted config changes a
+// field that is set by an environment variable.
+var ErrEnvOverride = errors.New("field set by environment")
+
 type ConfigService struct {
 	s *server.Server
 }
@@ -25,21 +34,23 @@ type ConfigView struct {
 	NowUnix          int64         `json:"nowUnix"`
 	FFmpegAvailable  bool          `json:"ffmpegAvailable"`
 	FFprobeAvailable bool          `json:"ffprobeAvailable"`
+
+	// Sources maps JSON paths ("port", "security.pinEnabled") to where the
+	// effective value comes from: "default", "file" or "env".
+	Sources map[string]string `json:"sources"`
+	// EnvOverrides maps env-sourced paths to their variable (MSP_PORT, ...).
+	// These fields are read-only through the API.
+	EnvOverrides map[string]string `json:"envOverrides"`
 }
 
 func (s *ConfigService) GetConfigView() ConfigView {
-	ips := util.GetLanIPv4s()
-	port := s.s.GetPort()
-	urls := make([]string, 0, 2+len(ips))
-	urls = append(urls, "http://127.0.0.1:"+util.Itoa(port)+"/")
-	for _, ip := range ips {
-		urls = append(urls, "http://"+ip+":"+util.Itoa(port)+"/")
-	}
-
+	sources, envVars := s.s.ConfigSources()
 	return ConfigView{
-		Config:           s.s.Config(),
-		LanIPs:           ips,
-		URLs:             urls,
+		Config:           s.s.Config().Redacted(),
+		Sources:          sources,
+		EnvOverrides:     envVars,
+		LanIPs:           util.GetLanIPv4s(),
+		URLs:             s.s.URLs(),
 		NowUnix:          time.Now().Unix(),
 		FFmpegAvailable:  media.CheckFFmpeg(),
 		FFprobeAvailable: media.CheckFFprobe(),
@@ -50,30 +61,46 @@ func (s *ConfigService) UpdateConfig(cfg config.Config) (config.Config, error) {
 	config.ApplyDefaults(&cfg)
 	cfg.Shares = util.NormalizeShares(cfg.Shares)
 
-	validShares := make([]config.Share, 0, len(cfg.Shares))
-	for _, sh := range cfg.Shares {
-		if sh.Path == "" {
-			continue
-		}
-		p := util.NormalizePath(sh.Path)
-		if ok := util.IsExistingDir(p); !ok {
-			continue
-		}
-		sh.Path = p
-		if sh.Label == "" {
-			sh.Label = filepath.Base(p)
+	// Folders that vanished after being shared are kept; new ones must exist.
+	current := s.s.Config()
+	var errs []config.FieldError
+	for i, sh := range cfg.Shares {
+		if !util.IsExistingDir(sh.Path) && !hasSharePath(current.Shares, sh.Path) {
+			errs = append(errs, config.FieldError{Field: fmt.Sprintf("shares[%d].path", i), Message: "folder does not exist or is not accessible"})
 		}
-		validShares = append(validShares, sh)
 	}
-	cfg.Shares = util.DedupeShares(validShares)
+	errs = append(errs, config.FieldErrors(config.Validate(cfg))...)
+	errs = append(errs, config.FieldErrors(config.ValidateShares(cfg.Shares, current.Shares))...)
+	if len(errs) > 0 {
+		return config.Config{}, fmt.Errorf("%w: %w", ErrInvalidConfig, config.ValidationError(errs))
+	}
+	if conflicts := s.s.Env().Conflicts(cfg, current); len(conflicts) > 0 {
+		f := conflicts[0]
+		return config.Config{}, fmt.Errorf("%w: %s 由环境变量 %s 设置，不能修改", ErrEnvOverride, f.Path, f.Var)
+	}
 
 	err := s.s.UpdateConfig(func(c *config.Config) {
+		// Secrets are redacted in responses and never changed here (PINs
+		// are changed via /api/pin/change), so keep the stored ones.
+		cfg.Security.PIN = ""
+		cfg.Security.PINHash = c.Security.PINHash
+		cfg.Security.AdminPIN = ""
+		cfg.Security.AdminPINHash = c.Security.AdminPINHash
+		cfg.Security.LinkSecret = c.Security.LinkSecret
 		*c = cfg
 	})
 	if err != nil {
 		return config.Config{}, err
 	}
 
-	s.s.InvalidateMediaCache()
-	return cfg, nil
+	return cfg.Redacted(), nil
+}
+
+func hasSharePath(shares []config.Share, p string) bool {
+	for _, sh := range shares {
+		if strings.EqualFold(sh.Path, p) {
+			return true
+		}
+	}
+	return false
 }
@@ -1,8 +1,12 @@
 package service
 
 import (
+	"encoding/json"
+	"errors"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 
 	"msp/internal/config"
@@ -45,6 +49,13 @@ func TestConfigService_GetConfigView(t *testing.T) {
 	if !foundLocal {
 		t.Error("Expected localhost URL in view")
 	}
+
+	// URLs follow the listener: scheme, --listen address and served port
+	srv.SetListener("192.0.2.10", true, func() int { return 8443 })
+	view = svc.GetConfigView()
+	if len(view.URLs) != 1 || view.URLs[0] != "https://192.0.2.10:8443/" {
+		t.Errorf("URLs = %v, want [https://192.0.2.10:8443/]", view.URLs)
+	}
 }
 
 func TestConfigService_UpdateConfig(t *testing.T) {
@@ -66,8 +77,18 @@ func TestConfigService_UpdateConfig(t *testing.T) {
 	newCfg.Port = 9000
 	newCfg.Shares = []config.Share{
 		{Label: "Test Share", Path: shareDir},
-		{Label: "Bad Share", Path: "/path/to/nowhere"}, // Should be filtered out
+		{Label: "Bad Share", Path: "/path/to/nowhere"},
+	}
+
+	// A missing folder is reported by its field path
+	_, err := svc.UpdateConfig(newCfg)
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("expected ErrInvalidConfig, got %v", err)
+	}
+	if fields := config.FieldErrors(err); len(fields) != 1 || fields[0].Field != "shares[1].path" {
+		t.Errorf("unexpected field errors: %+v", fields)
 	}
+	newCfg.Shares = newCfg.Shares[:1]
 
 	// Test Update
 	updated, err := svc.UpdateConfig(newCfg)
@@ -80,7 +101,7 @@ func TestConfigService_UpdateConfig(t *testing.T) {
 		t.Errorf("Expected port 9000, got %d", updated.Port)
 	}
 	if len(updated.Shares) != 1 {
-		t.Errorf("Expected 1 valid share, got %d", len(updated.Shares))
+		t.Fatalf("Expected 1 share, got %d", len(updated.Shares))
 	}
 	if updated.Shares[0].Label != "Test Share" {
 		t.Errorf("Expected share label 'Test Share', got %s", updated.Shares[0].Label)
@@ -92,3 +113,143 @@ func TestConfigService_UpdateConfig(t *testing.T) {
 		t.Errorf("Server config not updated, port is %d", current.Port)
 	}
 }
+
+func TestConfigService_Secrets(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfgPath := filepath.Join(tmpDir, "config_test.json")
+	if err := os.WriteFile(cfgPath, []byte(`{"security":{"pinEnabled":true,"pin":"2468"}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	srv := server.New(cfgPath)
+	if err := srv.LoadOrInitConfig(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	// Plaintext PIN is migrated to a hash on disk
+	b, _ := os.ReadFile(cfgPath)
+	if strings.Contains(string(b), "2468") || !strings.Contains(string(b), "$argon2id$") {
+		t.Fatalf("Expected hashed PIN on disk, got %s", b)
+	}
+	hash := srv.Config().Security.PINHash
+
+	// Secrets are redacted from the view
+	svc := NewConfigService(srv)
+	view := svc.GetConfigView()
+	if view.Config.Security.PIN != "" || view.Config.Security.PINHash != "" {
+		t.Error("Expected secrets to be redacted from config view")
+	}
+
+	// Posting the redacted config back (or a new plaintext PIN) keeps the stored hash
+	cfg := view.Config
+	cfg.Security.PIN = "1111"
+	updated, err := svc.UpdateConfig(cfg)
+	if err != nil {
+		t.Fatalf("UpdateConfig failed: %v", err)
+	}
+	if updated.Security.PINHash != "" {
+		t.Error("Expected secrets to be redacted from update response")
+	}
+	if got := srv.Config().Security; got.PINHash != hash || got.PIN != "" {
+		t.Errorf("Expected stored PIN hash to be preserved, got %+v", got)
+	}
+}
+
+func TestConfigService_EnvOverrides(t *testing.T) {
+	t.Setenv("MSP_PORT", "9300")
+	t.Setenv("MSP_SECURITY_PIN", "5555")
+	tmpDir := t.TempDir()
+	cfgPath := filepath.Join(tmpDir, "config_test.json")
+	if err := os.WriteFile(cfgPath, []byte(`{"port":8100,"logLevel":"debug"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	srv := server.New(cfgPath)
+	if err := srv.LoadOrInitConfig(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if srv.Config().Port != 9300 {
+		t.Errorf("Expected env port 9300, got %d", srv.Config().Port)
+	}
+
+	svc := NewConfigService(srv)
+	view := svc.GetConfigView()
+	if view.Sources["port"] != config.SourceEnv || view.Sources["logLevel"] != config.SourceFile || view.EnvOverrides["port"] != "MSP_PORT" {
+		t.Errorf("Unexpected sources %v / %v", view.Sources, view.EnvOverrides)
+	}
+
+	// Changing an env-pinned field is rejected
+	cfg := view.Config
+	cfg.Port = 9400
+	if _, err := svc.UpdateConfig(cfg); !errors.Is(err, ErrEnvOverride) {
+		t.Fatalf("Expected ErrEnvOverride, got %v", err)
+	}
+
+	// Other fields can be saved; env values never reach the file
+	cfg = view.Config
+	cfg.LogLevel = "error"
+	if _, err := svc.UpdateConfig(cfg); err != nil {
+		t.Fatalf("UpdateConfig failed: %v", err)
+	}
+	var onDisk config.Config
+	b, _ := os.ReadFile(cfgPath)
+	if err := json.Unmarshal(b, &onDisk); err != nil {
+		t.Fatal(err)
+	}
+	if onDisk.Port != 8100 || onDisk.LogLevel != "error" || onDisk.Security.PINHash == srv.Config().Security.PINHash {
+		t.Errorf("Expected file values to be kept, got port %d level %q", onDisk.Port, onDisk.LogLevel)
+	}
+	if srv.Config().Port != 9300 {
+		t.Errorf("Expected env port to stay in effect, got %d", srv.Config().Port)
+	}
+}
+
+func TestConfigService_LegacyShareClashes(t *testing.T) {
+	tmpDir := t.TempDir()
+	var dirs []string
+	for _, d := range []string{"a/Movies", "b/Movies", "a/Movies/4K", "c/Movies"} {
+		p := filepath.Join(tmpDir, d)
+		if err := os.MkdirAll(p, 0750); err != nil {
+			t.Fatal(err)
+		}
+		dirs = append(dirs, p)
+	}
+	// Labels generated by older versions clash, and one root is nested
+	cfgPath := filepath.Join(tmpDir, "config.json")
+	b, _ := json.Marshal(map[string]any{"shares": []config.Share{
+		{Label: "Movies", Path: dirs[0]},
+		{Label: "Movies", Path: dirs[1]},
+		{Label: "4K", Path: dirs[2]},
+	}})
+	if err := os.WriteFile(cfgPath, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	srv := server.New(cfgPath)
+	if err := srv.LoadOrInitConfig(); err != nil {
+		t.Fatalf("Expected legacy share clashes to load, got %v", err)
+	}
+	svc := NewConfigService(srv)
+
+	// Saving unrelated settings keeps working
+	cfg := srv.Config()
+	cfg.Port = 9001
+	if _, err := svc.UpdateConfig(cfg); err != nil {
+		t.Fatalf("UpdateConfig with legacy clashes: %v", err)
+	}
+
+	// A new unlabelled "Movies" folder gets a unique label
+	cfg.Shares = append(cfg.Shares, config.Share{Path: dirs[3]})
+	updated, err := svc.UpdateConfig(cfg)
+	if err != nil {
+		t.Fatalf("UpdateConfig adding a share: %v", err)
+	}
+	if got := updated.Shares[3].Label; got != "Movies (2)" {
+		t.Errorf("Expected label %q, got %q", "Movies (2)", got)
+	}
+
+	// A label typed by the user must still be unique
+	cfg = srv.Config()
+	cfg.Shares = slices.Clone(cfg.Shares)
+	cfg.Shares[3].Label = "movies"
+	if _, err := svc.UpdateConfig(cfg); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected duplicate label to be rejected, got %v", err)
+	}
+}
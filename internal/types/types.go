@@ -39,18 +39,98 @@ type MediaScan struct {
 	UpdatedAt time.Time `gorm:"autoUpdateTime"`
 }
 
+// UserPref and the other per-user tables use UserID 0 for the shared
+// profile used by PIN, localhost and anonymous access.
 type UserPref struct {
+	UserID    uint   `gorm:"primaryKey;autoIncrement:false"`
 	Key       string `gorm:"primaryKey"`
 	Value     string
 	UpdatedAt time.Time `gorm:"autoUpdateTime"`
 }
 
 type PlaybackProgress struct {
+	UserID    uint      `json:"-" gorm:"primaryKey;autoIncrement:false"`
 	MediaID   string    `json:"mediaId" gorm:"primaryKey"`
 	Time      float64   `json:"time" gorm:"not null"`
 	UpdatedAt time.Time `json:"updatedAt" gorm:"autoUpdateTime"`
 }
 
+// User is a named account. Its role and groups decide which endpoints and
+// shares it can access.
+type User struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	Name         string    `json:"name" gorm:"uniqueIndex;not null"`
+	PasswordHash string    `json:"-" gorm:"not null"`
+	Role         string    `json:"role" gorm:"not null;default:viewer"`
+	Groups       []string  `json:"groups" gorm:"serializer:json"`
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+type Favorite struct {
+	UserID    uint      `json:"-" gorm:"primaryKey;autoIncrement:false"`
+	MediaID   string    `json:"mediaId" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// HistoryEntry records when a user last played a media item.
+type HistoryEntry struct {
+	UserID   uint      `json:"-" gorm:"primaryKey;autoIncrement:false"`
+	MediaID  string    `json:"mediaId" gorm:"primaryKey"`
+	PlayedAt time.Time `json:"playedAt" gorm:"index"`
+}
+
+// Session is a server-issued login session created after a successful PIN check.
+// Only the SHA-256 hash of the token is stored.
+type Session struct {
+	ID         string    `json:"id" gorm:"primaryKey"`
+	TokenHash  string    `json:"-" gorm:"uniqueIndex;not null"`
+	CSRFToken  string    `json:"-" gorm:"column:csrf_token"`
+	Label      string    `json:"label"`
+	Role       string    `json:"role" gorm:"not null;default:viewer"`
+	UserID     uint      `json:"userId,omitempty" gorm:"index"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	ExpiresAt  time.Time `json:"expiresAt" gorm:"index"`
+	Current    bool      `json:"current,omitempty" gorm:"-"`
+}
+
+// API token scopes.
+const (
+	TokenScopeRead   = "read"   // read-only access to all non-admin endpoints
+	TokenScopeStream = "stream" // media and subtitle streams only
+	TokenScopeAdmin  = "admin"  // full access
+)
+
+// APIToken is a named bearer token for scripts and third-party clients.
+// Only the SHA-256 hash of the secret is stored.
+type APIToken struct {
+	ID         string     `json:"id" gorm:"primaryKey"`
+	Name       string     `json:"name"`
+	Scope      string     `json:"scope" gorm:"not null"`
+	TokenHash  string     `json:"-" gorm:"uniqueIndex;not null"`
+	CreatedBy  uint       `json:"createdBy,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty" gorm:"index"`
+}
+
+// ShareLink is a signed, expiring link to a single file or folder that
+// grants access without a PIN.
+type ShareLink struct {
+	ID            string    `json:"id" gorm:"primaryKey"`
+	MediaID       string    `json:"mediaId" gorm:"not null"` // encoded path of the file or folder
+	IsDir         bool      `json:"isDir"`
+	Label         string    `json:"label"`
+	AllowDownload bool      `json:"allowDownload"`
+	MaxUses       int       `json:"maxUses"` // 0 means unlimited
+	Uses          int       `json:"uses"`
+	CreatedBy     uint      `json:"createdBy,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+	ExpiresAt     time.Time `json:"expiresAt" gorm:"index"`
+}
+
 type MediaResponse struct {
 	Shares      []config.Share `json:"shares"`
 	Videos      []MediaItem    `json:"videos"`
@@ -74,13 +154,16 @@ type ConfigResponse struct {
 }
 
 type ApiError struct {
-	Message string `json:"message"`
+	Message string              `json:"message"`
+	Fields  []config.FieldError `json:"fields,omitempty"` // invalid config fields
 }
 
 type SharesOpRequest struct {
-	Op    string `json:"op"`
-	Label string `json:"label"`
-	Path  string `json:"path"`
+	Op          string   `json:"op"`
+	Label       string   `json:"label"`
+	Path        string   `json:"path"`
+	AllowUsers  []string `json:"allowUsers,omitempty"`
+	AllowGroups []string `json:"allowGroups,omitempty"`
 }
 
 type SharesOpResponse struct {
@@ -96,6 +179,29 @@ type ProbeResponse struct {
 	Error     *ApiError  `json:"error,omitempty"`
 }
 
+// LyricWord is a single word of an enhanced (word-level) LRC line.
+type LyricWord struct {
+	Time float64 `json:"time"`
+	Text string  `json:"text"`
+}
+
+type LyricLine struct {
+	Time        float64     `json:"time"`
+	Text        string      `json:"text"`
+	Translation string      `json:"translation,omitempty"`
+	Words       []LyricWord `json:"words,omitempty"`
+}
+
+type LyricsResponse struct {
+	Source string      `json:"source,omitempty"` // "lrc", "id3" or "vorbis"
+	Synced bool        `json:"synced"`
+	Title  string      `json:"title,omitempty"`
+	Artist string      `json:"artist,omitempty"`
+	Album  string      `json:"album,omitempty"`
+	Lines  []LyricLine `json:"lines"`
+	Error  *ApiError   `json:"error,omitempty"`
+}
+
 type PrefsResponse struct {
 	Prefs map[string]string `json:"prefs"`
 	Error *ApiError         `json:"error,omitempty"`
@@ -105,7 +211,189 @@ type PrefsUpdateRequest struct {
 	Prefs map[string]string `json:"prefs"`
 }
 
+type SessionsResponse struct {
+	Sessions []Session `json:"sessions"`
+	Error    *ApiError `json:"error,omitempty"`
+}
+
+type SessionsOpRequest struct {
+	Op string `json:"op"` // "revoke" or "revokeOthers"
+	ID string `json:"id"`
+}
+
+type LockoutsOpRequest struct {
+	Op string `json:"op"` // "clear"
+	IP string `json:"ip"` // empty clears all lockouts
+}
+
+type PINChangeRequest struct {
+	Role   string `json:"role"` // "viewer" (default) or "admin"
+	OldPIN string `json:"oldPin"`
+	NewPIN string `json:"newPin"`
+}
+
+type LoginRequest struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+	Label    string `json:"label"`
+}
+
+type MeResponse struct {
+	Role  string    `json:"role"`
+	User  *User     `json:"user,omitempty"`
+	Error *ApiError `json:"error,omitempty"`
+}
+
+type UsersResponse struct {
+	Users []User    `json:"users"`
+	Error *ApiError `json:"error,omitempty"`
+}
+
+type UsersOpRequest struct {
+	Op       string   `json:"op"` // "create", "update" or "delete"
+	ID       uint     `json:"id"`
+	Name     string   `json:"name"`
+	Password string   `json:"password"` // required for create, optional for update
+	Role     string   `json:"role"`
+	Groups   []string `json:"groups"`
+}
+
+type FavoritesResponse struct {
+	Favorites []Favorite `json:"favorites"`
+	Error     *ApiError  `json:"error,omitempty"`
+}
+
+type FavoritesOpRequest struct {
+	Op string `json:"op"` // "add" or "remove"
+	ID string `json:"id"`
+}
+
+type HistoryResponse struct {
+	History []HistoryEntry `json:"history"`
+	Error   *ApiError      `json:"error,omitempty"`
+}
+
+type HistoryOpRequest struct {
+	Op string `json:"op"` // "clear"
+}
+
+type LinksResponse struct {
+	Links []ShareLink `json:"links"`
+	Error *ApiError   `json:"error,omitempty"`
+}
+
+type LinksOpRequest struct {
+	Op            string  `json:"op"` // "create" or "revoke"
+	ID            string  `json:"id"` // media or folder ID for create, link ID for revoke
+	Label         string  `json:"label"`
+	ExpiresHours  float64 `json:"expiresHours"` // default 24
+	AllowDownload bool    `json:"allowDownload"`
+	MaxUses       int     `json:"maxUses"`
+}
+
+type TokensResponse struct {
+	Tokens []APIToken `json:"tokens"`
+	Error  *ApiError  `json:"error,omitempty"`
+}
+
+type TokensOpRequest struct {
+	Op          string `json:"op"` // "create" or "revoke"
+	ID          string `json:"id"` // token ID for revoke
+	Name        string `json:"name"`
+	Scope       string `json:"scope"`       // read, stream or admin
+	ExpiresDays int    `json:"expiresDays"` // 0 means no expiry
+}
+
+type TokenCreatedResponse struct {
+	Token  APIToken `json:"token"`
+	Secret string   `json:"secret"` // shown only once
+}
+
+type LinkCreatedResponse struct {
+	Link  ShareLink `json:"link"`
+	Token string    `json:"token"`
+	URL   string    `json:"url"`
+}
+
+type LinkOpenResponse struct {
+	Link  ShareLink   `json:"link"`
+	Items []MediaItem `json:"items"`
+	Error *ApiError   `json:"error,omitempty"`
+}
+
+// Audit event types.
+const (
+	AuditLogin        = "login"
+	AuditLoginFailed  = "login_failed"
+	AuditLogout       = "logout"
+	AuditPINChange    = "pin_change"
+	AuditConfigChange = "config_change"
+	AuditShareAdd     = "share_add"
+	AuditShareRemove  = "share_remove"
+	AuditIPDenied     = "ip_denied"
+	AuditNewDevice    = "new_device"
+	AuditTokenCreate  = "token_create"
+	AuditTokenRevoke  = "token_revoke"
+)
+
+// AuditEvent is a security-relevant event kept in the audit_events table.
+type AuditEvent struct {
+	ID      uint          `json:"id" gorm:"primaryKey"`
+	Time    time.Time     `json:"time" gorm:"index"`
+	Type    string        `json:"type" gorm:"index;not null"`
+	IP      string        `json:"ip,omitempty" gorm:"index"`
+	Actor   string        `json:"actor,omitempty"` // user name, role or "config file"
+	Detail  string        `json:"detail,omitempty"`
+	Changes []FieldChange `json:"changes,omitempty" gorm:"serializer:json"`
+}
+
+// FieldChange is one changed config field; values are JSON-encoded.
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+type AuditResponse struct {
+	Events []AuditEvent `json:"events"`
+	Total  int64        `json:"total"`
+	Error  *ApiError    `json:"error,omitempty"`
+}
+
 type LogRequest struct {
 	Level string `json:"level"`
 	Msg   string `json:"msg"`
 }
+
+// ItemCounts is the number of indexed media items by kind.
+type ItemCounts struct {
+	Videos int `json:"videos"`
+	Audios int `json:"audios"`
+	Images int `json:"images"`
+	Others int `json:"others"`
+}
+
+type StatusResponse struct {
+	Version          string        `json:"version"`
+	UptimeSeconds    int64         `json:"uptimeSeconds"`
+	DBSize           int64         `json:"dbSize"` // bytes, including the write-ahead log
+	Items            ItemCounts    `json:"items"`
+	LastScanAt       *time.Time    `json:"lastScanAt,omitempty"`
+	LastScanSeconds  float64       `json:"lastScanSeconds,omitempty"`
+	Scanning         bool          `json:"scanning"`
+	ActiveTranscodes int           `json:"activeTranscodes"`
+	FFmpeg           ToolStatus    `json:"ffmpeg"`
+	FFprobe          ToolStatus    `json:"ffprobe"`
+	Shares           []ShareStatus `json:"shares"`
+}
+
+type ToolStatus struct {
+	Available bool   `json:"available"`
+	Version   string `json:"version,omitempty"` // first line of "-version"
+}
+
+type ShareStatus struct {
+	Label     string  `json:"label"`
+	Path      string  `json:"path"`
+	FreeBytes *uint64 `json:"freeBytes"` // null if the folder is unreachable
+}
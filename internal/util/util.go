@@ -1,7 +1,10 @@
 package util
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"net"
 	"os"
@@ -115,7 +118,17 @@ func MustExeDir() string {
 	return filepath.Dir(exe)
 }
 
+// NormalizeShares cleans share paths, drops empty ones and names unlabelled
+// shares after their folder. Generated labels get a " (2)", " (3)"… suffix
+// when the folder name is already taken, so two "Movies" folders do not
+// clash on a label the user never typed.
 func NormalizeShares(in []config.Share) []config.Share {
+	taken := map[string]bool{}
+	for _, sh := range in {
+		if lbl := strings.TrimSpace(sh.Label); lbl != "" && NormalizePath(sh.Path) != "" {
+			taken[strings.ToLower(lbl)] = true
+		}
+	}
 	out := make([]config.Share, 0, len(in))
 	for _, sh := range in {
 		p := NormalizePath(sh.Path)
@@ -124,9 +137,15 @@ func NormalizeShares(in []config.Share) []config.Share {
 		}
 		lbl := strings.TrimSpace(sh.Label)
 		if lbl == "" {
-			lbl = filepath.Base(p)
+			base := filepath.Base(p)
+			lbl = base
+			for n := 2; taken[strings.ToLower(lbl)]; n++ {
+				lbl = base + " (" + strconv.Itoa(n) + ")"
+			}
+			taken[strings.ToLower(lbl)] = true
 		}
-		out = append(out, config.Share{Label: lbl, Path: p})
+		sh.Label, sh.Path = lbl, p
+		out = append(out, sh)
 	}
 	return out
 }
@@ -136,6 +155,19 @@ func IsExistingDir(p string) bool {
 	return err == nil && st.IsDir()
 }
 
+// SharesFor returns the shares a user may access (see config.Share.Allows).
+func SharesFor(shares []config.Share, user string, groups []string) []config.Share {
+	out := make([]config.Share, 0, len(shares))
+	for _, sh := range shares {
+		if sh.Allows(user, groups) {
+			out = append(out, sh)
+		}
+	}
+	return out
+}
+
+// IsAllowedFile reports whether fileAbs is an existing file inside one of
+// shares. Callers pass the shares visible to the requesting user (SharesFor).
 func IsAllowedFile(fileAbs string, shares []config.Share) bool {
 	if fileAbs == "" {
 		return false
@@ -262,3 +294,18 @@ func DedupeStrings(in []string) []string {
 	}
 	return out
 }
+
+// NewToken returns a URL-safe random token with n bytes of entropy.
+func NewToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the hex SHA-256 of a token, used for storing secrets at rest.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -3,6 +3,8 @@ package util
 import (
 	"strings"
 	"testing"
+
+	"msp/internal/config"
 )
 
 func TestEncodeDecodeID(t *testing.T) {
@@ -26,6 +28,22 @@ func TestNormalizePath(t *testing.T) {
 	}
 }
 
+func TestNormalizeSharesLabels(t *testing.T) {
+	shares := NormalizeShares([]config.Share{
+		{Path: "/a/Movies"},
+		{Path: "/b/Movies"},
+		{Path: "/c/movies"},
+		{Label: "Movies (2)", Path: "/d/Films"},
+	})
+	var got []string
+	for _, sh := range shares {
+		got = append(got, sh.Label)
+	}
+	if want := "Movies Movies (3) movies (4) Movies (2)"; strings.Join(got, " ") != want {
+		t.Errorf("labels = %q, want %q", strings.Join(got, " "), want)
+	}
+}
+
 func TestParseSize(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -95,7 +95,8 @@ function Build-Go {
     $env:CGO_ENABLED = "0"
     if ($GOARM) { $env:GOARM = $GOARM } else { Remove-Item Env:GOARM -ErrorAction SilentlyContinue }
     New-Dir ([System.IO.Path]::GetDirectoryName($OutPath))
-    & go build -trimpath -ldflags="-s -w" -o $OutPath ./cmd/msp
+    $version = if ($env:MSP_VERSION) { $env:MSP_VERSION } else { "dev" }
+    & go build -trimpath -ldflags="-s -w -X main.version=$version" -o $OutPath ./cmd/msp
     if ($LASTEXITCODE -ne 0) { throw ("go build failed. exitCode=" + $LASTEXITCODE) }
     Write-Log ("Built: " + $OutPath) 'INFO'
   }
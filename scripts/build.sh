@@ -60,7 +60,7 @@ build_go() {
       unset GOARM || true
     fi
     new_dir "$(dirname "$out")"
-    go build -trimpath -ldflags="-s -w" -o "$out" ./cmd/msp
+    go build -trimpath -ldflags="-s -w -X main.version=${MSP_VERSION:-dev}" -o "$out" ./cmd/msp
     log "Built: $out" "INFO"
   )
 }
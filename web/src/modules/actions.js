@@ -26,6 +26,7 @@ export async function loadConfig() {
     if (blFiles) blFiles.value = (bl.filenames || []).join(", ");
     if (blFolders) blFolders.value = (bl.folders || []).join(", ");
     if (blMinSize) blMinSize.value = bl.sizeRule || "";
+    lockEnvFields(data.envOverrides || {});
   } catch (e) {
     console.error("Failed to load config:", e);
     setMeta(t("meta_fail"));
@@ -35,6 +36,25 @@ export async function loadConfig() {
   }
 }
 
+// Fields set by MSP_* environment variables are read-only in the UI.
+function lockEnvFields(env) {
+  const fields = {
+    shares: ["sharePath", "shareLabel", "btnAddShare"],
+    "blacklist.extensions": ["blExts"],
+    "blacklist.filenames": ["blFiles"],
+    "blacklist.folders": ["blFolders"],
+    "blacklist.sizeRule": ["blMinSize"],
+  };
+  for (const [path, ids] of Object.entries(fields)) {
+    for (const id of ids) {
+      const node = el(id);
+      if (!node) continue;
+      node.disabled = !!env[path];
+      node.title = env[path] ? t("env_locked", env[path]) : "";
+    }
+  }
+}
+
 export async function loadMedia(refresh, limit) {
   const isLimitedRequest = Number(limit || 0) > 0;
 
@@ -13,23 +13,42 @@ export async function apiRetry(fn, retries = 3, delay = 1000) {
   }
 }
 
+// apiError builds an Error from a failed response, listing any invalid
+// config fields the server reported.
+function apiError(res, data) {
+  const err = data?.error;
+  let msg = err?.message || `${res.status} ${res.statusText}`;
+  if (err?.fields?.length) msg += "\n" + err.fields.map(f => `${f.field}: ${f.message}`).join("\n");
+  return new Error(msg);
+}
+
 export async function apiGet(url) {
   const res = await fetch(url, { cache: "no-store" });
   const data = await res.json().catch(() => ({}));
-  if (!res.ok) throw new Error(data?.error?.message || `${res.status} ${res.statusText}`);
+  if (!res.ok) throw apiError(res, data);
   if (data?.error?.message) throw new Error(data.error.message);
   return data;
 }
 
+// csrfToken returns the session's CSRF token, which the server sets in a
+// script-readable cookie and expects back on every POST.
+function csrfToken() {
+  const m = document.cookie.match(/(?:^|;\s*)msp_csrf=([^;]*)/);
+  return m ? decodeURIComponent(m[1]) : "";
+}
+
 export async function apiPost(url, body) {
+  const headers = { "Content-Type": "application/json" };
+  const token = csrfToken();
+  if (token) headers["X-CSRF-Token"] = token;
   const res = await fetch(url, {
     method: "POST",
-    headers: { "Content-Type": "application/json" },
+    headers,
     body: JSON.stringify(body),
   });
   if (res.status === 204) return null;
   const data = await res.json().catch(() => ({}));
-  if (!res.ok) throw new Error(data?.error?.message || `${res.status} ${res.statusText}`);
+  if (!res.ok) throw apiError(res, data);
   if (data?.error?.message) throw new Error(data.error.message);
   return data;
 }
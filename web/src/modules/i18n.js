@@ -73,6 +73,7 @@ export const I18N = {
     pin_title: "Authentication Required",
     pin_note: "Please enter the PIN code to access this service.",
     pin_placeholder: "Enter PIN",
+    pin_user_placeholder: "Username (optional)",
     pin_submit: "Submit",
     pin_error: "Incorrect PIN. Please try again.",
     pin_checking: "Verifying...",
@@ -90,6 +91,7 @@ export const I18N = {
     err_video_load: "Video load/decode failed ({0}, {1}). Browser may not support codec. {2}Try 'Open Raw' or transcode.",
     err_img_load: "Image load failed ({0}). Try 'Open Raw'.",
     meta_fail: "Connection failed or init failed",
+    env_locked: "Set by environment variable {0}",
   },
   zh: {
     title: "MSP 媒体分享预览",
@@ -171,11 +173,13 @@ export const I18N = {
     err_video_load: "视频加载/解码失败（{0}，{1}）。同为 mp4/mkv 也可能因编码不同而无法播放。{2}建议用“在新标签打开”，或转码为 H.264/AAC（或仅转音频为 AAC）再播放。",
     err_img_load: "图片加载失败（{0}）。可用“在新标签打开”查看原文件。",
     meta_fail: "服务连接失败或初始化失败",
+    env_locked: "由环境变量 {0} 设置",
 
     // PIN Authentication
     pin_title: "身份验证",
     pin_note: "请输入 PIN 码以访问此服务。",
     pin_placeholder: "输入 PIN 码",
+    pin_user_placeholder: "用户名（可选）",
     pin_submit: "提交",
     pin_error: "PIN 码错误，请重试。",
     pin_checking: "验证中...",
@@ -41,6 +41,12 @@ export function renderLyrics(lines) {
     div.className = "ly";
     div.dataset.t = String(ln.t);
     div.textContent = ln.text || "";
+    if (ln.tr) {
+      const tr = document.createElement("div");
+      tr.className = "ly-tr";
+      tr.textContent = ln.tr;
+      div.appendChild(tr);
+    }
     frag.appendChild(div);
   }
   box.appendChild(frag);
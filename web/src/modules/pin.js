@@ -15,6 +15,7 @@ export function showPinDialog() {
   el("pinDlgTitle").textContent = t("pin_title");
   el("pinDlgNote").textContent = t("pin_note");
   input.placeholder = t("pin_placeholder");
+  el("pinUser").placeholder = t("pin_user_placeholder");
   el("btnSubmitPin").textContent = t("pin_submit");
   errorEl.textContent = "";
 
@@ -29,9 +30,12 @@ export function hidePinDialog() {
   el("pinError").textContent = "";
 }
 
-export async function verifyPin(pin) {
+// verifyPin checks the PIN, or signs in as a user when a name is given.
+export async function verifyPin(pin, name = "") {
   try {
-    const data = await apiPost("/api/pin", { pin });
+    const data = name
+      ? await apiPost("/api/login", { name, password: pin })
+      : await apiPost("/api/pin", { pin });
     return data.valid === true;
   } catch (e) {
     console.error("PIN verification failed:", e);
@@ -73,7 +77,7 @@ export function bindPinDialog() {
     submitBtn.textContent = t("pin_checking");
     errorEl.textContent = "";
 
-    const valid = await verifyPin(pin);
+    const valid = await verifyPin(pin, el("pinUser").value.trim());
 
     if (valid) {
       hidePinDialog();
@@ -2,7 +2,7 @@ import { state, el, lsGet, LS } from './state.js';
 import { t } from './i18n.js';
 import { gpGet, gpSet, logRemote, apiPost, probeItem, probeText, probeWarnText, mediaErrorText, rememberEnabled, reportProgress, getProgress } from './api.js';
 import { mimeFor, canPlayMedia, streamUrl, formatName, formatBytes, formatTime, getCfg } from './utils.js';
-import { resetLyrics, renderLyrics, parseLrc, updateLyricsByTime } from './lyrics.js';
+import { resetLyrics, renderLyrics, updateLyricsByTime } from './lyrics.js';
 import { setPlaylist, renderPlaylist, buildPlaylist, updateNavLabels, updateNavButtons, playAtIndex } from './playlist.js';
 
 export function getActiveMedia() {
@@ -808,18 +808,22 @@ export function playItem(item, opts) {
       }
     }
 
-    if (item.lyricsId) {
-      fetch(streamUrl(item.lyricsId))
-        .then(r => r.ok ? r.text() : "")
-        .then(txt => {
-          if (token !== state.selectionToken) return;
-          const lines = parseLrc(txt);
-          state.lyrics = { lines, activeIndex: -1 };
-          renderLyrics(lines);
-          requestAnimationFrame(() => updateLyricsByTime(audio.currentTime || 0, true));
-        })
-        .catch(() => { });
-    }
+    fetch(`/api/lyrics?id=${encodeURIComponent(item.id)}`, { cache: "no-store" })
+      .then(r => r.ok ? r.json() : null)
+      .then(data => {
+        if (token !== state.selectionToken) return;
+        if (!data) {
+          if (item.lyricsId) renderLyrics([]);
+          return;
+        }
+        const lines = (data.lines || []).map(ln => ({ t: ln.time, text: ln.text, tr: ln.translation || "" }));
+        renderLyrics(lines);
+        // Unsynced (plain-text) lyrics are shown statically without highlighting
+        if (!data.synced) return;
+        state.lyrics = { lines, activeIndex: -1 };
+        requestAnimationFrame(() => updateLyricsByTime(audio.currentTime || 0, true));
+      })
+      .catch(() => { });
 
     return;
   }